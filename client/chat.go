@@ -0,0 +1,152 @@
+// chat.go
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+	"github.com/gorilla/websocket"
+	"growth-protocol"
+)
+
+// Enter opens the chat box in the bottom left corner and sends what's typed,
+// or closes the box if nothing was. Tab switches between global and local
+// chat, and "/w name message" whispers to a player. Other keys are ignored
+// while typing. Recent lines disappear after a while unless the box is open.
+const (
+	maxChatLines     = 100
+	shownChatLines   = 8
+	chatLineTime     = 15 * time.Second // how long a line is shown with the box closed
+	announcementTime = 8 * time.Second  // how long an announcement is shown across the top
+)
+
+type receivedLine struct {
+	protocol.ChatLine
+	at time.Time
+}
+
+// Lines arrive on the network goroutine and are drawn on the main one
+var (
+	chatLines    []receivedLine
+	announcement receivedLine // the latest announcement made while we're connected
+	chatLock     sync.Mutex   // guards chatLines and announcement
+)
+
+var (
+	chatTyping  = false
+	chatInput   []rune
+	chatChannel = protocol.ChannelGlobal
+)
+
+var chatColors = map[string]rl.Color{
+	protocol.ChannelGlobal:  rl.RayWhite,
+	protocol.ChannelLocal:   rl.Lime,
+	protocol.ChannelWhisper: rl.Pink,
+	protocol.ChannelSystem:  rl.Gold,
+}
+
+func receiveChat(msg *protocol.ChatLinesMessage) {
+	chatLock.Lock()
+	defer chatLock.Unlock()
+	for _, line := range msg.Lines {
+		chatLines = append(chatLines, receivedLine{ChatLine: line, at: time.Now()})
+		// Announcements that arrive while we're connected are also shown across the top
+		if line.Channel == protocol.ChannelSystem && !msg.History {
+			announcement = receivedLine{ChatLine: line, at: time.Now()}
+		}
+	}
+	if len(chatLines) > maxChatLines {
+		chatLines = chatLines[len(chatLines)-maxChatLines:]
+	}
+}
+
+// Handles typing in the chat box. Returns whether the box has the keyboard.
+func handleChatKeys(wsConn *websocket.Conn) bool {
+	if !chatTyping {
+		if rl.IsKeyPressed(rl.KeyEnter) && loggedIn {
+			chatTyping = true
+			chatInput = chatInput[:0]
+		}
+		return chatTyping
+	}
+	for char := rl.GetCharPressed(); char != 0; char = rl.GetCharPressed() {
+		if len(chatInput) < protocol.MaxChatLength {
+			chatInput = append(chatInput, char)
+		}
+	}
+	if (rl.IsKeyPressed(rl.KeyBackspace) || rl.IsKeyPressedRepeat(rl.KeyBackspace)) && len(chatInput) > 0 {
+		chatInput = chatInput[:len(chatInput)-1]
+	}
+	if rl.IsKeyPressed(rl.KeyTab) {
+		if chatChannel == protocol.ChannelGlobal {
+			chatChannel = protocol.ChannelLocal
+		} else {
+			chatChannel = protocol.ChannelGlobal
+		}
+	}
+	if rl.IsKeyPressed(rl.KeyEnter) {
+		sendChat(wsConn, string(chatInput))
+		chatTyping = false
+	}
+	return true
+}
+
+func sendChat(wsConn *websocket.Conn, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" || connectionStatus != "Connected" {
+		return
+	}
+	msg := protocol.NewChat(chatChannel, "", text)
+	if rest, ok := strings.CutPrefix(text, "/w "); ok {
+		to, whisper, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		msg = protocol.NewChat(protocol.ChannelWhisper, to, strings.TrimSpace(whisper))
+	}
+	err := sendMessage(wsConn, trackRequest(msg))
+	if err != nil {
+		log.Println("Error sending chat message:", err)
+	}
+}
+
+func chatLineText(line protocol.ChatLine) string {
+	switch line.Channel {
+	case protocol.ChannelSystem:
+		return line.Text
+	case protocol.ChannelLocal:
+		return "[local] " + line.From + ": " + line.Text
+	case protocol.ChannelWhisper:
+		return "[" + line.From + " to " + line.To + "] " + line.Text
+	}
+	return line.From + ": " + line.Text
+}
+
+// Draws the recent lines above the input, bottom up
+func drawChat() {
+	chatLock.Lock()
+	defer chatLock.Unlock()
+	bottom := int32(rl.GetScreenHeight()) - 30
+	if chatTyping {
+		prompt := "[" + chatChannel + "] > " + string(chatInput) + "_"
+		rl.DrawRectangle(6, bottom-4, 600, 26, rl.Fade(rl.Black, 0.6))
+		rl.DrawText(prompt, 10, bottom, 20, chatColors[chatChannel])
+	}
+	shown := 0
+	for i := len(chatLines) - 1; i >= 0 && shown < shownChatLines; i-- {
+		line := chatLines[i]
+		if !chatTyping && time.Since(line.at) > chatLineTime {
+			break
+		}
+		shown++
+		rl.DrawText(chatLineText(line.ChatLine), 10, bottom-int32(24*shown), 20, chatColors[line.Channel])
+	}
+}
+
+func drawAnnouncement() {
+	chatLock.Lock()
+	defer chatLock.Unlock()
+	if announcement.Text != "" && time.Since(announcement.at) < announcementTime {
+		rl.DrawText(announcement.Text, 10, 115, 20, rl.Gold)
+	}
+}
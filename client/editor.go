@@ -0,0 +1,359 @@
+// editor.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+	"github.com/gorilla/websocket"
+	"growth-protocol"
+)
+
+// Tab switches editor mode on and off. In editor mode the left mouse button
+// paints the palette entry picked from the bar at the bottom of the screen,
+// using the brush (Z), a line (X), a rectangle (C) or a flood fill (F). V
+// switches the brush between a square and a circle and the mouse wheel
+// changes its size. Edits go to the server in batched editTiles requests,
+// and it skips any tile the player isn't allowed to change.
+const (
+	toolBrush = iota
+	toolLine
+	toolRectangle
+	toolFill
+)
+
+var toolNames = []string{"brush", "line", "rectangle", "fill"}
+
+const (
+	maxBrushSize      = 16
+	editFlushInterval = 200 * time.Millisecond // how often a brush stroke is sent while the mouse is held
+	altitudeStep      = 0.02
+	swatchSize        = 36
+)
+
+// A palette entry either sets tiles to a type or raises or lowers them
+type paletteEntry struct {
+	name     string
+	tileType int
+	altitude float64
+}
+
+var palette = []paletteEntry{
+	{"water", protocol.ShallowWater, 0},
+	{"sand", protocol.Sand, 0},
+	{"grass", protocol.Grass, 0},
+	{"forest", protocol.Forest, 0},
+	{"dirt", protocol.Dirt, 0},
+	{"mountains", protocol.Mountains, 0},
+	{"raise", protocol.LeaveTile, altitudeStep},
+	{"lower", protocol.LeaveTile, -altitudeStep},
+}
+
+var (
+	raiseColor = rl.NewColor(255, 255, 255, 120)
+	lowerColor = rl.NewColor(0, 0, 0, 120)
+)
+
+var (
+	editorMode    = false
+	editorTool    = toolBrush
+	brushCircle   = false
+	brushSize     = 1
+	selectedPaint = 2 // index into palette
+
+	// The tiles painted by the stroke in progress that haven't been sent yet
+	pendingEdits = make(map[[2]int]bool)
+	stroking     = false
+	strokeStartX int
+	strokeStartY int
+	lastBrushX   int
+	lastBrushY   int
+	lastFlush    time.Time
+)
+
+func handleEditorKeys() {
+	if rl.IsKeyPressed(rl.KeyTab) {
+		editorMode = !editorMode
+		selectedStructure = -1
+		stroking = false
+		clear(pendingEdits)
+	}
+	if !editorMode || ctrlDown() {
+		return
+	}
+	switch {
+	case rl.IsKeyPressed(rl.KeyZ):
+		editorTool = toolBrush
+	case rl.IsKeyPressed(rl.KeyX):
+		editorTool = toolLine
+	case rl.IsKeyPressed(rl.KeyC):
+		editorTool = toolRectangle
+	case rl.IsKeyPressed(rl.KeyF):
+		editorTool = toolFill
+	case rl.IsKeyPressed(rl.KeyV):
+		brushCircle = !brushCircle
+	}
+	if wheel := rl.GetMouseWheelMove(); wheel != 0 {
+		brushSize = min(max(brushSize+int(wheel), 1), maxBrushSize)
+	}
+}
+
+func ctrlDown() bool {
+	return rl.IsKeyDown(rl.KeyLeftControl) || rl.IsKeyDown(rl.KeyRightControl)
+}
+
+func shiftDown() bool {
+	return rl.IsKeyDown(rl.KeyLeftShift) || rl.IsKeyDown(rl.KeyRightShift)
+}
+
+// Paints with the current tool while the left mouse button is held, sending
+// brush strokes as they go and lines and rectangles once the button is let go
+func handleEditorMouse(wsConn *websocket.Conn, tiles *[tilesWide][tilesHigh]int) {
+	x, y := mouseTile()
+	if rl.IsMouseButtonPressed(rl.MouseLeftButton) {
+		if index, ok := swatchUnderMouse(); ok {
+			selectedPaint = index
+			return
+		}
+		if editorTool == toolFill {
+			floodFill(tiles, x, y)
+			flushEdits(wsConn, tiles)
+			return
+		}
+		stroking = true
+		strokeStartX, strokeStartY = x, y
+		lastBrushX, lastBrushY = x, y
+		lastFlush = time.Now()
+	}
+	if !stroking {
+		return
+	}
+	switch editorTool {
+	case toolBrush:
+		stampLine(lastBrushX, lastBrushY, x, y)
+		lastBrushX, lastBrushY = x, y
+		if time.Since(lastFlush) >= editFlushInterval {
+			flushEdits(wsConn, tiles)
+		}
+	case toolLine:
+		clear(pendingEdits)
+		stampLine(strokeStartX, strokeStartY, x, y)
+	case toolRectangle:
+		clear(pendingEdits)
+		for i := min(strokeStartX, x); i <= max(strokeStartX, x); i++ {
+			for j := min(strokeStartY, y); j <= max(strokeStartY, y); j++ {
+				addEdit(i, j)
+			}
+		}
+	}
+	if rl.IsMouseButtonReleased(rl.MouseLeftButton) {
+		stroking = false
+		flushEdits(wsConn, tiles)
+	}
+}
+
+func addEdit(x, y int) {
+	if x >= 0 && x < tilesWide && y >= 0 && y < tilesHigh {
+		pendingEdits[[2]int{x, y}] = true
+	}
+}
+
+// The tiles covered by the brush centered on (x, y)
+func brushTiles(x, y int) [][2]int {
+	covered := [][2]int{}
+	radius := float64(brushSize) / 2
+	for dx := -(brushSize - 1) / 2; dx <= brushSize/2; dx++ {
+		for dy := -(brushSize - 1) / 2; dy <= brushSize/2; dy++ {
+			if brushCircle && float64(dx*dx+dy*dy) > radius*radius {
+				continue
+			}
+			covered = append(covered, [2]int{x + dx, y + dy})
+		}
+	}
+	return covered
+}
+
+// Stamps the brush along a Bresenham line, so fast mouse movements don't leave gaps
+func stampLine(x0, y0, x1, y1 int) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	stepX, stepY := 1, 1
+	if x0 > x1 {
+		stepX = -1
+	}
+	if y0 > y1 {
+		stepY = -1
+	}
+	err := dx + dy
+	for {
+		for _, tile := range brushTiles(x0, y0) {
+			addEdit(tile[0], tile[1])
+		}
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if 2*err >= dy {
+			err += dy
+			x0 += stepX
+		}
+		if 2*err <= dx {
+			err += dx
+			y0 += stepY
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Fills the tiles of the same type connected to (x, y). The fill stays within
+// a box around the starting tile so it fits in a single request.
+func floodFill(tiles *[tilesWide][tilesHigh]int, x, y int) {
+	if x < 0 || x >= tilesWide || y < 0 || y >= tilesHigh {
+		return
+	}
+	target := tiles[x][y]
+	left, top := x-protocol.MaxEditSize/2, y-protocol.MaxEditSize/2
+	queue := [][2]int{{x, y}}
+	seen := map[[2]int]bool{{x, y}: true}
+	for len(queue) > 0 {
+		tile := queue[0]
+		queue = queue[1:]
+		addEdit(tile[0], tile[1])
+		for _, step := range [][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+			next := [2]int{tile[0] + step[0], tile[1] + step[1]}
+			if seen[next] || next[0] < max(left, 0) || next[0] >= min(left+protocol.MaxEditSize, tilesWide) ||
+				next[1] < max(top, 0) || next[1] >= min(top+protocol.MaxEditSize, tilesHigh) || tiles[next[0]][next[1]] != target {
+				continue
+			}
+			seen[next] = true
+			queue = append(queue, next)
+		}
+	}
+}
+
+// Sends the pending edits, split into requests no bigger than the server allows.
+// Tiles that are already the type being painted are left out.
+func flushEdits(wsConn *websocket.Conn, tiles *[tilesWide][tilesHigh]int) {
+	lastFlush = time.Now()
+	if len(pendingEdits) == 0 {
+		return
+	}
+	paint := palette[selectedPaint]
+	minX, minY, maxX, maxY := tilesWide, tilesHigh, -1, -1
+	for tile := range pendingEdits {
+		minX, minY = min(minX, tile[0]), min(minY, tile[1])
+		maxX, maxY = max(maxX, tile[0]), max(maxY, tile[1])
+	}
+	for chunkX := minX; chunkX <= maxX; chunkX += protocol.MaxEditSize {
+		for chunkY := minY; chunkY <= maxY; chunkY += protocol.MaxEditSize {
+			width := min(protocol.MaxEditSize, maxX-chunkX+1)
+			height := min(protocol.MaxEditSize, maxY-chunkY+1)
+			types := make([]int, width*height)
+			altitude := make([]float64, width*height)
+			edited := false
+			for i := 0; i < width; i++ {
+				for j := 0; j < height; j++ {
+					index := i*height + j
+					types[index] = protocol.LeaveTile
+					x, y := chunkX+i, chunkY+j
+					if !pendingEdits[[2]int{x, y}] || (paint.altitude == 0 && tiles[x][y] == paint.tileType) {
+						continue
+					}
+					types[index] = paint.tileType
+					altitude[index] = paint.altitude
+					edited = true
+				}
+			}
+			if !edited || connectionStatus != "Connected" {
+				continue
+			}
+			var msg protocol.Message = protocol.NewEditTypes(chunkX, chunkY, width, height, types)
+			if paint.altitude != 0 {
+				msg = protocol.NewEditAltitude(chunkX, chunkY, width, height, altitude)
+			}
+			err := sendMessage(wsConn, trackRequest(msg))
+			if err != nil {
+				log.Println("Error sending editTiles message:", err)
+			}
+		}
+	}
+	clear(pendingEdits)
+}
+
+// The palette is a row of swatches along the bottom of the screen
+func swatchRect(index int) rl.Rectangle {
+	width := float32(len(palette) * (swatchSize + 6))
+	left := (float32(rl.GetScreenWidth())-width)/2 + float32(index*(swatchSize+6))
+	top := float32(rl.GetScreenHeight() - swatchSize - 24)
+	return rl.NewRectangle(left, top, swatchSize, swatchSize)
+}
+
+func swatchUnderMouse() (int, bool) {
+	for i := range palette {
+		if rl.CheckCollisionPointRec(rl.GetMousePosition(), swatchRect(i)) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func paintColor(entry paletteEntry) rl.Color {
+	switch {
+	case entry.altitude > 0:
+		return raiseColor
+	case entry.altitude < 0:
+		return lowerColor
+	}
+	color := tileColors[entry.tileType]
+	color.A = 160
+	return color
+}
+
+// Draws the pending edits, the brush under the mouse and the palette
+func drawEditor() {
+	if !editorMode {
+		return
+	}
+	paint := palette[selectedPaint]
+	color := paintColor(paint)
+	for tile := range pendingEdits {
+		screenX := (float32(tile[0]) - cameraX) * configuration.TileSizeX
+		screenY := (float32(tile[1]) - cameraY) * configuration.TileSizeY
+		rl.DrawRectangle(int32(screenX), int32(screenY), int32(configuration.TileSizeX), int32(configuration.TileSizeY), color)
+	}
+	if !stroking && editorTool != toolFill {
+		x, y := mouseTile()
+		for _, tile := range brushTiles(x, y) {
+			screenX := (float32(tile[0]) - cameraX) * configuration.TileSizeX
+			screenY := (float32(tile[1]) - cameraY) * configuration.TileSizeY
+			rl.DrawRectangleLines(int32(screenX), int32(screenY), int32(configuration.TileSizeX), int32(configuration.TileSizeY), rl.RayWhite)
+		}
+	}
+
+	for i, entry := range palette {
+		rect := swatchRect(i)
+		swatch := paintColor(entry)
+		swatch.A = 255
+		rl.DrawRectangleRec(rect, swatch)
+		outline := rl.DarkGray
+		if i == selectedPaint {
+			outline = rl.Gold
+		}
+		rl.DrawRectangleLinesEx(rect, 3, outline)
+		nameWidth := rl.MeasureText(entry.name, 10)
+		rl.DrawText(entry.name, int32(rect.X+rect.Width/2)-nameWidth/2, int32(rect.Y+rect.Height)+4, 10, rl.RayWhite)
+	}
+
+	shape := "square"
+	if brushCircle {
+		shape = "circle"
+	}
+	text := fmt.Sprintf("Editing %s with the %s, %s brush size %d (Z/X/C/F tools, V shape, wheel size, Tab to leave)", paint.name, toolNames[editorTool], shape, brushSize)
+	rl.DrawText(text, 10, 140, 20, rl.RayWhite)
+}
@@ -5,6 +5,7 @@ go 1.23.2
 require (
 	github.com/gen2brain/raylib-go/raylib v0.0.0-20241019150900-b7833eeae8d0
 	github.com/gorilla/websocket v1.5.3
+	growth-protocol v0.0.0
 )
 
 require (
@@ -12,3 +13,5 @@ require (
 	golang.org/x/exp v0.0.0-20240506185415-9bf2ced13842 // indirect
 	golang.org/x/sys v0.20.0 // indirect
 )
+
+replace growth-protocol => ../protocol
@@ -7,10 +7,12 @@ import (
 	"log"
 	"math"
 	"os"
+	"sync"
 	"time"
 
 	rl "github.com/gen2brain/raylib-go/raylib"
 	"github.com/gorilla/websocket"
+	"growth-protocol"
 )
 
 type Config struct {
@@ -21,6 +23,8 @@ type Config struct {
 	TilesOnScreenX float32 `json:"tilesOnScreenX"`
 	TilesOnScreenY float32 `json:"tilesOnScreenY"`
 	WsUrl          string  `json:"wsUrl"`
+	Username       string  `json:"username"`
+	Password       string  `json:"password"`
 }
 
 func NewConfig() Config {
@@ -51,61 +55,266 @@ var (
 
 	cameraX float32 = 0
 	cameraY float32 = 0
+
+	// Set once the server accepts our login, and used to resume the session after reconnecting
+	sessionToken string = ""
+	loggedIn     bool   = false
+	role         string = ""
+
+	// The simulation clock and time of day as of the last frame
+	clock     protocol.ClockState
+	worldTime protocol.WorldTime
+
+	// Rain and drought over the map, drawn over the tiles unless toggled off with O
+	weather     protocol.WeatherState
+	showWeather bool = true
+
+	// The player's resources, pushed by the server whenever they change
+	inventoryText string = ""
+
+	// Wildlife population counts, pushed by the server every few seconds
+	populationText string = ""
+
+	// The last request the server rejected, shown for a few seconds
+	errorText string = ""
+	errorTime time.Time
+
+	// Territory claims, as indices into territoryPlayers with 0 being unclaimed
+	territoryChunkSize  int = 0
+	territoryChunksWide int = 0
+	territoryChunksHigh int = 0
+	territoryOwners     []int
+	territoryPlayers    []string
 )
 
-// create tilesWide and tilesHigh constants
+// Indexed by tile type
+var tileColors = map[int]rl.Color{
+	protocol.DeepWater:     rl.NewColor(0, 0, 128, 255),
+	protocol.ShallowWater:  rl.NewColor(0, 0, 255, 255),
+	protocol.Sand:          rl.NewColor(228, 228, 103, 255),
+	protocol.Grass:         rl.NewColor(0, 255, 0, 255),
+	protocol.Forest:        rl.NewColor(0, 128, 0, 255),
+	protocol.Dirt:          rl.NewColor(128, 64, 0, 255),
+	protocol.Mountains:     rl.NewColor(128, 128, 128, 255),
+	protocol.HighMountains: rl.NewColor(255, 255, 255, 255),
+	protocol.Concrete:      rl.NewColor(170, 170, 170, 255),
+	protocol.Nest:          rl.NewColor(176, 112, 48, 255),
+	protocol.HiveCore:      rl.NewColor(255, 200, 0, 255),
+	protocol.Bridge:        rl.NewColor(139, 105, 20, 255),
+	protocol.Oilspout:      rl.NewColor(64, 64, 64, 255),
+	protocol.Ice:           rl.NewColor(180, 220, 240, 255),
+	protocol.Snow:          rl.NewColor(235, 240, 250, 255),
+	protocol.Puddle:        rl.NewColor(110, 170, 210, 255),
+	protocol.Pump:          rl.NewColor(40, 30, 50, 255),
+	protocol.Fire:          rl.NewColor(240, 100, 20, 255),
+	protocol.Burnt:         rl.NewColor(50, 40, 35, 255),
+	protocol.Shrub:         rl.NewColor(90, 140, 50, 255),
+}
+
+var territoryColors = []rl.Color{rl.Magenta, rl.Orange, rl.SkyBlue, rl.Lime, rl.Pink, rl.Gold, rl.Purple, rl.Beige}
+
+// Returns the index of the player owning the chunk, or 0 when it's unclaimed or out of bounds
+func territoryOwner(cx, cy int) int {
+	if cx < 0 || cx >= territoryChunksWide || cy < 0 || cy >= territoryChunksHigh {
+		return 0
+	}
+	return territoryOwners[cy*territoryChunksWide+cx]
+}
+
+// Drone positions as sent by the server
+type Drone struct {
+	X int
+	Y int
+}
+
+// Cooperative drone tasks as sent by the server
+type Task struct {
+	Kind     string
+	X        int
+	Y        int
+	State    string
+	Team     int
+	Required int
+}
+
+// Converts the mouse position to tile coordinates
+func mouseTile() (int, int) {
+	tileX := int(cameraX + float32(rl.GetMouseX())/configuration.TileSizeX)
+	tileY := int(cameraY + float32(rl.GetMouseY())/configuration.TileSizeY)
+	return tileX, tileY
+}
+
 const (
-	tilesWide = 80 * 30 // This should come from the server on connection
-	tilesHigh = 45 * 30 // This too
+	tilesWide = protocol.TilesWide
+	tilesHigh = protocol.TilesHigh
 )
 
-func sendUpdateTile(wsConn *websocket.Conn, x, y, value int) error {
+// Gorilla connections only allow one writer at a time, and both the connection
+// goroutine and the render loop send messages
+var writeLock sync.Mutex
+
+func sendMessage(wsConn *websocket.Conn, msg protocol.Message) error {
 	if wsConn == nil {
 		return fmt.Errorf("WebSocket connection is nil")
 	}
-	// Create the message as a map
-	msg := map[string]interface{}{
-		"type":  "updateTile",
-		"x":     x,
-		"y":     y,
-		"value": value,
-	}
 	// Serialize the message to JSON
-	msgJSON, err := json.Marshal(msg)
+	msgJSON, err := protocol.Encode(msg)
 	if err != nil {
 		return err
 	}
 
 	// Send the JSON message over the WebSocket
-	err = wsConn.WriteMessage(websocket.TextMessage, msgJSON)
-	if err != nil {
-		return err
-	}
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	return wsConn.WriteMessage(websocket.TextMessage, msgJSON)
+}
+
+func sendPlaceHive(wsConn *websocket.Conn, x, y int) error {
+	return sendMessage(wsConn, trackRequest(protocol.NewPlaceHive(x, y)))
+}
 
-	return nil
+func sendBuildNest(wsConn *websocket.Conn, x, y, value int) error {
+	return sendMessage(wsConn, trackRequest(protocol.NewBuildNest(x, y, value)))
+}
+
+func sendPostTask(wsConn *websocket.Conn, kind string, x, y int) error {
+	return sendMessage(wsConn, trackRequest(protocol.NewPostTask(kind, x, y)))
+}
+
+func sendCancelTask(wsConn *websocket.Conn, x, y int) error {
+	return sendMessage(wsConn, trackRequest(protocol.NewCancelTask(x, y)))
+}
+
+func sendPlaceStructure(wsConn *websocket.Conn, structure string, x, y, rotation int) error {
+	return sendMessage(wsConn, trackRequest(protocol.NewPlaceStructure(structure, x, y, rotation)))
+}
+
+func sendIgnite(wsConn *websocket.Conn, x, y int) error {
+	return sendMessage(wsConn, trackRequest(protocol.NewIgnite(x, y)))
+}
+
+func sendUndo(wsConn *websocket.Conn) error {
+	return sendMessage(wsConn, trackRequest(protocol.NewUndo()))
+}
+
+func sendRedo(wsConn *websocket.Conn) error {
+	return sendMessage(wsConn, trackRequest(protocol.NewRedo()))
+}
+
+func sendPlaceDrone(wsConn *websocket.Conn) error {
+	return sendMessage(wsConn, trackRequest(protocol.NewPlaceDrone()))
 }
 
 func sendResetTiles(wsConn *websocket.Conn) error {
-	if wsConn == nil {
-		return fmt.Errorf("WebSocket connection is nil")
+	return sendMessage(wsConn, trackRequest(protocol.NewResetTiles()))
+}
+
+func sendSaveWorld(wsConn *websocket.Conn, name string) error {
+	return sendMessage(wsConn, trackRequest(protocol.NewSaveWorld(name)))
+}
+
+func sendLoadWorld(wsConn *websocket.Conn, name string) error {
+	return sendMessage(wsConn, trackRequest(protocol.NewLoadWorld(name)))
+}
+
+func sendPause(wsConn *websocket.Conn, paused bool) error {
+	return sendMessage(wsConn, trackRequest(protocol.NewPause(paused)))
+}
+
+func sendSetSpeed(wsConn *websocket.Conn, speed float64) error {
+	return sendMessage(wsConn, trackRequest(protocol.NewSetSpeed(speed)))
+}
+
+// Returns the speed step up or down from the current one
+func nextSpeed(step int) float64 {
+	current := 0
+	for i, speed := range protocol.Speeds {
+		if speed == clock.Speed {
+			current = i
+		}
 	}
-	// Create the message as a map
-	msg := map[string]interface{}{
-		"type": "resetTiles",
+	next := current + step
+	if next < 0 || next >= len(protocol.Speeds) {
+		return clock.Speed
 	}
-	// Serialize the message to JSON
-	msgJSON, err := json.Marshal(msg)
-	if err != nil {
-		return err
+	return protocol.Speeds[next]
+}
+
+func speedText(speed float64) string {
+	if speed == protocol.SpeedMax {
+		return "max"
 	}
+	return fmt.Sprintf("%gx", speed)
+}
 
-	// Send the JSON message over the WebSocket
-	err = wsConn.WriteMessage(websocket.TextMessage, msgJSON)
-	if err != nil {
-		return err
+// The tick rate the clock is set to, or the rate the server measured when it
+// runs flat out or falls more than a tenth short
+func tickRateText() string {
+	target := clock.TicksPerSecond * clock.Speed
+	if clock.Paused || clock.Speed == 0 {
+		return fmt.Sprintf("%g tps", clock.TicksPerSecond)
 	}
+	if clock.Speed == protocol.SpeedMax {
+		return fmt.Sprintf("%.0f tps", clock.Measured)
+	}
+	if clock.Measured < target*0.9 {
+		return fmt.Sprintf("%.1f of %g tps", clock.Measured, target)
+	}
+	return fmt.Sprintf("%g tps", target)
+}
 
-	return nil
+// Formats the time of day as a 24 hour clock along with the day, season and temperature
+func timeText() string {
+	minutes := int(worldTime.TimeOfDay * 24 * 60)
+	return fmt.Sprintf("Day %d, %s  %02d:%02d  %.0fC", worldTime.Day, worldTime.Season, minutes/60, minutes%60, worldTime.Temperature)
+}
+
+// The world is drawn darker and bluer at night
+func daylightTint() rl.Color {
+	night := rl.NewColor(70, 80, 140, 255)
+	light := float32(worldTime.Daylight)
+	return rl.NewColor(
+		uint8(float32(night.R)+(255-float32(night.R))*light),
+		uint8(float32(night.G)+(255-float32(night.G))*light),
+		uint8(float32(night.B)+(255-float32(night.B))*light),
+		255,
+	)
+}
+
+// Rain is drawn blue, getting darker towards a storm, and drought is drawn orange.
+// Mild weather near zero isn't drawn at all.
+func weatherColor(value int) (rl.Color, bool) {
+	switch {
+	case value >= 70:
+		return rl.NewColor(40, 50, 90, uint8(60+value)), true
+	case value >= 25:
+		return rl.NewColor(60, 110, 220, uint8(value*2)), true
+	case value <= -25:
+		return rl.NewColor(230, 140, 40, uint8(-value*2)), true
+	}
+	return rl.Color{}, false
+}
+
+// Resumes the previous session if there is one, otherwise logs in with the
+// configured password. Without a configured username the client only watches.
+func sendLogin(wsConn *websocket.Conn) error {
+	if sessionToken != "" {
+		return sendMessage(wsConn, trackRequest(protocol.NewResume(sessionToken)))
+	}
+	if configuration.Username == "" {
+		return nil
+	}
+	return sendMessage(wsConn, trackRequest(protocol.NewLogin(configuration.Username, configuration.Password)))
+}
+
+// Viewport updates are frequent and don't need acknowledging, so they carry no id
+func sendViewport(wsConn *websocket.Conn) error {
+	return sendMessage(wsConn, protocol.NewViewport(protocol.Viewport{
+		X:      float64(cameraX),
+		Y:      float64(cameraY),
+		Width:  float64(configuration.TilesOnScreenX),
+		Height: float64(configuration.TilesOnScreenY),
+	}))
 }
 
 func main() {
@@ -117,13 +326,15 @@ func main() {
 	defer rl.CloseWindow()
 	// create an 80x45 array of tiles
 	var tiles [tilesWide][tilesHigh]int
+	var drones []Drone
+	var tasks []Task
+	var animals []protocol.AnimalState
 
 	rl.SetTargetFPS(60)
 
 	// WebSocket connection setup
 	var wsConn *websocket.Conn
 	var err error
-	var loggedIn bool = false
 	var newState bool = false
 
 	// Start a goroutine to handle the WebSocket connection
@@ -139,18 +350,17 @@ func main() {
 			}
 			connectionStatus = "Connected"
 			log.Println("Connected to WebSocket server")
-			if !loggedIn {
-				// Send a login message
-				loginMessage := []byte(`{"type":"login","username":"raylib"}`)
-				err = wsConn.WriteMessage(websocket.TextMessage, loginMessage)
-				if err != nil {
-					log.Println("Write error:", err)
-					connectionStatus = "Disconnected"
-					break
-				}
-				loggedIn = true
-				newState = true
+			// Log in again on every connection, the session token lets the server pick up where we left off
+			loggedIn = false
+			err = sendLogin(wsConn)
+			if err != nil {
+				log.Println("Write error:", err)
+				connectionStatus = "Disconnected"
+				wsConn.Close()
+				time.Sleep(5 * time.Second)
+				continue
 			}
+			newState = true
 
 			// Read messages in a loop
 			var lastMessage []byte
@@ -170,60 +380,144 @@ func main() {
 				lastMessage = message
 				newState = true
 
-				// try to parse the message as a tile update, and update the tiles array
-				// the message is a JSON object with a "type" field whych may be "tiles", and if so it has a "tiles" field which is the actual tile data
-				var msg map[string]interface{}
-				err = json.Unmarshal(message, &msg)
+				envelope, decoded, err := protocol.Decode(message)
 				if err != nil {
-					log.Println("JSON unmarshal error:", err)
+					log.Println("Error decoding", envelope.Type, "message:", err)
 					continue
 				}
-				if msg["type"] == "tiles" { // update the tiles array
-					tileData, ok := msg["tiles"].([]interface{})
-					if !ok {
-						log.Println("Invalid tiles format")
+				switch msg := decoded.(type) {
+				case *protocol.TilesMessage:
+					err := applyChunks(&tiles, msg.Chunks)
+					if err != nil {
+						log.Println("Invalid tiles:", err)
+						continue
+					}
+					checkChunks(wsConn, msg)
+					clock = msg.Clock
+					worldTime = msg.Time
+					weather = msg.Weather
+					receiveOverlays(msg.Overlays)
+					newDrones := make([]Drone, 0, len(msg.Drones))
+					for _, drone := range msg.Drones {
+						newDrones = append(newDrones, Drone{X: drone.X, Y: drone.Y})
+					}
+					drones = newDrones
+					animals = msg.Animals
+					newTasks := make([]Task, 0, len(msg.Tasks))
+					for _, task := range msg.Tasks {
+						newTasks = append(newTasks, Task{Kind: task.Kind, X: task.X, Y: task.Y, State: task.State, Team: len(task.Team), Required: task.Required})
+					}
+					tasks = newTasks
+
+				case *protocol.PresenceMessage:
+					receivePresence(msg)
+
+				case *protocol.TerritoryMessage:
+					if len(msg.Owners) != msg.ChunksWide*msg.ChunksHigh {
+						log.Println("Invalid territory size")
 						continue
 					}
-					// set tiles = tileData
-					for x := 0; x < tilesWide; x++ {
-						for y := 0; y < tilesHigh; y++ {
-							tiles[x][y] = int(tileData[x].([]interface{})[y].(float64))
+					territoryChunkSize = msg.ChunkSize
+					territoryChunksWide = msg.ChunksWide
+					territoryChunksHigh = msg.ChunksHigh
+					territoryOwners = msg.Owners
+					territoryPlayers = msg.Players
+
+				case *protocol.SessionMessage:
+					resolveRequest(msg.ID)
+					sessionToken = msg.Token
+					loggedIn = true
+					role = msg.Role
+					overlaysChanged = true
+					// Put the camera back where it was before the connection dropped
+					if msg.Resumed {
+						cameraX = float32(msg.Viewport.X)
+						cameraY = float32(msg.Viewport.Y)
+					}
+					log.Println("Logged in as", msg.Role, "resumed session:", msg.Resumed)
+
+				case *protocol.AckMessage:
+					resolveRequest(msg.ID)
+
+				case *protocol.ChatLinesMessage:
+					receiveChat(msg)
+
+				case *protocol.ErrorMessage:
+					request, ok := resolveRequest(msg.ID)
+					if !ok {
+						request = msg.For
+					}
+					log.Printf("Server error for %s (%s): %s\n", request, msg.Code, msg.Message)
+					errorText = request + ": " + msg.Message
+					errorTime = time.Now()
+					// The session may have expired, so fall back to the password
+					if msg.Code == protocol.CodeLoginFailed && sessionToken != "" {
+						sessionToken = ""
+						err = sendLogin(wsConn)
+						if err != nil {
+							log.Println("Write error:", err)
 						}
 					}
 
-				} else {
-					log.Println("Received message of unknown type:", msg)
+				case *protocol.StatsMessage:
+					text := ""
+					for _, population := range msg.Populations {
+						text += fmt.Sprintf("%ss: %d  ", population.Kind, population.Count)
+					}
+					populationText = text
+
+				case *protocol.InventoryMessage:
+					text := ""
+					for _, resource := range protocol.ResourceTypes {
+						text += fmt.Sprintf("%s: %d  ", resource, msg.Resources[resource])
+					}
+					inventoryText = text
+
+				default:
+					log.Println("Received message of unexpected type:", envelope.Type)
 				}
 			}
 
+			// Answers to requests sent on this connection will never arrive
+			clearPendingRequests()
+
 			// Close the connection and retry
 			wsConn.Close()
 			time.Sleep(5 * time.Second)
 		}
 	}()
 
-	// var oilColor = rl.NewColor(64, 64, 64, 255)
 	// var concreteColor = rl.NewColor(128, 128, 128, 255)
 	// var highMountainColor = rl.NewColor(202, 215, 215, 255)
 
-	var deepWater = rl.NewColor(0, 0, 128, 255)
-	var shallowWater = rl.NewColor(0, 0, 255, 255)
-	// var sand = rl.NewColor(240, 240, 64, 255)
-	var sand = rl.NewColor(228, 228, 103, 255)
-	var grass = rl.NewColor(0, 255, 0, 255)
-	var forest = rl.NewColor(0, 128, 0, 255)
-	var dirt = rl.NewColor(128, 64, 0, 255)
-	var mountains = rl.NewColor(128, 128, 128, 255)
-	var highMountains = rl.NewColor(255, 255, 255, 255)
+	var droneColor = rl.NewColor(255, 64, 64, 255)
+	var grazerColor = rl.NewColor(230, 210, 160, 255)
+	var predatorColor = rl.NewColor(120, 20, 20, 255)
 
 	var shouldDraw = true
 	var lastDrawTime = time.Now()
+	var sentViewport = rl.NewRectangle(-1, -1, 0, 0)
+	var lastViewportTime = time.Now()
 
 	for !rl.WindowShouldClose() {
 		statusText := "Status: " + connectionStatus
 		statusColor := rl.Red
 		if connectionStatus == "Connected" {
 			statusColor = rl.Green
+			if !loggedIn || role == protocol.RoleSpectator {
+				statusText += " (spectating)"
+				statusColor = rl.Yellow
+			} else if role == protocol.RoleAdmin {
+				statusText += " (admin)"
+			}
+			statusText += fmt.Sprintf("  Tick %d  %s  %s", clock.Tick, tickRateText(), speedText(clock.Speed))
+			if driftedChunks > 0 {
+				statusText += fmt.Sprintf("  %d chunks out of sync", driftedChunks)
+				statusColor = rl.Orange
+			}
+			if clock.Paused {
+				statusText += " - paused"
+			}
 		}
 
 		// Calculate the range of tiles to draw
@@ -252,27 +546,7 @@ func main() {
 			// Draw the tiles
 			for x := tileXStart; x < tileXEnd; x++ {
 				for y := tileYStart; y < tileYEnd; y++ {
-					tileValue := tiles[x][y]
-					var tileColor rl.Color
-					// Determine the color based on tileValue
-					switch tileValue {
-					case 0:
-						tileColor = deepWater
-					case 1:
-						tileColor = shallowWater
-					case 2:
-						tileColor = sand
-					case 3:
-						tileColor = grass
-					case 4:
-						tileColor = forest
-					case 5:
-						tileColor = dirt
-					case 6:
-						tileColor = mountains
-					case 7:
-						tileColor = highMountains
-					}
+					tileColor := tileColors[tiles[x][y]]
 
 					screenX := (float32(x) - cameraX) * configuration.TileSizeX
 					screenY := (float32(y) - cameraY) * configuration.TileSizeY
@@ -280,6 +554,89 @@ func main() {
 					rl.DrawRectangle(int32(screenX), int32(screenY), int32(configuration.TileSizeX), int32(configuration.TileSizeY), tileColor)
 				}
 			}
+			// Draw the drones on top of the tiles
+			for _, drone := range drones {
+				if drone.X < tileXStart || drone.X >= tileXEnd || drone.Y < tileYStart || drone.Y >= tileYEnd {
+					continue
+				}
+				screenX := (float32(drone.X)-cameraX)*configuration.TileSizeX + configuration.TileSizeX/2
+				screenY := (float32(drone.Y)-cameraY)*configuration.TileSizeY + configuration.TileSizeY/2
+				rl.DrawCircle(int32(screenX), int32(screenY), configuration.TileSizeX/2, droneColor)
+			}
+			// The server only sends the animals in the viewport
+			for _, animal := range animals {
+				screenX := (float32(animal.X)-cameraX)*configuration.TileSizeX + configuration.TileSizeX/2
+				screenY := (float32(animal.Y)-cameraY)*configuration.TileSizeY + configuration.TileSizeY/2
+				color := grazerColor
+				if animal.Kind == protocol.AnimalPredator {
+					color = predatorColor
+				}
+				rl.DrawCircle(int32(screenX), int32(screenY), configuration.TileSizeX/3, color)
+			}
+			drawOverlay(tileXStart, tileYStart, tileXEnd, tileYEnd)
+			// Draw the weather over the tiles
+			if showWeather && weather.CellSize > 0 {
+				cellW := float32(weather.CellSize) * configuration.TileSizeX
+				cellH := float32(weather.CellSize) * configuration.TileSizeY
+				for cx := tileXStart / weather.CellSize; cx < len(weather.Cells) && cx*weather.CellSize < tileXEnd; cx++ {
+					for cy := tileYStart / weather.CellSize; cy < len(weather.Cells[cx]) && cy*weather.CellSize < tileYEnd; cy++ {
+						color, ok := weatherColor(weather.Cells[cx][cy])
+						if !ok {
+							continue
+						}
+						left := (float32(cx*weather.CellSize) - cameraX) * configuration.TileSizeX
+						top := (float32(cy*weather.CellSize) - cameraY) * configuration.TileSizeY
+						rl.DrawRectangle(int32(left), int32(top), int32(cellW), int32(cellH), color)
+					}
+				}
+			}
+			// Draw territory borders wherever the owner changes between neighbouring chunks
+			if territoryChunkSize > 0 {
+				chunkXStart := tileXStart / territoryChunkSize
+				chunkYStart := tileYStart / territoryChunkSize
+				chunkXEnd := (tileXEnd + territoryChunkSize - 1) / territoryChunkSize
+				chunkYEnd := (tileYEnd + territoryChunkSize - 1) / territoryChunkSize
+				chunkW := float32(territoryChunkSize) * configuration.TileSizeX
+				chunkH := float32(territoryChunkSize) * configuration.TileSizeY
+				for cx := chunkXStart; cx < chunkXEnd; cx++ {
+					for cy := chunkYStart; cy < chunkYEnd; cy++ {
+						owner := territoryOwner(cx, cy)
+						if owner == 0 {
+							continue
+						}
+						borderColor := territoryColors[(owner-1)%len(territoryColors)]
+						left := (float32(cx*territoryChunkSize) - cameraX) * configuration.TileSizeX
+						top := (float32(cy*territoryChunkSize) - cameraY) * configuration.TileSizeY
+						if territoryOwner(cx-1, cy) != owner {
+							rl.DrawLineEx(rl.NewVector2(left, top), rl.NewVector2(left, top+chunkH), 2, borderColor)
+						}
+						if territoryOwner(cx+1, cy) != owner {
+							rl.DrawLineEx(rl.NewVector2(left+chunkW, top), rl.NewVector2(left+chunkW, top+chunkH), 2, borderColor)
+						}
+						if territoryOwner(cx, cy-1) != owner {
+							rl.DrawLineEx(rl.NewVector2(left, top), rl.NewVector2(left+chunkW, top), 2, borderColor)
+						}
+						if territoryOwner(cx, cy+1) != owner {
+							rl.DrawLineEx(rl.NewVector2(left, top+chunkH), rl.NewVector2(left+chunkW, top+chunkH), 2, borderColor)
+						}
+					}
+				}
+			}
+			// Outline task sites, yellow while the auction is open and red once a team is on it
+			for _, task := range tasks {
+				if task.X < tileXStart || task.X >= tileXEnd || task.Y < tileYStart || task.Y >= tileYEnd {
+					continue
+				}
+				screenX := (float32(task.X) - cameraX) * configuration.TileSizeX
+				screenY := (float32(task.Y) - cameraY) * configuration.TileSizeY
+				taskColor := rl.Yellow
+				if task.State != "open" {
+					taskColor = rl.Red
+				}
+				rl.DrawRectangleLines(int32(screenX), int32(screenY), int32(configuration.TileSizeX), int32(configuration.TileSizeY), taskColor)
+				taskText := fmt.Sprintf("%s %s %d/%d", task.Kind, task.State, task.Team, task.Required)
+				rl.DrawText(taskText, int32(screenX+configuration.TileSizeX), int32(screenY), 10, taskColor)
+			}
 			rl.EndTextureMode()
 			shouldDraw = true
 			lastDrawTime = time.Now()
@@ -292,97 +649,97 @@ func main() {
 		destRec := rl.NewRectangle(0, 0, float32(renderTexture.Texture.Width), float32(renderTexture.Texture.Height))
 		originVector := rl.NewVector2(0, 0)
 		roation := 0.0
-		tintColor := rl.White
+		tintColor := daylightTint()
 		rl.DrawTexturePro(renderTexture.Texture, sourceRec, destRec, originVector, float32(roation), tintColor)
 
+		if connectionStatus == "Connected" {
+			rl.DrawText(timeText(), 10, 15, 20, rl.RayWhite)
+		}
 		rl.DrawText(statusText, 10, 40, 20, statusColor)
+		rl.DrawText(inventoryText, 10, 65, 20, rl.RayWhite)
+		populationWidth := rl.MeasureText(populationText, 20)
+		rl.DrawText(populationText, int32(rl.GetScreenWidth())-populationWidth-10, 15, 20, rl.RayWhite)
+		if errorText != "" && time.Since(errorTime) < 4*time.Second {
+			rl.DrawText(errorText, 10, 90, 20, rl.Red)
+		}
+		drawAnnouncement()
+		drawStructureGhost(&tiles)
+		drawEditor()
+		drawPresence()
+		drawChat()
+		drawOverlayLegend()
 		rl.EndDrawing()
 
-		// Handle mouse input to update tiles
-		if rl.IsMouseButtonPressed(rl.MouseLeftButton) && connectionStatus == "Connected" {
-			mouseX := rl.GetMouseX()
-			mouseY := rl.GetMouseY()
-
-			distFromCenterY := mouseY - int32(configuration.WindowHeight/2)
-			distFromCenterY = int32(math.Max(float64(distFromCenterY), float64(0-distFromCenterY)))
-
-			tileX := int(cameraX + float32(mouseX)/configuration.TileSizeX)
-			tileY := int(cameraY + float32(mouseY)/configuration.TileSizeY)
-
-			// Ensure the tile coordinates are within bounds
-			if tileX >= 0 && tileX < tilesWide && tileY >= 0 && tileY < tilesHigh {
-				currentValue := tiles[tileX][tileY]
-				var newValue int
-
-				// Determine the new value based on the current tile color
-				if currentValue == 1 {
-					newValue = 0 // If white, change to black
-				} else {
-					newValue = 1 // Otherwise, change to white
-				}
-
-				// Send the updateTile message to the server
-				err := sendUpdateTile(wsConn, tileX, tileY, newValue)
-				if err != nil {
-					log.Println("Error sending updateTile message:", err)
-				}
+		// Clicking paints in editor mode, or otherwise places the selected structure
+		if editorMode && connectionStatus == "Connected" {
+			handleEditorMouse(wsConn, &tiles)
+		} else if rl.IsMouseButtonPressed(rl.MouseLeftButton) && connectionStatus == "Connected" && selectedStructure >= 0 {
+			tileX, tileY := mouseTile()
+			err := sendPlaceStructure(wsConn, protocol.Structures[selectedStructure].Name, tileX, tileY, structureRotation)
+			if err != nil {
+				log.Println("Error sending placeStructure message:", err)
 			}
 		}
 
-		moveSpeed := 500.0 / configuration.TileSizeX // Adjust as needed
-		var speedMultiplier float32 = 1.0
-		if rl.IsKeyDown(rl.KeyLeftShift) || rl.IsKeyDown(rl.KeyRightShift) {
-			speedMultiplier = 2.0
-		}
-		if rl.IsKeyDown(rl.KeyLeft) || rl.IsKeyDown(rl.KeyA) {
-			cameraX -= moveSpeed * speedMultiplier * rl.GetFrameTime()
-			newState = true
-		}
-		if rl.IsKeyDown(rl.KeyRight) || rl.IsKeyDown(rl.KeyD) {
-			cameraX += moveSpeed * speedMultiplier * rl.GetFrameTime()
-			newState = true
-		}
-		if rl.IsKeyDown(rl.KeyUp) || rl.IsKeyDown(rl.KeyW) {
-			cameraY -= moveSpeed * speedMultiplier * rl.GetFrameTime()
-			newState = true
-		}
-		if rl.IsKeyDown(rl.KeyDown) || rl.IsKeyDown(rl.KeyS) {
-			cameraY += moveSpeed * speedMultiplier * rl.GetFrameTime()
-			newState = true
-		}
-		if rl.IsKeyPressed(rl.KeyPageUp) || rl.IsKeyPressed(rl.KeyEqual) || rl.IsKeyPressed(rl.KeyKpAdd) {
-			if configuration.TileSizeX < 128 && configuration.TileSizeY < 128 {
-				centerX := cameraX + configuration.TilesOnScreenX/2.0
-				centerY := cameraY + configuration.TilesOnScreenY/2.0
-
-				configuration.TileSizeX += 1
-				configuration.TileSizeY += 1
+		// Enter opens the chat box, which takes the keyboard until it closes
+		typing := handleChatKeys(wsConn)
 
-				configuration.TilesOnScreenX = float32(rl.GetScreenWidth()) / configuration.TileSizeX
-				configuration.TilesOnScreenY = float32(rl.GetScreenHeight()) / configuration.TileSizeY
-				// Center the new camera position so we zoom in on the center of the screen
-				cameraX = centerX - configuration.TilesOnScreenX/2.0
-				cameraY = centerY - configuration.TilesOnScreenY/2.0
+		if !typing {
+			moveSpeed := 500.0 / configuration.TileSizeX // Adjust as needed
+			var speedMultiplier float32 = 1.0
+			if shiftDown() {
+				speedMultiplier = 2.0
+			}
+			if rl.IsKeyDown(rl.KeyLeft) || rl.IsKeyDown(rl.KeyA) {
+				cameraX -= moveSpeed * speedMultiplier * rl.GetFrameTime()
+				newState = true
+			}
+			if rl.IsKeyDown(rl.KeyRight) || rl.IsKeyDown(rl.KeyD) {
+				cameraX += moveSpeed * speedMultiplier * rl.GetFrameTime()
+				newState = true
+			}
+			if rl.IsKeyDown(rl.KeyUp) || rl.IsKeyDown(rl.KeyW) {
+				cameraY -= moveSpeed * speedMultiplier * rl.GetFrameTime()
+				newState = true
+			}
+			if rl.IsKeyDown(rl.KeyDown) || rl.IsKeyDown(rl.KeyS) {
+				cameraY += moveSpeed * speedMultiplier * rl.GetFrameTime()
+				newState = true
+			}
+			if rl.IsKeyPressed(rl.KeyPageUp) || rl.IsKeyPressed(rl.KeyEqual) || rl.IsKeyPressed(rl.KeyKpAdd) {
+				if configuration.TileSizeX < 128 && configuration.TileSizeY < 128 {
+					centerX := cameraX + configuration.TilesOnScreenX/2.0
+					centerY := cameraY + configuration.TilesOnScreenY/2.0
+
+					configuration.TileSizeX += 1
+					configuration.TileSizeY += 1
+
+					configuration.TilesOnScreenX = float32(rl.GetScreenWidth()) / configuration.TileSizeX
+					configuration.TilesOnScreenY = float32(rl.GetScreenHeight()) / configuration.TileSizeY
+					// Center the new camera position so we zoom in on the center of the screen
+					cameraX = centerX - configuration.TilesOnScreenX/2.0
+					cameraY = centerY - configuration.TilesOnScreenY/2.0
+				}
+				newState = true
 			}
-			newState = true
-		}
 
-		if rl.IsKeyPressed(rl.KeyPageDown) || rl.IsKeyPressed(rl.KeyMinus) || rl.IsKeyPressed(rl.KeyKpSubtract) {
-			if configuration.TileSizeX > 1 && configuration.TileSizeY > 1 {
-				centerX := cameraX + configuration.TilesOnScreenX/2.0
-				centerY := cameraY + configuration.TilesOnScreenY/2.0
+			if rl.IsKeyPressed(rl.KeyPageDown) || rl.IsKeyPressed(rl.KeyMinus) || rl.IsKeyPressed(rl.KeyKpSubtract) {
+				if configuration.TileSizeX > 1 && configuration.TileSizeY > 1 {
+					centerX := cameraX + configuration.TilesOnScreenX/2.0
+					centerY := cameraY + configuration.TilesOnScreenY/2.0
 
-				configuration.TileSizeX -= 1
-				configuration.TileSizeY -= 1
+					configuration.TileSizeX -= 1
+					configuration.TileSizeY -= 1
 
-				configuration.TilesOnScreenX = float32(rl.GetScreenWidth()) / configuration.TileSizeX
-				configuration.TilesOnScreenY = float32(rl.GetScreenHeight()) / configuration.TileSizeY
+					configuration.TilesOnScreenX = float32(rl.GetScreenWidth()) / configuration.TileSizeX
+					configuration.TilesOnScreenY = float32(rl.GetScreenHeight()) / configuration.TileSizeY
 
-				// Center the new camera position so we zoom in on the center of the screen
-				cameraX = centerX - configuration.TilesOnScreenX/2.0
-				cameraY = centerY - configuration.TilesOnScreenY/2.0
+					// Center the new camera position so we zoom in on the center of the screen
+					cameraX = centerX - configuration.TilesOnScreenX/2.0
+					cameraY = centerY - configuration.TilesOnScreenY/2.0
+				}
+				newState = true
 			}
-			newState = true
 		}
 
 		if newState {
@@ -392,6 +749,13 @@ func main() {
 			shouldDraw = false
 		}
 
+		if !typing {
+			handlePresenceKeys()
+		}
+		if followCamera() {
+			newState = true
+		}
+
 		// Clamp camera position
 		if cameraX < 0 {
 			cameraX = 0
@@ -408,10 +772,162 @@ func main() {
 			cameraY = maxCameraY
 		}
 
-		if rl.IsKeyPressed(rl.KeyR) && connectionStatus == "Connected" {
-			err := sendResetTiles(wsConn)
+		if !typing {
+			// H places the player's hive, N queues a nest tile for its drones and U a pump on an oilspout
+			if toggleOverlays() {
+				newState = true
+			}
+			handleStructureKeys()
+			handleEditorKeys()
+			if rl.IsKeyPressed(rl.KeyO) {
+				showWeather = !showWeather
+				newState = true
+			}
+			if rl.IsKeyPressed(rl.KeyH) && connectionStatus == "Connected" {
+				tileX, tileY := mouseTile()
+				err := sendPlaceHive(wsConn, tileX, tileY)
+				if err != nil {
+					log.Println("Error sending placeHive message:", err)
+				}
+			}
+			if rl.IsKeyPressed(rl.KeyN) && connectionStatus == "Connected" {
+				tileX, tileY := mouseTile()
+				err := sendBuildNest(wsConn, tileX, tileY, protocol.Nest)
+				if err != nil {
+					log.Println("Error sending buildNest message:", err)
+				}
+			}
+			if rl.IsKeyPressed(rl.KeyU) && connectionStatus == "Connected" {
+				tileX, tileY := mouseTile()
+				err := sendBuildNest(wsConn, tileX, tileY, protocol.Pump)
+				if err != nil {
+					log.Println("Error sending buildNest message:", err)
+				}
+			}
+
+			// I sets fire to the tile under the mouse
+			if rl.IsKeyPressed(rl.KeyI) && connectionStatus == "Connected" {
+				tileX, tileY := mouseTile()
+				err := sendIgnite(wsConn, tileX, tileY)
+				if err != nil {
+					log.Println("Error sending ignite message:", err)
+				}
+			}
+
+			// Ctrl+Z undoes the player's last edit and Ctrl+Y redoes it
+			if ctrlDown() && rl.IsKeyPressed(rl.KeyZ) && connectionStatus == "Connected" {
+				err := sendUndo(wsConn)
+				if err != nil {
+					log.Println("Error sending undo message:", err)
+				}
+			}
+			if ctrlDown() && rl.IsKeyPressed(rl.KeyY) && connectionStatus == "Connected" {
+				err := sendRedo(wsConn)
+				if err != nil {
+					log.Println("Error sending redo message:", err)
+				}
+			}
+
+			// P buys another drone for the player's hive
+			if rl.IsKeyPressed(rl.KeyP) && connectionStatus == "Connected" {
+				err := sendPlaceDrone(wsConn)
+				if err != nil {
+					log.Println("Error sending placeDrone message:", err)
+				}
+			}
+
+			// T posts a cooperative task for the tile under the mouse:
+			// bridges over shallow water, ore hauling on mountains, or clearing them with shift held.
+			// Ctrl+T cancels the task there instead.
+			if ctrlDown() && rl.IsKeyPressed(rl.KeyT) && connectionStatus == "Connected" {
+				tileX, tileY := mouseTile()
+				err := sendCancelTask(wsConn, tileX, tileY)
+				if err != nil {
+					log.Println("Error sending cancelTask message:", err)
+				}
+			} else if rl.IsKeyPressed(rl.KeyT) && connectionStatus == "Connected" {
+				tileX, tileY := mouseTile()
+				if tileX >= 0 && tileX < tilesWide && tileY >= 0 && tileY < tilesHigh {
+					kind := ""
+					switch tiles[tileX][tileY] {
+					case protocol.ShallowWater:
+						kind = protocol.TaskBridgeWater
+					case protocol.Mountains:
+						kind = protocol.TaskHaulOre
+						if shiftDown() {
+							kind = protocol.TaskClearMountain
+						}
+					}
+					if kind != "" {
+						err := sendPostTask(wsConn, kind, tileX, tileY)
+						if err != nil {
+							log.Println("Error sending postTask message:", err)
+						}
+					}
+				}
+			}
+		}
+
+		// Let the server know where we're looking so it can be restored when the session resumes
+		viewport := rl.NewRectangle(cameraX, cameraY, configuration.TilesOnScreenX, configuration.TilesOnScreenY)
+		if loggedIn && viewport != sentViewport && time.Since(lastViewportTime) >= 250*time.Millisecond {
+			err := sendViewport(wsConn)
 			if err != nil {
-				log.Println("Error sending resetTiles message:", err)
+				log.Println("Error sending viewport message:", err)
+			}
+			sentViewport = viewport
+			lastViewportTime = time.Now()
+		}
+
+		if loggedIn && connectionStatus == "Connected" {
+			sendCursor(wsConn)
+		}
+
+		if loggedIn && overlaysChanged {
+			err := sendMessage(wsConn, protocol.NewSetOverlays(overlayFields()))
+			if err != nil {
+				log.Println("Error sending setOverlays message:", err)
+			}
+			overlaysChanged = false
+		}
+
+		// Admin commands: Ctrl+Shift+R resets the world, so it can't be hit by accident,
+		// F5 and F9 save and load the quicksave, space pauses or resumes the simulation,
+		// and [ and ] change its speed
+		if role == protocol.RoleAdmin && connectionStatus == "Connected" && !typing {
+			if ctrlDown() && shiftDown() && rl.IsKeyPressed(rl.KeyR) {
+				err := sendResetTiles(wsConn)
+				if err != nil {
+					log.Println("Error sending resetTiles message:", err)
+				}
+			}
+			if rl.IsKeyPressed(rl.KeyF5) {
+				err := sendSaveWorld(wsConn, "quicksave")
+				if err != nil {
+					log.Println("Error sending saveWorld message:", err)
+				}
+			}
+			if rl.IsKeyPressed(rl.KeyF9) {
+				err := sendLoadWorld(wsConn, "quicksave")
+				if err != nil {
+					log.Println("Error sending loadWorld message:", err)
+				}
+			}
+			if rl.IsKeyPressed(rl.KeySpace) {
+				err := sendPause(wsConn, !clock.Paused)
+				if err != nil {
+					log.Println("Error sending pause message:", err)
+				}
+			}
+			if rl.IsKeyPressed(rl.KeyLeftBracket) || rl.IsKeyPressed(rl.KeyRightBracket) {
+				step := 1
+				if rl.IsKeyPressed(rl.KeyLeftBracket) {
+					step = -1
+				}
+				err := sendSetSpeed(wsConn, nextSpeed(step))
+				if err != nil {
+					log.Println("Error sending setSpeed message:", err)
+				}
 			}
 		}
 	}
@@ -0,0 +1,163 @@
+// overlays.go
+package main
+
+import (
+	"fmt"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+	"growth-protocol"
+)
+
+// An overlay is drawn blended over the tiles and toggled with its key. Most
+// are fields streamed by the server for the viewport, but ownership is drawn
+// from the territory claims the client already has.
+type overlay struct {
+	name      string
+	field     string // empty for ownership
+	key       int32
+	low       rl.Color
+	high      rl.Color
+	lowLabel  string
+	highLabel string
+}
+
+const (
+	overlayAlpha     = 160
+	overlayLegendBar = 200 // width of the legend's color scale
+)
+
+var overlays = []overlay{
+	{name: "Altitude", field: protocol.FieldAltitude, key: rl.KeyOne, low: rl.NewColor(20, 40, 120, overlayAlpha), high: rl.NewColor(250, 80, 40, overlayAlpha), lowLabel: "low", highLabel: "high"},
+	{name: "Nutrients", field: protocol.FieldNutrient, key: rl.KeyTwo, low: rl.NewColor(60, 40, 20, overlayAlpha), high: rl.NewColor(120, 255, 60, overlayAlpha), lowLabel: "none", highLabel: "rich"},
+	{name: "Moisture", field: protocol.FieldMoisture, key: rl.KeyThree, low: rl.NewColor(200, 160, 80, overlayAlpha), high: rl.NewColor(30, 90, 255, overlayAlpha), lowLabel: "dry", highLabel: "wet"},
+	{name: "Vegetation", field: protocol.FieldVegetation, key: rl.KeyFour, low: rl.NewColor(120, 100, 60, overlayAlpha), high: rl.NewColor(20, 160, 40, overlayAlpha), lowLabel: "bare", highLabel: "overgrown"},
+	{name: "Water proximity", field: protocol.FieldWaterProximity, key: rl.KeyFive, low: rl.NewColor(80, 40, 20, overlayAlpha), high: rl.NewColor(60, 200, 255, overlayAlpha), lowLabel: "far", highLabel: "water"},
+	{name: "Pollution", field: protocol.FieldPollution, key: rl.KeySix, low: rl.NewColor(40, 60, 40, overlayAlpha), high: rl.NewColor(20, 10, 30, 230), lowLabel: "clean", highLabel: "slick"},
+	{name: "Ownership", key: rl.KeySeven},
+}
+
+var (
+	activeOverlay   = -1 // index into overlays, or -1 for none
+	overlaysChanged = false
+	overlayValues   *protocol.FieldOverlay // the active field as of the last frame
+)
+
+// Toggles the overlay whose key was pressed. Only one is shown at a time.
+func toggleOverlays() bool {
+	for i, o := range overlays {
+		if !rl.IsKeyPressed(o.key) {
+			continue
+		}
+		if activeOverlay == i {
+			activeOverlay = -1
+		} else {
+			activeOverlay = i
+		}
+		overlayValues = nil
+		overlaysChanged = true
+		return true
+	}
+	return false
+}
+
+// The fields the server should stream for the active overlay
+func overlayFields() []string {
+	if activeOverlay < 0 || overlays[activeOverlay].field == "" {
+		return []string{}
+	}
+	return []string{overlays[activeOverlay].field}
+}
+
+func receiveOverlays(fieldOverlays []protocol.FieldOverlay) {
+	fields := overlayFields()
+	for i := range fieldOverlays {
+		if len(fields) > 0 && fieldOverlays[i].Field == fields[0] {
+			overlayValues = &fieldOverlays[i]
+			return
+		}
+	}
+	overlayValues = nil
+}
+
+func lerpColor(a, b rl.Color, t float32) rl.Color {
+	return rl.NewColor(
+		uint8(float32(a.R)+(float32(b.R)-float32(a.R))*t),
+		uint8(float32(a.G)+(float32(b.G)-float32(a.G))*t),
+		uint8(float32(a.B)+(float32(b.B)-float32(a.B))*t),
+		uint8(float32(a.A)+(float32(b.A)-float32(a.A))*t),
+	)
+}
+
+// Draws the active overlay over the visible tiles. Called while drawing to the render texture.
+func drawOverlay(tileXStart, tileYStart, tileXEnd, tileYEnd int) {
+	if activeOverlay < 0 {
+		return
+	}
+	o := overlays[activeOverlay]
+	if o.field == "" {
+		drawOwnershipOverlay(tileXStart, tileYStart, tileXEnd, tileYEnd)
+		return
+	}
+	values := overlayValues
+	if values == nil {
+		return
+	}
+	for x := max(tileXStart, values.X); x < min(tileXEnd, values.X+values.Width); x++ {
+		for y := max(tileYStart, values.Y); y < min(tileYEnd, values.Y+values.Height); y++ {
+			value := values.Values[(x-values.X)*values.Height+(y-values.Y)]
+			screenX := (float32(x) - cameraX) * configuration.TileSizeX
+			screenY := (float32(y) - cameraY) * configuration.TileSizeY
+			rl.DrawRectangle(int32(screenX), int32(screenY), int32(configuration.TileSizeX), int32(configuration.TileSizeY), lerpColor(o.low, o.high, float32(value)/255))
+		}
+	}
+}
+
+// Fills each claimed chunk with its owner's color
+func drawOwnershipOverlay(tileXStart, tileYStart, tileXEnd, tileYEnd int) {
+	if territoryChunkSize == 0 {
+		return
+	}
+	chunkW := float32(territoryChunkSize) * configuration.TileSizeX
+	chunkH := float32(territoryChunkSize) * configuration.TileSizeY
+	for cx := tileXStart / territoryChunkSize; cx < (tileXEnd+territoryChunkSize-1)/territoryChunkSize; cx++ {
+		for cy := tileYStart / territoryChunkSize; cy < (tileYEnd+territoryChunkSize-1)/territoryChunkSize; cy++ {
+			owner := territoryOwner(cx, cy)
+			if owner == 0 {
+				continue
+			}
+			left := (float32(cx*territoryChunkSize) - cameraX) * configuration.TileSizeX
+			top := (float32(cy*territoryChunkSize) - cameraY) * configuration.TileSizeY
+			rl.DrawRectangle(int32(left), int32(top), int32(chunkW), int32(chunkH), rl.Fade(territoryColors[(owner-1)%len(territoryColors)], 0.4))
+		}
+	}
+}
+
+// Draws the name and scale of the active overlay in the bottom left corner
+func drawOverlayLegend() {
+	if activeOverlay < 0 {
+		return
+	}
+	o := overlays[activeOverlay]
+	bottom := int32(rl.GetScreenHeight()) - 10
+	title := fmt.Sprintf("%s (%d to hide)", o.name, activeOverlay+1)
+	if o.field == "" {
+		// One row per player, skipping the unclaimed entry
+		players := []string{}
+		if len(territoryPlayers) > 1 {
+			players = territoryPlayers[1:]
+		}
+		top := bottom - int32(len(players))*20
+		rl.DrawText(title, 10, top-24, 20, rl.RayWhite)
+		for i, player := range players {
+			y := top + int32(i)*20
+			rl.DrawRectangle(10, y, 16, 16, territoryColors[i%len(territoryColors)])
+			rl.DrawText(player, 32, y, 16, rl.RayWhite)
+		}
+		return
+	}
+	rl.DrawText(title, 10, bottom-56, 20, rl.RayWhite)
+	rl.DrawRectangleGradientH(10, bottom-32, overlayLegendBar, 14, o.low, o.high)
+	rl.DrawText(o.lowLabel, 10, bottom-14, 14, rl.RayWhite)
+	highWidth := rl.MeasureText(o.highLabel, 14)
+	rl.DrawText(o.highLabel, 10+overlayLegendBar-highWidth, bottom-14, 14, rl.RayWhite)
+}
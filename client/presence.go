@@ -0,0 +1,145 @@
+// presence.go
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"time"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+	"github.com/gorilla/websocket"
+	"growth-protocol"
+)
+
+// Everyone else who's connected is listed in the top right corner, and their
+// cameras and cursors are drawn over the map in a color picked from their
+// name. G follows each of them in turn, moving our camera along with theirs,
+// and pressing it past the last one stops following.
+const cursorInterval = 100 * time.Millisecond // how often our cursor is sent while the mouse moves
+
+var (
+	others           []protocol.UserPresence
+	anonymousViewers int
+	following        string // the username whose camera we follow, empty if none
+
+	sentCursorX    float32
+	sentCursorY    float32
+	lastCursorTime time.Time
+)
+
+func receivePresence(msg *protocol.PresenceMessage) {
+	others = msg.Users
+	anonymousViewers = msg.Anonymous
+}
+
+// The usernames of everyone else, once each
+func otherUsernames() []string {
+	names := []string{}
+	for _, user := range others {
+		if len(names) == 0 || names[len(names)-1] != user.Username {
+			names = append(names, user.Username)
+		}
+	}
+	return names
+}
+
+func handlePresenceKeys() {
+	if !rl.IsKeyPressed(rl.KeyG) || editorMode {
+		return
+	}
+	names := otherUsernames()
+	next := 0
+	for i, name := range names {
+		if name == following {
+			next = i + 1
+		}
+	}
+	following = ""
+	if next < len(names) {
+		following = names[next]
+	}
+}
+
+// Centers our camera on the followed player's. Returns whether it moved.
+func followCamera() bool {
+	if following == "" {
+		return false
+	}
+	for _, user := range others {
+		if user.Username != following {
+			continue
+		}
+		x := float32(user.Viewport.X+user.Viewport.Width/2) - configuration.TilesOnScreenX/2
+		y := float32(user.Viewport.Y+user.Viewport.Height/2) - configuration.TilesOnScreenY/2
+		moved := x != cameraX || y != cameraY
+		cameraX, cameraY = x, y
+		return moved
+	}
+	// They've left
+	following = ""
+	return false
+}
+
+// Tells the server where our cursor is whenever it moves to another tile
+func sendCursor(wsConn *websocket.Conn) {
+	x := cameraX + float32(rl.GetMouseX())/configuration.TileSizeX
+	y := cameraY + float32(rl.GetMouseY())/configuration.TileSizeY
+	if int(x) == int(sentCursorX) && int(y) == int(sentCursorY) || time.Since(lastCursorTime) < cursorInterval {
+		return
+	}
+	err := sendMessage(wsConn, protocol.NewCursor(float64(x), float64(y)))
+	if err != nil {
+		log.Println("Error sending cursor message:", err)
+	}
+	sentCursorX, sentCursorY = x, y
+	lastCursorTime = time.Now()
+}
+
+func userColor(username string) rl.Color {
+	h := fnv.New32a()
+	h.Write([]byte(username))
+	return rl.ColorFromHSV(float32(h.Sum32()%360), 0.7, 1)
+}
+
+// Draws the other players' cameras and cursors, and the list of who's connected
+func drawPresence() {
+	for _, user := range others {
+		color := userColor(user.Username)
+		left := (float32(user.Viewport.X) - cameraX) * configuration.TileSizeX
+		top := (float32(user.Viewport.Y) - cameraY) * configuration.TileSizeY
+		width := float32(user.Viewport.Width) * configuration.TileSizeX
+		height := float32(user.Viewport.Height) * configuration.TileSizeY
+		if width > 0 {
+			rl.DrawRectangleLinesEx(rl.NewRectangle(left, top, width, height), 2, rl.Fade(color, 0.6))
+		}
+
+		cursorX := (float32(user.CursorX) - cameraX) * configuration.TileSizeX
+		cursorY := (float32(user.CursorY) - cameraY) * configuration.TileSizeY
+		rl.DrawTriangle(rl.NewVector2(cursorX, cursorY), rl.NewVector2(cursorX, cursorY+14), rl.NewVector2(cursorX+10, cursorY+10), color)
+		rl.DrawText(user.Username, int32(cursorX)+12, int32(cursorY)+10, 10, color)
+	}
+
+	lines := []string{}
+	for _, user := range others {
+		line := fmt.Sprintf("%s (%s)", user.Username, user.Role)
+		if user.Username == following {
+			line = "following " + line
+		}
+		lines = append(lines, line)
+	}
+	if anonymousViewers > 0 {
+		lines = append(lines, fmt.Sprintf("%d watching anonymously", anonymousViewers))
+	}
+	if len(lines) > 0 {
+		lines = append(lines, "G to follow")
+	}
+	for i, line := range lines {
+		color := rl.RayWhite
+		if i < len(others) {
+			color = userColor(others[i].Username)
+		}
+		width := rl.MeasureText(line, 20)
+		rl.DrawText(line, int32(rl.GetScreenWidth())-width-10, int32(40+25*i), 20, color)
+	}
+}
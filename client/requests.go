@@ -0,0 +1,43 @@
+// requests.go
+package main
+
+import (
+	"strconv"
+	"sync"
+
+	"growth-protocol"
+)
+
+// Requests waiting for an ack or error, by id
+var (
+	nextRequestID   = 0
+	pendingRequests = make(map[string]string)
+	pendingLock     sync.Mutex
+)
+
+// Gives the request a fresh id, remembering it until the server answers
+func trackRequest(msg protocol.Message) protocol.Message {
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+	nextRequestID++
+	header := msg.Header()
+	header.ID = strconv.Itoa(nextRequestID)
+	pendingRequests[header.ID] = header.Type
+	return msg
+}
+
+// Forgets a request once it has been answered, returning its type
+func resolveRequest(id string) (string, bool) {
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+	msgType, ok := pendingRequests[id]
+	delete(pendingRequests, id)
+	return msgType, ok
+}
+
+// Pending requests are never answered once the connection drops
+func clearPendingRequests() {
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+	pendingRequests = make(map[string]string)
+}
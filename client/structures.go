@@ -0,0 +1,59 @@
+// structures.go
+package main
+
+import (
+	"fmt"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+	"growth-protocol"
+)
+
+// B cycles through the structures in the catalog and then back to none, and
+// E turns the selected structure clockwise. While one is selected a ghost of
+// it follows the mouse, green where it can be built and red where it can't,
+// and clicking places it.
+var (
+	selectedStructure = -1 // index into protocol.Structures, or -1 for none
+	structureRotation = 0
+)
+
+var (
+	ghostValid   = rl.NewColor(80, 220, 80, 120)
+	ghostInvalid = rl.NewColor(230, 60, 60, 120)
+)
+
+// The ghost is drawn straight to the screen every frame, so changing the
+// selection doesn't need the tiles redrawn
+func handleStructureKeys() {
+	if rl.IsKeyPressed(rl.KeyB) {
+		selectedStructure++
+		if selectedStructure >= len(protocol.Structures) {
+			selectedStructure = -1
+		}
+		editorMode = false
+	}
+	if rl.IsKeyPressed(rl.KeyE) && selectedStructure >= 0 {
+		structureRotation = (structureRotation + 1) % 4
+	}
+}
+
+// Draws the selected structure under the mouse. The server has the final say,
+// so tiles outside the player's territory are only caught when it's placed.
+func drawStructureGhost(tiles *[tilesWide][tilesHigh]int) {
+	if selectedStructure < 0 {
+		return
+	}
+	structure := protocol.Structures[selectedStructure]
+	x, y := mouseTile()
+	for _, tile := range structure.Tiles(x, y, structureRotation) {
+		color := ghostInvalid
+		if tile.X >= 0 && tile.X < tilesWide && tile.Y >= 0 && tile.Y < tilesHigh && protocol.CanBuildOn(tile.Type, tiles[tile.X][tile.Y]) {
+			color = ghostValid
+		}
+		screenX := (float32(tile.X) - cameraX) * configuration.TileSizeX
+		screenY := (float32(tile.Y) - cameraY) * configuration.TileSizeY
+		rl.DrawRectangle(int32(screenX), int32(screenY), int32(configuration.TileSizeX), int32(configuration.TileSizeY), color)
+	}
+	text := fmt.Sprintf("Building %s (B for the next, E to rotate)", structure.Name)
+	rl.DrawText(text, 10, 140, 20, rl.RayWhite)
+}
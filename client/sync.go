@@ -0,0 +1,80 @@
+// sync.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"growth-protocol"
+)
+
+// Frames only carry the chunks of the map that changed, along with a hash of
+// every chunk. The client hashes its own copy of each chunk as it arrives and
+// asks the server to send again any chunk whose hash doesn't match, up to
+// MaxResyncChunks at a time. Chunks left over are asked for after the next
+// resyncInterval, if they still don't match by then.
+const resyncInterval = time.Second // how often a resync may be requested
+
+var (
+	chunkHashes   [protocol.HashChunks]uint64 // the hashes of our copy of the map
+	driftedChunks int                         // chunks that didn't match the last frame
+	lastResync    time.Time
+)
+
+// Copies the chunks from a frame into the map
+func applyChunks(tiles *[tilesWide][tilesHigh]int, chunks []protocol.TileChunk) error {
+	for _, chunk := range chunks {
+		if chunk.Index < 0 || chunk.Index >= protocol.HashChunks {
+			return fmt.Errorf("chunk %d is out of bounds", chunk.Index)
+		}
+		x, y, width, height := protocol.ChunkBounds(chunk.Index)
+		if len(chunk.Tiles) != width*height {
+			return fmt.Errorf("chunk %d has %d tiles, expected %d", chunk.Index, len(chunk.Tiles), width*height)
+		}
+		var hash uint64
+		for i := 0; i < width; i++ {
+			for j := 0; j < height; j++ {
+				tileType := chunk.Tiles[i*height+j]
+				tiles[x+i][y+j] = tileType
+				hash += protocol.TileHash(x+i, y+j, tileType)
+			}
+		}
+		chunkHashes[chunk.Index] = hash
+	}
+	return nil
+}
+
+// Compares our copy of the map with the frame's hashes, asking for the
+// chunks that differ
+func checkChunks(wsConn *websocket.Conn, msg *protocol.TilesMessage) {
+	if len(msg.ChunkHashes) != protocol.HashChunks {
+		log.Println("Invalid chunk hashes size")
+		return
+	}
+	var drifted []int
+	var hash uint64
+	for index, chunkHash := range chunkHashes {
+		if protocol.ShortHash(chunkHash) != msg.ChunkHashes[index] {
+			drifted = append(drifted, index)
+		}
+		hash += chunkHash
+	}
+	driftedChunks = len(drifted)
+	if len(drifted) == 0 {
+		if hash != msg.Hash {
+			log.Printf("Map hashed to %016x, but the server's hashed to %016x", hash, msg.Hash)
+		}
+		return
+	}
+	if time.Since(lastResync) < resyncInterval {
+		return
+	}
+	lastResync = time.Now()
+	log.Println(len(drifted), "chunks are out of sync")
+	err := sendMessage(wsConn, protocol.NewResync(drifted[:min(len(drifted), protocol.MaxResyncChunks)]))
+	if err != nil {
+		log.Println("Error sending resync message:", err)
+	}
+}
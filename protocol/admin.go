@@ -0,0 +1,232 @@
+// admin.go
+package protocol
+
+import (
+	"regexp"
+)
+
+// Roles attached to sessions. Spectators can only watch, players can build,
+// and admins can also run the admin commands below.
+const (
+	RoleSpectator = "spectator"
+	RolePlayer    = "player"
+	RoleAdmin     = "admin"
+)
+
+func IsRole(role string) bool {
+	return role == RoleSpectator || role == RolePlayer || role == RoleAdmin
+}
+
+// Admin requests
+
+var saveNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+func validateSaveName(name string) error {
+	if !saveNamePattern.MatchString(name) {
+		return Errorf(CodeInvalidField, "save names must be 1 to 32 letters, digits, dashes or underscores")
+	}
+	return nil
+}
+
+type SaveWorldMessage struct {
+	Envelope
+	Name string `json:"name"`
+}
+
+func NewSaveWorld(name string) *SaveWorldMessage {
+	return &SaveWorldMessage{Envelope: Envelope{Type: TypeSaveWorld}, Name: name}
+}
+
+func (m *SaveWorldMessage) Validate() error {
+	return validateSaveName(m.Name)
+}
+
+type LoadWorldMessage struct {
+	Envelope
+	Name string `json:"name"`
+}
+
+func NewLoadWorld(name string) *LoadWorldMessage {
+	return &LoadWorldMessage{Envelope: Envelope{Type: TypeLoadWorld}, Name: name}
+}
+
+func (m *LoadWorldMessage) Validate() error {
+	return validateSaveName(m.Name)
+}
+
+type PauseMessage struct {
+	Envelope
+	Paused *bool `json:"paused"`
+}
+
+func NewPause(paused bool) *PauseMessage {
+	return &PauseMessage{Envelope: Envelope{Type: TypePause}, Paused: &paused}
+}
+
+func (m *PauseMessage) Validate() error {
+	if m.Paused == nil {
+		return Errorf(CodeMissingField, "missing field paused")
+	}
+	return nil
+}
+
+// Simulation speed multipliers admins may choose from. At SpeedMax the
+// server runs ticks as fast as it can.
+const SpeedMax = -1.0
+
+var Speeds = []float64{0, 0.5, 1, 4, SpeedMax}
+
+// SetSpeedMessage sets how fast simulated time passes relative to real time
+type SetSpeedMessage struct {
+	Envelope
+	Speed *float64 `json:"speed"`
+}
+
+func NewSetSpeed(speed float64) *SetSpeedMessage {
+	return &SetSpeedMessage{Envelope: Envelope{Type: TypeSetSpeed}, Speed: &speed}
+}
+
+func (m *SetSpeedMessage) Validate() error {
+	if m.Speed == nil {
+		return Errorf(CodeMissingField, "missing field speed")
+	}
+	for _, speed := range Speeds {
+		if *m.Speed == speed {
+			return nil
+		}
+	}
+	return Errorf(CodeInvalidField, "speed must be one of %v", Speeds)
+}
+
+// Limits on the simulation tick rate
+const (
+	MinTicksPerSecond = 1
+	MaxTicksPerSecond = 60
+)
+
+// SetTickRateMessage sets how many ticks make up one second of simulated time
+type SetTickRateMessage struct {
+	Envelope
+	TicksPerSecond *float64 `json:"ticksPerSecond"`
+}
+
+func NewSetTickRate(ticksPerSecond float64) *SetTickRateMessage {
+	return &SetTickRateMessage{Envelope: Envelope{Type: TypeSetTickRate}, TicksPerSecond: &ticksPerSecond}
+}
+
+func (m *SetTickRateMessage) Validate() error {
+	if m.TicksPerSecond == nil {
+		return Errorf(CodeMissingField, "missing field ticksPerSecond")
+	}
+	if *m.TicksPerSecond < MinTicksPerSecond || *m.TicksPerSecond > MaxTicksPerSecond {
+		return Errorf(CodeInvalidField, "ticksPerSecond must be between %d and %d", MinTicksPerSecond, MaxTicksPerSecond)
+	}
+	return nil
+}
+
+// SetParamMessage tunes one of the server's named simulation parameters
+type SetParamMessage struct {
+	Envelope
+	Name  string   `json:"name"`
+	Value *float64 `json:"value"`
+}
+
+func NewSetParam(name string, value float64) *SetParamMessage {
+	return &SetParamMessage{Envelope: Envelope{Type: TypeSetParam}, Name: name, Value: &value}
+}
+
+func (m *SetParamMessage) Validate() error {
+	if m.Name == "" {
+		return Errorf(CodeMissingField, "missing field name")
+	}
+	if m.Value == nil {
+		return Errorf(CodeMissingField, "missing field value")
+	}
+	return nil
+}
+
+// KickMessage disconnects every connection logged in as Username
+type KickMessage struct {
+	Envelope
+	Username string `json:"username"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func NewKick(username, reason string) *KickMessage {
+	return &KickMessage{Envelope: Envelope{Type: TypeKick}, Username: username, Reason: reason}
+}
+
+func (m *KickMessage) Validate() error {
+	if m.Username == "" {
+		return Errorf(CodeMissingField, "missing field username")
+	}
+	return nil
+}
+
+// AnnounceMessage asks the server to send an announcement to every client
+type AnnounceMessage struct {
+	Envelope
+	Message string `json:"message"`
+}
+
+func NewAnnounce(message string) *AnnounceMessage {
+	return &AnnounceMessage{Envelope: Envelope{Type: TypeAnnounce}, Message: message}
+}
+
+const maxAnnouncementLength = 280
+
+func (m *AnnounceMessage) Validate() error {
+	if m.Message == "" {
+		return Errorf(CodeMissingField, "missing field message")
+	}
+	if len(m.Message) > maxAnnouncementLength {
+		return Errorf(CodeInvalidField, "announcements can be at most %d characters", maxAnnouncementLength)
+	}
+	return nil
+}
+
+type SetRoleMessage struct {
+	Envelope
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+func NewSetRole(username, role string) *SetRoleMessage {
+	return &SetRoleMessage{Envelope: Envelope{Type: TypeSetRole}, Username: username, Role: role}
+}
+
+func (m *SetRoleMessage) Validate() error {
+	if m.Username == "" {
+		return Errorf(CodeMissingField, "missing field username")
+	}
+	if !IsRole(m.Role) {
+		return Errorf(CodeInvalidField, "unknown role %q", m.Role)
+	}
+	return nil
+}
+
+// RollbackMessage undoes every tile edit Username made from FromTick to
+// ToTick, or up to now if ToTick is left out
+type RollbackMessage struct {
+	Envelope
+	Username string `json:"username"`
+	FromTick *int64 `json:"fromTick"`
+	ToTick   *int64 `json:"toTick,omitempty"`
+}
+
+func NewRollback(username string, fromTick int64) *RollbackMessage {
+	return &RollbackMessage{Envelope: Envelope{Type: TypeRollback}, Username: username, FromTick: &fromTick}
+}
+
+func (m *RollbackMessage) Validate() error {
+	if m.Username == "" {
+		return Errorf(CodeMissingField, "missing field username")
+	}
+	if m.FromTick == nil {
+		return Errorf(CodeMissingField, "missing field fromTick")
+	}
+	if *m.FromTick < 0 || (m.ToTick != nil && *m.ToTick < *m.FromTick) {
+		return Errorf(CodeInvalidField, "fromTick must be at least 0 and no later than toTick")
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// chat.go
+package protocol
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Chat channels. Global chat goes to everyone connected, local chat to the
+// players whose territory is near the sender's, and whispers to one player.
+// System lines are announcements from the server itself.
+const (
+	ChannelGlobal  = "global"
+	ChannelLocal   = "local"
+	ChannelWhisper = "whisper"
+	ChannelSystem  = "system"
+)
+
+const MaxChatLength = 200 // in characters
+
+// Client requests
+
+// ChatMessage sends a line of chat. To names the player a whisper is for.
+type ChatMessage struct {
+	Envelope
+	Channel string `json:"channel"`
+	To      string `json:"to,omitempty"`
+	Text    string `json:"text"`
+}
+
+func NewChat(channel, to, text string) *ChatMessage {
+	return &ChatMessage{Envelope: Envelope{Type: TypeChat}, Channel: channel, To: to, Text: text}
+}
+
+func (m *ChatMessage) Validate() error {
+	switch m.Channel {
+	case ChannelGlobal, ChannelLocal:
+	case ChannelWhisper:
+		if m.To == "" {
+			return Errorf(CodeMissingField, "whispers need a player to send them to")
+		}
+	default:
+		return Errorf(CodeInvalidField, "unknown chat channel %q", m.Channel)
+	}
+	if strings.TrimSpace(m.Text) == "" {
+		return Errorf(CodeMissingField, "missing field text")
+	}
+	if !utf8.ValidString(m.Text) {
+		return Errorf(CodeInvalidField, "chat must be valid UTF-8")
+	}
+	if utf8.RuneCountInString(m.Text) > MaxChatLength {
+		return Errorf(CodeInvalidField, "chat can be at most %d characters", MaxChatLength)
+	}
+	for _, r := range m.Text {
+		if unicode.IsControl(r) {
+			return Errorf(CodeInvalidField, "chat can't contain control characters")
+		}
+	}
+	return nil
+}
+
+// Server messages
+
+// ChatLinesMessage delivers chat. Each client is sent the recent global chat
+// and announcements when it connects, marked as history, then each line as
+// it's sent.
+type ChatLinesMessage struct {
+	Envelope
+	Lines   []ChatLine `json:"lines"`
+	History bool       `json:"history,omitempty"`
+}
+
+type ChatLine struct {
+	Channel string `json:"channel"`
+	From    string `json:"from,omitempty"` // empty for system lines
+	To      string `json:"to,omitempty"`
+	Text    string `json:"text"`
+}
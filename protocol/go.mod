@@ -0,0 +1,3 @@
+module growth-protocol
+
+go 1.23.2
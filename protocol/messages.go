@@ -0,0 +1,441 @@
+// messages.go
+package protocol
+
+import "math"
+
+// Client requests. Coordinates are pointers so that a missing field can be
+// told apart from a zero.
+
+type LoginMessage struct {
+	Envelope
+	Version  int    `json:"version"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// NewLogin logs in with a password, registering the username if it's new
+func NewLogin(username, password string) *LoginMessage {
+	return &LoginMessage{Envelope: Envelope{Type: TypeLogin}, Version: Version, Username: username, Password: password}
+}
+
+// NewResume picks up an earlier session with its token
+func NewResume(token string) *LoginMessage {
+	return &LoginMessage{Envelope: Envelope{Type: TypeLogin}, Version: Version, Token: token}
+}
+
+func (m *LoginMessage) Validate() error {
+	if m.Version != Version {
+		return Errorf(CodeVersionMismatch, "protocol version %d is not supported, expected %d", m.Version, Version)
+	}
+	if m.Token == "" && m.Username == "" {
+		return Errorf(CodeMissingField, "login needs a username or a token")
+	}
+	return nil
+}
+
+// Servers only track this many tiles across and down of a viewport, so a zoomed
+// out client isn't sent everything on the map
+const MaxViewportSize = 512
+
+// The rectangle of tiles the client was looking at, restored when a session is resumed
+type Viewport struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+type ViewportMessage struct {
+	Envelope
+	Viewport
+}
+
+func NewViewport(viewport Viewport) *ViewportMessage {
+	return &ViewportMessage{Envelope: Envelope{Type: TypeViewport}, Viewport: viewport}
+}
+
+func (m *ViewportMessage) Validate() error {
+	if math.IsNaN(m.X) || math.IsInf(m.X, 0) || math.IsNaN(m.Y) || math.IsInf(m.Y, 0) {
+		return Errorf(CodeInvalidField, "the viewport's corner must be finite")
+	}
+	if !(m.Width >= 0 && m.Height >= 0) || math.IsInf(m.Width, 0) || math.IsInf(m.Height, 0) {
+		return Errorf(CodeInvalidField, "the viewport's width and height must be finite and not negative")
+	}
+	return nil
+}
+
+type UpdateTileMessage struct {
+	Envelope
+	X     *int `json:"x"`
+	Y     *int `json:"y"`
+	Value *int `json:"value"`
+}
+
+func NewUpdateTile(x, y, value int) *UpdateTileMessage {
+	return &UpdateTileMessage{Envelope: Envelope{Type: TypeUpdateTile}, X: &x, Y: &y, Value: &value}
+}
+
+func (m *UpdateTileMessage) Validate() error {
+	return requireFields(intField{"x", m.X}, intField{"y", m.Y}, intField{"value", m.Value})
+}
+
+// EditTilesMessage edits a rectangle of tiles in one request. Types holds the
+// new tile type for each tile, indexed [x*Height+y], with LeaveTile for tiles
+// that aren't being edited. Altitude instead holds how far to raise or lower
+// each tile. Exactly one of the two is sent.
+type EditTilesMessage struct {
+	Envelope
+	X        *int      `json:"x"`
+	Y        *int      `json:"y"`
+	Width    *int      `json:"width"`
+	Height   *int      `json:"height"`
+	Types    []int     `json:"types,omitempty"`
+	Altitude []float64 `json:"altitude,omitempty"`
+}
+
+const (
+	LeaveTile         = -1
+	MaxEditSize       = 64 // the widest and highest an edit can be
+	MaxAltitudeChange = 0.05
+)
+
+func NewEditTypes(x, y, width, height int, types []int) *EditTilesMessage {
+	return &EditTilesMessage{Envelope: Envelope{Type: TypeEditTiles}, X: &x, Y: &y, Width: &width, Height: &height, Types: types}
+}
+
+func NewEditAltitude(x, y, width, height int, altitude []float64) *EditTilesMessage {
+	return &EditTilesMessage{Envelope: Envelope{Type: TypeEditTiles}, X: &x, Y: &y, Width: &width, Height: &height, Altitude: altitude}
+}
+
+func (m *EditTilesMessage) Validate() error {
+	err := requireFields(intField{"x", m.X}, intField{"y", m.Y}, intField{"width", m.Width}, intField{"height", m.Height})
+	if err != nil {
+		return err
+	}
+	if *m.Width < 1 || *m.Width > MaxEditSize || *m.Height < 1 || *m.Height > MaxEditSize {
+		return Errorf(CodeInvalidField, "width and height must be between 1 and %d", MaxEditSize)
+	}
+	size := *m.Width * *m.Height
+	switch {
+	case m.Types != nil && m.Altitude != nil:
+		return Errorf(CodeInvalidField, "types and altitude can't be edited together")
+	case m.Types != nil:
+		if len(m.Types) != size {
+			return Errorf(CodeInvalidField, "expected %d types, got %d", size, len(m.Types))
+		}
+	case m.Altitude != nil:
+		if len(m.Altitude) != size {
+			return Errorf(CodeInvalidField, "expected %d altitudes, got %d", size, len(m.Altitude))
+		}
+		for _, change := range m.Altitude {
+			if !(change >= -MaxAltitudeChange && change <= MaxAltitudeChange) {
+				return Errorf(CodeInvalidField, "altitude changes must be between %g and %g", -MaxAltitudeChange, MaxAltitudeChange)
+			}
+		}
+	default:
+		return Errorf(CodeMissingField, "missing field types or altitude")
+	}
+	return nil
+}
+
+type PlaceHiveMessage struct {
+	Envelope
+	X *int `json:"x"`
+	Y *int `json:"y"`
+}
+
+func NewPlaceHive(x, y int) *PlaceHiveMessage {
+	return &PlaceHiveMessage{Envelope: Envelope{Type: TypePlaceHive}, X: &x, Y: &y}
+}
+
+func (m *PlaceHiveMessage) Validate() error {
+	return requireFields(intField{"x", m.X}, intField{"y", m.Y})
+}
+
+type BuildNestMessage struct {
+	Envelope
+	X     *int `json:"x"`
+	Y     *int `json:"y"`
+	Value *int `json:"value"`
+}
+
+func NewBuildNest(x, y, value int) *BuildNestMessage {
+	return &BuildNestMessage{Envelope: Envelope{Type: TypeBuildNest}, X: &x, Y: &y, Value: &value}
+}
+
+func (m *BuildNestMessage) Validate() error {
+	return requireFields(intField{"x", m.X}, intField{"y", m.Y}, intField{"value", m.Value})
+}
+
+type PostTaskMessage struct {
+	Envelope
+	Kind string `json:"kind"`
+	X    *int   `json:"x"`
+	Y    *int   `json:"y"`
+}
+
+func NewPostTask(kind string, x, y int) *PostTaskMessage {
+	return &PostTaskMessage{Envelope: Envelope{Type: TypePostTask}, Kind: kind, X: &x, Y: &y}
+}
+
+func (m *PostTaskMessage) Validate() error {
+	if m.Kind == "" {
+		return Errorf(CodeMissingField, "missing field kind")
+	}
+	return requireFields(intField{"x", m.X}, intField{"y", m.Y})
+}
+
+// CancelTaskMessage cancels the player's task at a tile, refunding its materials
+type CancelTaskMessage struct {
+	Envelope
+	X *int `json:"x"`
+	Y *int `json:"y"`
+}
+
+func NewCancelTask(x, y int) *CancelTaskMessage {
+	return &CancelTaskMessage{Envelope: Envelope{Type: TypeCancelTask}, X: &x, Y: &y}
+}
+
+func (m *CancelTaskMessage) Validate() error {
+	return requireFields(intField{"x", m.X}, intField{"y", m.Y})
+}
+
+type IgniteMessage struct {
+	Envelope
+	X *int `json:"x"`
+	Y *int `json:"y"`
+}
+
+func NewIgnite(x, y int) *IgniteMessage {
+	return &IgniteMessage{Envelope: Envelope{Type: TypeIgnite}, X: &x, Y: &y}
+}
+
+func (m *IgniteMessage) Validate() error {
+	return requireFields(intField{"x", m.X}, intField{"y", m.Y})
+}
+
+// UndoMessage undoes the player's most recent tile edit
+type UndoMessage struct {
+	Envelope
+}
+
+func NewUndo() *UndoMessage {
+	return &UndoMessage{Envelope{Type: TypeUndo}}
+}
+
+// RedoMessage makes the player's most recently undone tile edit again
+type RedoMessage struct {
+	Envelope
+}
+
+func NewRedo() *RedoMessage {
+	return &RedoMessage{Envelope{Type: TypeRedo}}
+}
+
+// ResyncMessage asks for the tiles of chunks whose hashes didn't match the
+// client's copy. They're sent again in the next frame. A client that needs
+// more than MaxResyncChunks asks for them over several requests.
+type ResyncMessage struct {
+	Envelope
+	Chunks []int `json:"chunks"`
+}
+
+const MaxResyncChunks = 512
+
+func NewResync(chunks []int) *ResyncMessage {
+	return &ResyncMessage{Envelope: Envelope{Type: TypeResync}, Chunks: chunks}
+}
+
+func (m *ResyncMessage) Validate() error {
+	if len(m.Chunks) == 0 || len(m.Chunks) > MaxResyncChunks {
+		return Errorf(CodeInvalidField, "expected between 1 and %d chunks", MaxResyncChunks)
+	}
+	for _, chunk := range m.Chunks {
+		if chunk < 0 || chunk >= HashChunks {
+			return Errorf(CodeInvalidField, "chunk %d is out of bounds", chunk)
+		}
+	}
+	return nil
+}
+
+type PlaceDroneMessage struct {
+	Envelope
+}
+
+func NewPlaceDrone() *PlaceDroneMessage {
+	return &PlaceDroneMessage{Envelope{Type: TypePlaceDrone}}
+}
+
+type ResetTilesMessage struct {
+	Envelope
+}
+
+func NewResetTiles() *ResetTilesMessage {
+	return &ResetTilesMessage{Envelope{Type: TypeResetTiles}}
+}
+
+type intField struct {
+	name  string
+	value *int
+}
+
+func requireFields(fields ...intField) error {
+	for _, field := range fields {
+		if field.value == nil {
+			return Errorf(CodeMissingField, "missing field %s", field.name)
+		}
+	}
+	return nil
+}
+
+// Server messages
+
+// TilesMessage is broadcast to every client each update. Chunks holds the
+// tiles of each chunk that changed since the client's last frame, and the
+// whole map in its first. Hash and ChunkHashes describe the whole map, so the
+// client can check its copy and send a resync for the chunks that differ.
+type TilesMessage struct {
+	Envelope
+	Clock       ClockState     `json:"clock"`
+	Time        WorldTime      `json:"time"`
+	Weather     WeatherState   `json:"weather"`
+	Overlays    []FieldOverlay `json:"overlays,omitempty"`
+	Chunks      []TileChunk    `json:"chunks"`
+	Hash        uint64         `json:"hash"`
+	ChunkHashes []uint32       `json:"chunkHashes"` // ShortHash of each chunk, by ChunkIndex
+	Hives       []HiveState    `json:"hives"`
+	Drones      []DroneState   `json:"drones"`
+	Tasks       []TaskState    `json:"tasks"`
+	Animals     []AnimalState  `json:"animals"` // only those in the client's viewport
+}
+
+// TileChunk holds a chunk's tile type IDs, indexed [x*height+y] where the
+// chunk's size is given by ChunkBounds
+type TileChunk struct {
+	Index int   `json:"index"`
+	Tiles []int `json:"tiles"`
+}
+
+// ClockState describes the simulation clock when a frame was sent.
+// Measured falls short of TicksPerSecond times Speed when the server can't
+// keep up, and is how fast it goes at SpeedMax.
+type ClockState struct {
+	Tick           int64   `json:"tick"`
+	TicksPerSecond float64 `json:"ticksPerSecond"`
+	Speed          float64 `json:"speed"`
+	Paused         bool    `json:"paused"`
+	Measured       float64 `json:"measured"` // ticks run per second, over the last second
+}
+
+// WorldTime is the time of day and season derived from the clock's tick
+type WorldTime struct {
+	Day         int     `json:"day"` // starting at 1
+	Season      string  `json:"season"`
+	TimeOfDay   float64 `json:"timeOfDay"`   // 0 is midnight, 0.5 is noon
+	Daylight    float64 `json:"daylight"`    // 0 at night, 1 at noon
+	Temperature float64 `json:"temperature"` // degrees celsius at sea level
+}
+
+// WeatherState is the weather over the whole map, in square cells of tiles
+type WeatherState struct {
+	CellSize int     `json:"cellSize"`
+	WindX    float64 `json:"windX"` // cells per tick
+	WindY    float64 `json:"windY"`
+	Cells    [][]int `json:"cells"` // indexed [x][y], from -100 for severe drought to 100 for a storm
+}
+
+type HiveState struct {
+	ID        int            `json:"id"`
+	Owner     string         `json:"owner"`
+	X         int            `json:"x"`
+	Y         int            `json:"y"`
+	Inventory map[string]int `json:"inventory"`
+	Jobs      int            `json:"jobs"`
+	Tasks     int            `json:"tasks"`
+}
+
+type DroneState struct {
+	ID           int      `json:"id"`
+	Hive         int      `json:"hive"`
+	X            int      `json:"x"`
+	Y            int      `json:"y"`
+	State        int      `json:"state"`
+	Task         int      `json:"task"`
+	Capabilities []string `json:"capabilities"`
+}
+
+type TaskState struct {
+	ID       int    `json:"id"`
+	Hive     int    `json:"hive"`
+	Kind     string `json:"kind"`
+	X        int    `json:"x"`
+	Y        int    `json:"y"`
+	State    string `json:"state"`
+	Required int    `json:"required"`
+	Bids     int    `json:"bids"`
+	Team     []int  `json:"team"`
+	Progress int    `json:"progress"`
+	Steps    int    `json:"steps"`
+}
+
+type AnimalState struct {
+	Kind string `json:"kind"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+}
+
+// StatsMessage is sent whenever the wildlife populations are counted
+type StatsMessage struct {
+	Envelope
+	Tick        int64             `json:"tick"`
+	Populations []PopulationStats `json:"populations"`
+}
+
+// PopulationStats counts one kind of animal. Births and deaths are since the last count.
+type PopulationStats struct {
+	Kind   string  `json:"kind"`
+	Count  int     `json:"count"`
+	Births int     `json:"births"`
+	Deaths int     `json:"deaths"`
+	Energy float64 `json:"energy"` // average, from 0 to 1
+}
+
+// InventoryMessage is sent whenever the player's resources change, always with every resource
+type InventoryMessage struct {
+	Envelope
+	Resources map[string]int `json:"resources"`
+}
+
+// TerritoryMessage holds chunk owners as indices into Players, 0 being unclaimed
+type TerritoryMessage struct {
+	Envelope
+	ChunkSize  int      `json:"chunkSize"`
+	ChunksWide int      `json:"chunksWide"`
+	ChunksHigh int      `json:"chunksHigh"`
+	Owners     []int    `json:"owners"`
+	Players    []string `json:"players"`
+}
+
+// SessionMessage answers a successful login
+type SessionMessage struct {
+	Envelope
+	Version  int      `json:"version"`
+	Token    string   `json:"token"`
+	Username string   `json:"username"`
+	Role     string   `json:"role"`
+	Viewport Viewport `json:"viewport"`
+	Resumed  bool     `json:"resumed"`
+}
+
+type AckMessage struct {
+	Envelope
+	For string `json:"for"`
+}
+
+type ErrorMessage struct {
+	Envelope
+	For     string `json:"for,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,33 @@
+package protocol
+
+import (
+	"math"
+	"testing"
+)
+
+// The largest edits the protocol allows must fit in a single message
+func TestLargestEditFits(t *testing.T) {
+	size := MaxEditSize * MaxEditSize
+	types := make([]int, size)
+	altitude := make([]float64, size)
+	for i := range types {
+		types[i] = LeaveTile
+		altitude[i] = math.Nextafter(-MaxAltitudeChange, 0)
+	}
+	for _, msg := range []*EditTilesMessage{
+		NewEditTypes(TilesWide-MaxEditSize, TilesHigh-MaxEditSize, MaxEditSize, MaxEditSize, types),
+		NewEditAltitude(TilesWide-MaxEditSize, TilesHigh-MaxEditSize, MaxEditSize, MaxEditSize, altitude),
+	} {
+		msg.ID = "2147483647"
+		if err := msg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := Encode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) > MaxMessageSize {
+			t.Errorf("a %dx%d edit encodes to %d bytes, over the %d byte limit", MaxEditSize, MaxEditSize, len(data), MaxMessageSize)
+		}
+	}
+}
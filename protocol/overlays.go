@@ -0,0 +1,61 @@
+// overlays.go
+package protocol
+
+// Scalar tile fields that clients can ask to have streamed for their viewport
+const (
+	FieldAltitude       = "altitude"
+	FieldNutrient       = "nutrient"
+	FieldMoisture       = "moisture"
+	FieldVegetation     = "vegetation"
+	FieldWaterProximity = "waterProximity" // 255 on water, falling to 0 far from it
+	FieldPollution      = "pollution"
+)
+
+var Fields = []string{FieldAltitude, FieldNutrient, FieldMoisture, FieldVegetation, FieldWaterProximity, FieldPollution}
+
+// Overlays cover at most this many tiles across and down, starting from the viewport's corner
+const MaxOverlaySize = 512
+
+func IsField(field string) bool {
+	for _, known := range Fields {
+		if field == known {
+			return true
+		}
+	}
+	return false
+}
+
+// SetOverlaysMessage replaces the fields streamed to the client. An empty list turns them off.
+type SetOverlaysMessage struct {
+	Envelope
+	Fields []string `json:"fields"`
+}
+
+func NewSetOverlays(fields []string) *SetOverlaysMessage {
+	return &SetOverlaysMessage{Envelope: Envelope{Type: TypeSetOverlays}, Fields: fields}
+}
+
+func (m *SetOverlaysMessage) Validate() error {
+	seen := make(map[string]bool)
+	for _, field := range m.Fields {
+		if !IsField(field) {
+			return Errorf(CodeInvalidField, "unknown field %q", field)
+		}
+		if seen[field] {
+			return Errorf(CodeInvalidField, "field %q is listed twice", field)
+		}
+		seen[field] = true
+	}
+	return nil
+}
+
+// FieldOverlay is one field for a rectangle of tiles, quantized from 0 to 255.
+// Values are indexed like tiles, [x*Height+y], and are base64 encoded in JSON.
+type FieldOverlay struct {
+	Field  string `json:"field"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Values []byte `json:"values"`
+}
@@ -0,0 +1,33 @@
+// presence.go
+package protocol
+
+// Client requests
+
+// CursorMessage moves the player's cursor, in tiles, as other players see it
+type CursorMessage struct {
+	Envelope
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+func NewCursor(x, y float64) *CursorMessage {
+	return &CursorMessage{Envelope: Envelope{Type: TypeCursor}, X: x, Y: y}
+}
+
+// Server messages
+
+// PresenceMessage lists everyone else who's connected, with where they're
+// looking and where their cursor is. It's sent whenever that changes.
+type PresenceMessage struct {
+	Envelope
+	Users     []UserPresence `json:"users"`
+	Anonymous int            `json:"anonymous"` // connections that haven't logged in
+}
+
+type UserPresence struct {
+	Username string   `json:"username"`
+	Role     string   `json:"role"`
+	Viewport Viewport `json:"viewport"`
+	CursorX  float64  `json:"cursorX"`
+	CursorY  float64  `json:"cursorY"`
+}
@@ -0,0 +1,209 @@
+// Package protocol defines the websocket messages exchanged by the growth
+// server and client, and how they are encoded.
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Version is bumped whenever a message changes in a way older peers can't read.
+// Clients send it when logging in and the server refuses mismatched versions.
+const Version = 7
+
+// MaxMessageSize is the largest request the server reads, in bytes, and it
+// closes the connection on anything bigger. It fits the largest valid request,
+// an editTiles with MaxEditSize squared altitudes of up to 22 bytes each.
+const MaxMessageSize = 128 << 10
+
+// Every websocket message is a JSON object with a "type" field.
+// Client requests may carry an "id", which is echoed back in the matching ack or error.
+const (
+	TypeLogin       = "login"
+	TypeViewport    = "viewport"
+	TypeUpdateTile  = "updateTile"
+	TypePlaceHive   = "placeHive"
+	TypeBuildNest   = "buildNest"
+	TypePostTask    = "postTask"
+	TypeCancelTask  = "cancelTask"
+	TypePlaceDrone  = "placeDrone"
+	TypeResetTiles  = "resetTiles"
+	TypeSetOverlays = "setOverlays"
+	TypeIgnite      = "ignite"
+	TypeUndo        = "undo"
+	TypeRedo        = "redo"
+	TypeResync      = "resync"
+	TypeCursor      = "cursor"
+	TypeChat        = "chat"
+
+	TypePlaceStructure = "placeStructure"
+	TypeEditTiles      = "editTiles"
+
+	TypeSaveWorld   = "saveWorld"
+	TypeLoadWorld   = "loadWorld"
+	TypePause       = "pause"
+	TypeSetSpeed    = "setSpeed"
+	TypeSetTickRate = "setTickRate"
+	TypeSetParam    = "setParam"
+	TypeKick        = "kick"
+	TypeAnnounce    = "announce"
+	TypeSetRole     = "setRole"
+	TypeRollback    = "rollback"
+
+	TypeTiles     = "tiles"
+	TypeInventory = "inventory"
+	TypeTerritory = "territory"
+	TypeSession   = "session"
+	TypeAck       = "ack"
+	TypeError     = "error"
+	TypeStats     = "stats"
+	TypePresence  = "presence"
+	TypeChatLines = "chatLines"
+)
+
+// Error codes sent back to clients in error messages
+const (
+	CodeBadJSON         = "bad_json"
+	CodeUnknownType     = "unknown_type"
+	CodeInvalidField    = "invalid_field"
+	CodeMissingField    = "missing_field"
+	CodeVersionMismatch = "version_mismatch"
+	CodeUnauthenticated = "unauthenticated"
+	CodeLoginFailed     = "login_failed"
+	CodeForbidden       = "forbidden"
+	CodeNoHive          = "no_hive"
+	CodeRateLimited     = "rate_limited"
+	CodeRejected        = "rejected"
+)
+
+// Error is an error with a code the client can act on
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func Errorf(code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Envelope holds the fields shared by every message
+type Envelope struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+}
+
+func (e *Envelope) Header() *Envelope {
+	return e
+}
+
+// Message is implemented by every message through its embedded Envelope
+type Message interface {
+	Header() *Envelope
+}
+
+// Requests with required fields are checked by Decode
+type validator interface {
+	Validate() error
+}
+
+var messageTypes = map[string]func() Message{
+	TypeLogin:       func() Message { return &LoginMessage{} },
+	TypeViewport:    func() Message { return &ViewportMessage{} },
+	TypeUpdateTile:  func() Message { return &UpdateTileMessage{} },
+	TypePlaceHive:   func() Message { return &PlaceHiveMessage{} },
+	TypeBuildNest:   func() Message { return &BuildNestMessage{} },
+	TypePostTask:    func() Message { return &PostTaskMessage{} },
+	TypeCancelTask:  func() Message { return &CancelTaskMessage{} },
+	TypePlaceDrone:  func() Message { return &PlaceDroneMessage{} },
+	TypeResetTiles:  func() Message { return &ResetTilesMessage{} },
+	TypeSetOverlays: func() Message { return &SetOverlaysMessage{} },
+	TypeIgnite:      func() Message { return &IgniteMessage{} },
+	TypeUndo:        func() Message { return &UndoMessage{} },
+	TypeRedo:        func() Message { return &RedoMessage{} },
+	TypeResync:      func() Message { return &ResyncMessage{} },
+	TypeCursor:      func() Message { return &CursorMessage{} },
+	TypeChat:        func() Message { return &ChatMessage{} },
+
+	TypePlaceStructure: func() Message { return &PlaceStructureMessage{} },
+	TypeEditTiles:      func() Message { return &EditTilesMessage{} },
+
+	TypeSaveWorld:   func() Message { return &SaveWorldMessage{} },
+	TypeLoadWorld:   func() Message { return &LoadWorldMessage{} },
+	TypePause:       func() Message { return &PauseMessage{} },
+	TypeSetSpeed:    func() Message { return &SetSpeedMessage{} },
+	TypeSetTickRate: func() Message { return &SetTickRateMessage{} },
+	TypeSetParam:    func() Message { return &SetParamMessage{} },
+	TypeKick:        func() Message { return &KickMessage{} },
+	TypeAnnounce:    func() Message { return &AnnounceMessage{} },
+	TypeSetRole:     func() Message { return &SetRoleMessage{} },
+	TypeRollback:    func() Message { return &RollbackMessage{} },
+
+	TypeTiles:     func() Message { return &TilesMessage{} },
+	TypeInventory: func() Message { return &InventoryMessage{} },
+	TypeTerritory: func() Message { return &TerritoryMessage{} },
+	TypeSession:   func() Message { return &SessionMessage{} },
+	TypeAck:       func() Message { return &AckMessage{} },
+	TypeError:     func() Message { return &ErrorMessage{} },
+
+	TypeStats:     func() Message { return &StatsMessage{} },
+	TypePresence:  func() Message { return &PresenceMessage{} },
+	TypeChatLines: func() Message { return &ChatLinesMessage{} },
+}
+
+// Encode marshals a message, refusing ones without a known type
+func Encode(msg Message) ([]byte, error) {
+	msgType := msg.Header().Type
+	if _, ok := messageTypes[msgType]; !ok {
+		return nil, fmt.Errorf("can't encode message of unknown type %q", msgType)
+	}
+	return json.Marshal(msg)
+}
+
+// Decode reads the envelope of a message and then the message itself into
+// the struct for its type, validating any required fields. The envelope is
+// returned even when decoding fails so the error can be sent back with its id.
+func Decode(data []byte) (Envelope, Message, error) {
+	var envelope Envelope
+	err := json.Unmarshal(data, &envelope)
+	if err != nil {
+		return envelope, nil, Errorf(CodeBadJSON, "%v", err)
+	}
+	newMessage, ok := messageTypes[envelope.Type]
+	if !ok {
+		return envelope, nil, Errorf(CodeUnknownType, "unknown message type %q", envelope.Type)
+	}
+	msg := newMessage()
+	err = json.Unmarshal(data, msg)
+	if err != nil {
+		return envelope, nil, Errorf(CodeInvalidField, "%v", err)
+	}
+	if v, ok := msg.(validator); ok {
+		err = v.Validate()
+		if err != nil {
+			return envelope, nil, err
+		}
+	}
+	return envelope, msg, nil
+}
+
+// NewAck acknowledges a request that succeeded
+func NewAck(request Envelope) *AckMessage {
+	return &AckMessage{Envelope: Envelope{Type: TypeAck, ID: request.ID}, For: request.Type}
+}
+
+// NewError tells the client why a request was rejected. Errors that aren't
+// protocol Errors come from game rules and are sent with CodeRejected.
+func NewError(request Envelope, err error) *ErrorMessage {
+	msg := &ErrorMessage{Envelope: Envelope{Type: TypeError, ID: request.ID}, For: request.Type, Code: CodeRejected, Message: err.Error()}
+	var protoErr *Error
+	if errors.As(err, &protoErr) {
+		msg.Code = protoErr.Code
+		msg.Message = protoErr.Message
+	}
+	return msg
+}
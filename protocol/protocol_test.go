@@ -0,0 +1,244 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A valid message of every type, with every field that's encoded filled in
+func sampleMessages() []Message {
+	tick := int64(120)
+	return []Message{
+		NewLogin("ada", "hunter2"),
+		NewViewport(Viewport{X: 10.5, Y: 20, Width: 40, Height: 22.5}),
+		NewUpdateTile(3, 4, 5),
+		NewPlaceHive(100, 200),
+		NewBuildNest(7, 8, 2),
+		NewPostTask(TaskHaulOre, 9, 10),
+		NewCancelTask(9, 10),
+		NewPlaceDrone(),
+		NewResetTiles(),
+		NewSetOverlays([]string{FieldMoisture, FieldPollution}),
+		NewIgnite(11, 12),
+		NewUndo(),
+		NewRedo(),
+		NewResync([]int{0, 5, HashChunks - 1}),
+		NewCursor(12.25, 13.5),
+		NewChat(ChannelWhisper, "grace", "hello there"),
+		NewPlaceStructure(StructureRoad, 14, 15, 3),
+		NewEditTypes(1, 2, 2, 2, []int{1, 2, LeaveTile, 3}),
+		NewSaveWorld("autumn_2"),
+		NewLoadWorld("autumn_2"),
+		NewPause(true),
+		NewSetSpeed(4),
+		NewSetTickRate(30),
+		NewSetParam("growthRate", 0.5),
+		NewKick("mallory", "griefing"),
+		NewAnnounce("Restarting in five minutes"),
+		NewSetRole("grace", RoleAdmin),
+		&RollbackMessage{Envelope: Envelope{Type: TypeRollback}, Username: "mallory", FromTick: &tick, ToTick: &tick},
+
+		&TilesMessage{
+			Envelope:    Envelope{Type: TypeTiles},
+			Clock:       ClockState{Tick: 42, TicksPerSecond: 4, Speed: 1, Measured: 3.5},
+			Time:        WorldTime{Day: 2, Season: "summer", TimeOfDay: 0.5, Daylight: 1, Temperature: 21.5},
+			Weather:     WeatherState{CellSize: 50, WindX: 0.1, WindY: -0.2, Cells: [][]int{{-100, 0}, {50, 100}}},
+			Overlays:    []FieldOverlay{{Field: FieldVegetation, X: 1, Y: 2, Width: 2, Height: 1, Values: []byte{0, 255}}},
+			Chunks:      []TileChunk{{Index: 3, Tiles: []int{1, 2, 3}}},
+			Hash:        1<<63 + 1,
+			ChunkHashes: []uint32{1, 2, 3},
+			Hives:       []HiveState{{ID: 1, Owner: "ada", X: 5, Y: 6, Inventory: map[string]int{"ore": 3}, Jobs: 1, Tasks: 2}},
+			Drones:      []DroneState{{ID: 2, Hive: 1, X: 7, Y: 8, State: 3, Task: 4, Capabilities: []string{"harvest"}}},
+			Tasks:       []TaskState{{ID: 4, Hive: 1, Kind: TaskHaulOre, X: 9, Y: 10, State: "bidding", Required: 2, Bids: 1, Team: []int{2}, Progress: 1, Steps: 5}},
+			Animals:     []AnimalState{{Kind: "deer", X: 11, Y: 12}},
+		},
+		&InventoryMessage{Envelope: Envelope{Type: TypeInventory}, Resources: map[string]int{"ore": 1, "wood": 0}},
+		&TerritoryMessage{Envelope: Envelope{Type: TypeTerritory}, ChunkSize: 50, ChunksWide: 2, ChunksHigh: 1, Owners: []int{0, 1}, Players: []string{"", "ada"}},
+		&SessionMessage{Envelope: Envelope{Type: TypeSession}, Version: Version, Token: "abc", Username: "ada", Role: RolePlayer, Viewport: Viewport{X: 1, Y: 2, Width: 3, Height: 4}, Resumed: true},
+		NewAck(Envelope{Type: TypePlaceHive, ID: "3"}),
+		NewError(Envelope{Type: TypePlaceHive, ID: "4"}, Errorf(CodeNoHive, "no hive")),
+		&StatsMessage{Envelope: Envelope{Type: TypeStats}, Tick: 99, Populations: []PopulationStats{{Kind: "deer", Count: 10, Births: 2, Deaths: 1, Energy: 0.75}}},
+		&PresenceMessage{Envelope: Envelope{Type: TypePresence}, Users: []UserPresence{{Username: "ada", Role: RoleAdmin, Viewport: Viewport{Width: 10, Height: 5}, CursorX: 1.5, CursorY: 2.5}}, Anonymous: 2},
+		&ChatLinesMessage{Envelope: Envelope{Type: TypeChatLines}, Lines: []ChatLine{{Channel: ChannelGlobal, From: "ada", Text: "hi"}, {Channel: ChannelSystem, Text: "Day 2 has begun"}}, History: true},
+	}
+}
+
+func TestEveryTypeRoundTrips(t *testing.T) {
+	samples := sampleMessages()
+	covered := make(map[string]bool)
+	for i, msg := range samples {
+		msgType := msg.Header().Type
+		covered[msgType] = true
+		if msg.Header().ID == "" {
+			msg.Header().ID = fmt.Sprint(i + 1)
+		}
+
+		data, err := Encode(msg)
+		if err != nil {
+			t.Errorf("%s: Encode: %v", msgType, err)
+			continue
+		}
+		envelope, decoded, err := Decode(data)
+		if err != nil {
+			t.Errorf("%s: Decode %s: %v", msgType, data, err)
+			continue
+		}
+		if envelope != *msg.Header() {
+			t.Errorf("%s: decoded envelope %+v, expected %+v", msgType, envelope, *msg.Header())
+		}
+		if !reflect.DeepEqual(decoded, msg) {
+			t.Errorf("%s: decoded\n%+v\nexpected\n%+v", msgType, decoded, msg)
+		}
+	}
+	for msgType := range messageTypes {
+		if !covered[msgType] {
+			t.Errorf("no sample message for type %q", msgType)
+		}
+	}
+}
+
+func TestEditAltitudeRoundTrips(t *testing.T) {
+	msg := NewEditAltitude(5, 6, 1, 3, []float64{0.01, -0.05, 0})
+	data, err := Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, decoded, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, msg) {
+		t.Errorf("decoded %+v, expected %+v", decoded, msg)
+	}
+}
+
+func TestEncodeRefusesUnknownTypes(t *testing.T) {
+	if _, err := Encode(&AckMessage{Envelope: Envelope{Type: "nonsense"}}); err == nil {
+		t.Error("encoded a message of unknown type")
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	longChat := strings.Repeat("a", MaxChatLength+1)
+	longAnnouncement := strings.Repeat("a", maxAnnouncementLength+1)
+	manyChunks := strings.TrimSuffix(strings.Repeat("0,", MaxResyncChunks+1), ",")
+
+	tests := []struct {
+		name    string
+		request string
+		code    string
+	}{
+		{"not JSON", `{"type":`, CodeBadJSON},
+		{"unknown type", `{"type":"fly"}`, CodeUnknownType},
+		{"missing type", `{"x":1}`, CodeUnknownType},
+		{"field of the wrong type", `{"type":"placeHive","x":"1","y":2}`, CodeInvalidField},
+
+		{"login with an old version", `{"type":"login","version":1,"username":"ada"}`, CodeVersionMismatch},
+		{"login without a username or token", `{"type":"login","version":` + fmt.Sprint(Version) + `}`, CodeMissingField},
+
+		{"viewport with a negative width", `{"type":"viewport","x":1,"y":2,"width":-40,"height":20}`, CodeInvalidField},
+		{"viewport with a negative height", `{"type":"viewport","x":1,"y":2,"width":40,"height":-20}`, CodeInvalidField},
+
+		{"updateTile without a value", `{"type":"updateTile","x":1,"y":2}`, CodeMissingField},
+		{"placeHive without y", `{"type":"placeHive","x":1}`, CodeMissingField},
+		{"buildNest without a value", `{"type":"buildNest","x":1,"y":2}`, CodeMissingField},
+		{"postTask without a kind", `{"type":"postTask","x":1,"y":2}`, CodeMissingField},
+		{"postTask without x", `{"type":"postTask","kind":"haulOre","y":2}`, CodeMissingField},
+		{"cancelTask without y", `{"type":"cancelTask","x":1}`, CodeMissingField},
+		{"ignite without x", `{"type":"ignite","y":2}`, CodeMissingField},
+
+		{"editTiles without a size", `{"type":"editTiles","x":1,"y":2,"types":[1]}`, CodeMissingField},
+		{"editTiles without an edit", `{"type":"editTiles","x":1,"y":2,"width":1,"height":1}`, CodeMissingField},
+		{"editTiles with both edits", `{"type":"editTiles","x":1,"y":2,"width":1,"height":1,"types":[1],"altitude":[0]}`, CodeInvalidField},
+		{"editTiles too wide", fmt.Sprintf(`{"type":"editTiles","x":1,"y":2,"width":%d,"height":1,"types":[1]}`, MaxEditSize+1), CodeInvalidField},
+		{"editTiles with too few types", `{"type":"editTiles","x":1,"y":2,"width":2,"height":1,"types":[1]}`, CodeInvalidField},
+		{"editTiles raising too far", `{"type":"editTiles","x":1,"y":2,"width":1,"height":1,"altitude":[0.06]}`, CodeInvalidField},
+
+		{"resync without chunks", `{"type":"resync","chunks":[]}`, CodeInvalidField},
+		{"resync with too many chunks", `{"type":"resync","chunks":[` + manyChunks + `]}`, CodeInvalidField},
+		{"resync of a chunk off the map", fmt.Sprintf(`{"type":"resync","chunks":[%d]}`, HashChunks), CodeInvalidField},
+		{"resync of a negative chunk", `{"type":"resync","chunks":[-1]}`, CodeInvalidField},
+
+		{"unknown overlay", `{"type":"setOverlays","fields":["heat"]}`, CodeInvalidField},
+		{"overlay listed twice", `{"type":"setOverlays","fields":["moisture","moisture"]}`, CodeInvalidField},
+
+		{"unknown structure", `{"type":"placeStructure","structure":"castle","x":1,"y":2}`, CodeInvalidField},
+		{"structure rotated too far", `{"type":"placeStructure","structure":"road","x":1,"y":2,"rotation":4}`, CodeInvalidField},
+		{"structure without y", `{"type":"placeStructure","structure":"road","x":1}`, CodeMissingField},
+
+		{"chat on an unknown channel", `{"type":"chat","channel":"team","text":"hi"}`, CodeInvalidField},
+		{"whisper to nobody", `{"type":"chat","channel":"whisper","text":"hi"}`, CodeMissingField},
+		{"blank chat", `{"type":"chat","channel":"global","text":"  "}`, CodeMissingField},
+		{"chat that's too long", `{"type":"chat","channel":"global","text":"` + longChat + `"}`, CodeInvalidField},
+		{"chat with a control character", `{"type":"chat","channel":"global","text":"a\u0007b"}`, CodeInvalidField},
+
+		{"save without a name", `{"type":"saveWorld"}`, CodeInvalidField},
+		{"save with a path", `{"type":"saveWorld","name":"../users"}`, CodeInvalidField},
+		{"load with a long name", `{"type":"loadWorld","name":"` + strings.Repeat("a", 33) + `"}`, CodeInvalidField},
+		{"pause without paused", `{"type":"pause"}`, CodeMissingField},
+		{"unsupported speed", `{"type":"setSpeed","speed":3}`, CodeInvalidField},
+		{"tick rate too low", `{"type":"setTickRate","ticksPerSecond":0.5}`, CodeInvalidField},
+		{"tick rate too high", `{"type":"setTickRate","ticksPerSecond":61}`, CodeInvalidField},
+		{"setParam without a value", `{"type":"setParam","name":"growthRate"}`, CodeMissingField},
+		{"kick without a username", `{"type":"kick"}`, CodeMissingField},
+		{"empty announcement", `{"type":"announce","message":""}`, CodeMissingField},
+		{"announcement that's too long", `{"type":"announce","message":"` + longAnnouncement + `"}`, CodeInvalidField},
+		{"setRole without a username", `{"type":"setRole","role":"admin"}`, CodeMissingField},
+		{"unknown role", `{"type":"setRole","username":"ada","role":"king"}`, CodeInvalidField},
+		{"rollback without fromTick", `{"type":"rollback","username":"ada"}`, CodeMissingField},
+		{"rollback from a negative tick", `{"type":"rollback","username":"ada","fromTick":-1}`, CodeInvalidField},
+		{"rollback ending before it starts", `{"type":"rollback","username":"ada","fromTick":10,"toTick":5}`, CodeInvalidField},
+	}
+	for _, test := range tests {
+		_, msg, err := Decode([]byte(test.request))
+		if err == nil {
+			t.Errorf("%s: decoded %+v", test.name, msg)
+			continue
+		}
+		var protoErr *Error
+		if !errors.As(err, &protoErr) {
+			t.Errorf("%s: error %v isn't a protocol Error", test.name, err)
+		} else if protoErr.Code != test.code {
+			t.Errorf("%s: code %s (%s), expected %s", test.name, protoErr.Code, protoErr.Message, test.code)
+		}
+	}
+}
+
+// encoding/json replaces invalid UTF-8 before Validate sees it, but chat
+// built in Go can still hold it
+func TestChatRejectsInvalidUTF8(t *testing.T) {
+	err := NewChat(ChannelGlobal, "", "a\xffb").Validate()
+	var protoErr *Error
+	if !errors.As(err, &protoErr) || protoErr.Code != CodeInvalidField {
+		t.Errorf("Validate = %v", err)
+	}
+}
+
+// JSON can't carry NaN or infinity, but a viewport built in code can
+func TestViewportRejectsNonFinite(t *testing.T) {
+	for _, viewport := range []Viewport{
+		{X: math.NaN(), Width: 10, Height: 10},
+		{Y: math.Inf(-1), Width: 10, Height: 10},
+		{Width: math.Inf(1), Height: 10},
+		{Width: 10, Height: math.NaN()},
+	} {
+		if NewViewport(viewport).Validate() == nil {
+			t.Errorf("accepted viewport %+v", viewport)
+		}
+	}
+}
+
+// Decode returns the envelope of a rejected request so its error can be answered with the id
+func TestDecodeKeepsEnvelopeOnError(t *testing.T) {
+	envelope, _, err := Decode([]byte(`{"type":"placeHive","id":"17","x":1}`))
+	if err == nil {
+		t.Fatal("decoded a placeHive without y")
+	}
+	if envelope != (Envelope{Type: TypePlaceHive, ID: "17"}) {
+		t.Errorf("envelope is %+v", envelope)
+	}
+}
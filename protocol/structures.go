@@ -0,0 +1,127 @@
+// structures.go
+package protocol
+
+// Structures are multi-tile buildings from a catalog, placed with a
+// placeStructure request and built a tile at a time by the hive's drones.
+// Footprints are rows of tiles from top to bottom. Each character is a tile
+// from StructureTiles, and anything else leaves the ground as it is.
+type Structure struct {
+	Name      string
+	Footprint []string
+}
+
+const (
+	StructurePlatform    = "platform"
+	StructureRoad        = "road"
+	StructureCorner      = "corner"
+	StructureNestBlock   = "nestBlock"
+	StructurePumpStation = "pumpStation"
+)
+
+var StructureTiles = map[byte]int{'C': Concrete, 'N': Nest, 'P': Pump}
+
+var Structures = []Structure{
+	{Name: StructurePlatform, Footprint: []string{
+		".CCCCC.",
+		"CCCCCCC",
+		"CCCCCCC",
+		"CCCCCCC",
+		"CCCCCCC",
+		"CCCCCCC",
+		".CCCCC.",
+	}},
+	{Name: StructureRoad, Footprint: []string{"CCCCC"}},
+	{Name: StructureCorner, Footprint: []string{
+		"CCC",
+		"C..",
+		"C..",
+	}},
+	{Name: StructureNestBlock, Footprint: []string{
+		"NNN",
+		"NNN",
+		"NNN",
+	}},
+	{Name: StructurePumpStation, Footprint: []string{
+		"CCC",
+		"CPC",
+		"CCC",
+	}},
+}
+
+func FindStructure(name string) (Structure, bool) {
+	for _, structure := range Structures {
+		if structure.Name == name {
+			return structure, true
+		}
+	}
+	return Structure{}, false
+}
+
+// A StructureTile is one tile of a placed structure
+type StructureTile struct {
+	X    int
+	Y    int
+	Type int
+}
+
+// Tiles returns the tiles of the structure placed with the middle of its
+// footprint at (x, y), turned clockwise by rotation quarter turns
+func (s Structure) Tiles(x, y, rotation int) []StructureTile {
+	tiles := []StructureTile{}
+	middleY := (len(s.Footprint) - 1) / 2
+	for row, line := range s.Footprint {
+		middleX := (len(line) - 1) / 2
+		for column := 0; column < len(line); column++ {
+			tileType, ok := StructureTiles[line[column]]
+			if !ok {
+				continue
+			}
+			dx, dy := column-middleX, row-middleY
+			for turn := 0; turn < rotation%4; turn++ {
+				dx, dy = -dy, dx
+			}
+			tiles = append(tiles, StructureTile{X: x + dx, Y: y + dy, Type: tileType})
+		}
+	}
+	return tiles
+}
+
+// IsOpenGround reports whether structures can be built on a tile type
+func IsOpenGround(tileType int) bool {
+	switch tileType {
+	case Sand, Grass, Forest, Dirt, Mountains, Snow, Puddle, Burnt, Shrub:
+		return true
+	}
+	return false
+}
+
+// CanBuildOn reports whether a structure tile can be built on the ground.
+// Pumps can only go on oilspouts, and everything else on open ground.
+func CanBuildOn(tileType, ground int) bool {
+	if tileType == Pump {
+		return ground == Oilspout
+	}
+	return IsOpenGround(ground)
+}
+
+type PlaceStructureMessage struct {
+	Envelope
+	Structure string `json:"structure"`
+	X         *int   `json:"x"`
+	Y         *int   `json:"y"`
+	Rotation  int    `json:"rotation"` // quarter turns clockwise, from 0 to 3
+}
+
+func NewPlaceStructure(structure string, x, y, rotation int) *PlaceStructureMessage {
+	return &PlaceStructureMessage{Envelope: Envelope{Type: TypePlaceStructure}, Structure: structure, X: &x, Y: &y, Rotation: rotation}
+}
+
+func (m *PlaceStructureMessage) Validate() error {
+	if _, ok := FindStructure(m.Structure); !ok {
+		return Errorf(CodeInvalidField, "unknown structure %q", m.Structure)
+	}
+	if m.Rotation < 0 || m.Rotation > 3 {
+		return Errorf(CodeInvalidField, "rotation must be between 0 and 3")
+	}
+	return requireFields(intField{"x", m.X}, intField{"y", m.Y})
+}
@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+)
+
+// Draws the tiles of a structure placed at (0, 0) as footprint rows, so
+// rotations can be compared with how they look
+func drawStructure(tiles []StructureTile) []string {
+	minX, minY, maxX, maxY := 0, 0, 0, 0
+	for _, tile := range tiles {
+		minX, minY = min(minX, tile.X), min(minY, tile.Y)
+		maxX, maxY = max(maxX, tile.X), max(maxY, tile.Y)
+	}
+	rows := make([][]byte, maxY-minY+1)
+	for i := range rows {
+		rows[i] = []byte(strings.Repeat(".", maxX-minX+1))
+	}
+	for _, tile := range tiles {
+		for symbol, tileType := range StructureTiles {
+			if tileType == tile.Type {
+				rows[tile.Y-minY][tile.X-minX] = symbol
+			}
+		}
+	}
+	drawn := make([]string, len(rows))
+	for i, row := range rows {
+		drawn[i] = string(row)
+	}
+	return drawn
+}
+
+func TestStructureRotation(t *testing.T) {
+	corner, _ := FindStructure(StructureCorner)
+	tests := []struct {
+		rotation int
+		expected []string
+	}{
+		{0, []string{"CCC", "C..", "C.."}},
+		{1, []string{"CCC", "..C", "..C"}},
+		{2, []string{"..C", "..C", "CCC"}},
+		{3, []string{"C..", "C..", "CCC"}},
+	}
+	for _, test := range tests {
+		drawn := drawStructure(corner.Tiles(0, 0, test.rotation))
+		if strings.Join(drawn, "/") != strings.Join(test.expected, "/") {
+			t.Errorf("rotation %d drew %v, expected %v", test.rotation, drawn, test.expected)
+		}
+	}
+
+	road, _ := FindStructure(StructureRoad)
+	if drawn := drawStructure(road.Tiles(0, 0, 1)); len(drawn) != 5 || drawn[0] != "C" {
+		t.Errorf("a road turned a quarter drew %v", drawn)
+	}
+}
+
+// Structures are placed by the middle of their footprint
+func TestStructureTilesArePlacedByMiddle(t *testing.T) {
+	pumpStation, _ := FindStructure(StructurePumpStation)
+	for rotation := 0; rotation < 4; rotation++ {
+		tiles := pumpStation.Tiles(20, 30, rotation)
+		if len(tiles) != 9 {
+			t.Fatalf("pump station has %d tiles", len(tiles))
+		}
+		for _, tile := range tiles {
+			if (tile.Type == Pump) != (tile.X == 20 && tile.Y == 30) {
+				t.Errorf("rotation %d put tile type %d at (%d, %d)", rotation, tile.Type, tile.X, tile.Y)
+			}
+		}
+	}
+}
+
+func TestCatalogFootprints(t *testing.T) {
+	for _, structure := range Structures {
+		if len(structure.Tiles(0, 0, 0)) == 0 {
+			t.Errorf("%s has no tiles", structure.Name)
+		}
+		for _, line := range structure.Footprint {
+			if len(line) != len(structure.Footprint[0]) {
+				t.Errorf("%s has rows of different lengths", structure.Name)
+			}
+		}
+		if found, ok := FindStructure(structure.Name); !ok || found.Name != structure.Name {
+			t.Errorf("can't find %s in the catalog", structure.Name)
+		}
+	}
+	if !CanBuildOn(Pump, Oilspout) || CanBuildOn(Pump, Grass) || CanBuildOn(Concrete, Oilspout) || CanBuildOn(Concrete, DeepWater) {
+		t.Error("pumps and concrete can go on the wrong ground")
+	}
+}
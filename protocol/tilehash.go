@@ -0,0 +1,41 @@
+// tilehash.go
+package protocol
+
+// The tile grid is hashed in square chunks so the client can tell which parts
+// of its copy of the map have drifted from the server's. A chunk's hash is the
+// sum of a hash of each tile's position and type, so it can be kept up to date
+// one tile at a time, and the world's hash is the sum of its chunks' hashes.
+const HashChunkSize = 32
+
+const (
+	HashChunksWide = (TilesWide + HashChunkSize - 1) / HashChunkSize
+	HashChunksHigh = (TilesHigh + HashChunkSize - 1) / HashChunkSize
+	HashChunks     = HashChunksWide * HashChunksHigh
+)
+
+// TileHash mixes a tile's position and type with splitmix64
+func TileHash(x, y, tileType int) uint64 {
+	z := uint64(x)<<40 | uint64(y)<<16 | uint64(uint16(tileType))
+	z += 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// ChunkIndex is where a chunk's hash is found in a frame's ChunkHashes
+func ChunkIndex(chunkX, chunkY int) int {
+	return chunkX*HashChunksHigh + chunkY
+}
+
+// ChunkBounds returns the tiles a chunk covers. Chunks along the right and
+// bottom edges of the map are smaller than the rest.
+func ChunkBounds(index int) (x, y, width, height int) {
+	x = index / HashChunksHigh * HashChunkSize
+	y = index % HashChunksHigh * HashChunkSize
+	return x, y, min(HashChunkSize, TilesWide-x), min(HashChunkSize, TilesHigh-y)
+}
+
+// ShortHash is the part of a chunk's hash sent in every frame
+func ShortHash(hash uint64) uint32 {
+	return uint32(hash ^ hash>>32)
+}
@@ -0,0 +1,52 @@
+package protocol
+
+import "testing"
+
+func TestShortHash(t *testing.T) {
+	if ShortHash(0) != 0 {
+		t.Errorf("ShortHash(0) = %d", ShortHash(0))
+	}
+	// Both halves of the hash count
+	if ShortHash(1) == ShortHash(0) || ShortHash(1<<32) == ShortHash(0) {
+		t.Error("ShortHash ignores part of the hash")
+	}
+	hash := TileHash(12, 34, Grass)
+	if ShortHash(hash) != uint32(hash)^uint32(hash>>32) {
+		t.Errorf("ShortHash(%016x) = %08x", hash, ShortHash(hash))
+	}
+}
+
+func TestTileHash(t *testing.T) {
+	hash := TileHash(5, 7, Sand)
+	for _, other := range []uint64{TileHash(7, 5, Sand), TileHash(5, 8, Sand), TileHash(6, 7, Sand), TileHash(5, 7, Grass)} {
+		if other == hash {
+			t.Errorf("TileHash collides for different tiles: %016x", hash)
+		}
+	}
+	if TileHash(5, 7, Sand) != hash {
+		t.Error("TileHash isn't deterministic")
+	}
+}
+
+// Every tile is in exactly one chunk
+func TestChunkBoundsCoverMap(t *testing.T) {
+	var covered [TilesWide][TilesHigh]int
+	for index := 0; index < HashChunks; index++ {
+		x, y, width, height := ChunkBounds(index)
+		if ChunkIndex(x/HashChunkSize, y/HashChunkSize) != index {
+			t.Fatalf("chunk %d starts at %d, %d, which is in chunk %d", index, x, y, ChunkIndex(x/HashChunkSize, y/HashChunkSize))
+		}
+		for i := x; i < x+width; i++ {
+			for j := y; j < y+height; j++ {
+				covered[i][j]++
+			}
+		}
+	}
+	for i := range covered {
+		for j := range covered[i] {
+			if covered[i][j] != 1 {
+				t.Fatalf("tile %d, %d is in %d chunks", i, j, covered[i][j])
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// world.go
+package protocol
+
+// Size of the world in tiles
+const (
+	TilesWide = 80 * 30
+	TilesHigh = 45 * 30
+)
+
+// Tile type IDs as sent in tiles messages
+const (
+	DeepWater     = 0
+	ShallowWater  = 1
+	Sand          = 2
+	Grass         = 3
+	Forest        = 4
+	Dirt          = 5
+	Mountains     = 6
+	HighMountains = 7
+	Concrete      = 8
+	Nest          = 9
+	HiveCore      = 10
+	Bridge        = 11
+	Oilspout      = 12
+	Ice           = 13
+	Snow          = 14
+	Puddle        = 15
+	Pump          = 16
+	Fire          = 17
+	Burnt         = 18
+	Shrub         = 19
+)
+
+// Seasons of the world clock, in order
+const (
+	SeasonSpring = "spring"
+	SeasonSummer = "summer"
+	SeasonAutumn = "autumn"
+	SeasonWinter = "winter"
+)
+
+var Seasons = []string{SeasonSpring, SeasonSummer, SeasonAutumn, SeasonWinter}
+
+// Kinds of wild animal
+const (
+	AnimalGrazer   = "grazer"
+	AnimalPredator = "predator"
+)
+
+var AnimalKinds = []string{AnimalGrazer, AnimalPredator}
+
+// Resources held in player inventories
+const (
+	ResourceNutrients = "nutrients"
+	ResourceOil       = "oil"
+	ResourceStone     = "stone"
+	ResourceMinerals  = "minerals"
+)
+
+var ResourceTypes = []string{ResourceNutrients, ResourceOil, ResourceStone, ResourceMinerals}
+
+// Kinds of cooperative drone tasks that can be posted
+const (
+	TaskHaulOre       = "haulOre"
+	TaskBridgeWater   = "bridgeWater"
+	TaskClearMountain = "clearMountain"
+)
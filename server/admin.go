@@ -0,0 +1,169 @@
+// admin.go
+package main
+
+import (
+	"fmt"
+
+	"growth-protocol"
+)
+
+// Simulation parameters admins may tune while the server runs. Altitude
+// thresholds take effect on the next tick, the perlin ones on the next reset.
+type tunableParam struct {
+	value *float64
+	min   float64
+	max   float64
+}
+
+var tunableParams = map[string]tunableParam{
+	"alpha":                    {&alpha, 1, 10},
+	"beta":                     {&beta, 1, 10},
+	"initDeepWaterAltitude":    {&initDeepWaterAltitude, 0, 1},
+	"initShallowWaterAltitude": {&initShallowWaterAltitude, 0, 1},
+	"sandAltitude":             {&sandAltitude, 0, 1},
+	"grassAltitude":            {&grassAltitude, 0, 1},
+	"forestAltitude":           {&forestAltitude, 0, 1},
+	"dirtAltitude":             {&dirtAltitude, 0, 1},
+	"mountainsAltitude":        {&mountainsAltitude, 0, 1},
+}
+
+// The altitude bands only make sense in increasing order
+func altitudesOrdered() bool {
+	bands := []float64{initDeepWaterAltitude, initShallowWaterAltitude, sandAltitude, grassAltitude, forestAltitude, dirtAltitude, mountainsAltitude}
+	for i := 1; i < len(bands); i++ {
+		if bands[i] <= bands[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+func handleResetTiles(client *Client, msg *protocol.ResetTilesMessage) (protocol.Message, error) {
+	if playback != nil {
+		return nil, protocol.Errorf(protocol.CodeForbidden, "the world can't be reset while a replay is playing")
+	}
+	fmt.Println("World reset by", client.Session.Username)
+	resetSimulation()
+	announce("%s reset the world", client.Session.Username)
+	return nil, nil
+}
+
+func handleSaveWorld(client *Client, msg *protocol.SaveWorldMessage) (protocol.Message, error) {
+	err := saveWorld(msg.Name)
+	if err != nil {
+		return nil, err
+	}
+	announce("%s saved the world as %s", client.Session.Username, msg.Name)
+	return nil, nil
+}
+
+func handleLoadWorld(client *Client, msg *protocol.LoadWorldMessage) (protocol.Message, error) {
+	if playback != nil {
+		return nil, protocol.Errorf(protocol.CodeForbidden, "worlds can't be loaded while a replay is playing")
+	}
+	err := loadWorld(msg.Name)
+	if err != nil {
+		return nil, err
+	}
+	announce("%s loaded %s", client.Session.Username, msg.Name)
+	return nil, nil
+}
+
+func handlePause(client *Client, msg *protocol.PauseMessage) (protocol.Message, error) {
+	worldLock.Lock()
+	defer worldLock.Unlock()
+	simClock.Paused = *msg.Paused
+	fmt.Println("Simulation paused:", simClock.Paused, "by", client.Session.Username)
+	return nil, nil
+}
+
+func handleSetSpeed(client *Client, msg *protocol.SetSpeedMessage) (protocol.Message, error) {
+	worldLock.Lock()
+	defer worldLock.Unlock()
+	simClock.Speed = *msg.Speed
+	fmt.Println("Simulation speed set to", simClock.Speed, "by", client.Session.Username)
+	return nil, nil
+}
+
+func handleSetTickRate(client *Client, msg *protocol.SetTickRateMessage) (protocol.Message, error) {
+	worldLock.Lock()
+	defer worldLock.Unlock()
+	simClock.TicksPerSecond = *msg.TicksPerSecond
+	fmt.Println("Simulation tick rate set to", simClock.TicksPerSecond, "by", client.Session.Username)
+	return nil, nil
+}
+
+func handleSetParam(client *Client, msg *protocol.SetParamMessage) (protocol.Message, error) {
+	param, ok := tunableParams[msg.Name]
+	if !ok {
+		return nil, protocol.Errorf(protocol.CodeInvalidField, "unknown parameter %q", msg.Name)
+	}
+	value := *msg.Value
+	if value < param.min || value > param.max {
+		return nil, protocol.Errorf(protocol.CodeInvalidField, "%s must be between %v and %v", msg.Name, param.min, param.max)
+	}
+	previous := *param.value
+	*param.value = value
+	if !altitudesOrdered() {
+		*param.value = previous
+		return nil, fmt.Errorf("%s = %v would put the altitude bands out of order", msg.Name, value)
+	}
+	fmt.Printf("%s set %s from %v to %v\n", client.Session.Username, msg.Name, previous, value)
+	return nil, nil
+}
+
+// Kicked clients are only disconnected, they may log back in
+func handleKick(client *Client, msg *protocol.KickMessage) (protocol.Message, error) {
+	var kicked []*Client
+	worldLock.Lock()
+	for _, c := range connectedClients() {
+		if c.Session != nil && c.Session.Username == msg.Username {
+			kicked = append(kicked, c)
+		}
+	}
+	worldLock.Unlock()
+	if len(kicked) == 0 {
+		return nil, fmt.Errorf("%s is not connected", msg.Username)
+	}
+	reason := "kicked by " + client.Session.Username
+	if msg.Reason != "" {
+		reason += ": " + msg.Reason
+	}
+	for _, c := range kicked {
+		c.disconnect(reason)
+	}
+	fmt.Println(msg.Username, "was", reason)
+	return nil, nil
+}
+
+// Admin announcements go out on the system channel like the server's own, so
+// they're kept in the chat history
+func handleAnnounce(client *Client, msg *protocol.AnnounceMessage) (protocol.Message, error) {
+	announce("%s: %s", client.Session.Username, msg.Message)
+	return nil, nil
+}
+
+func handleRollback(client *Client, msg *protocol.RollbackMessage) (protocol.Message, error) {
+	toTick := simClock.Tick
+	if msg.ToTick != nil {
+		toTick = *msg.ToTick
+	}
+	operations, err := rollbackEdits(msg.Username, *msg.FromTick, toTick)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("%s rolled back %d edits by %s from tick %d to %d\n", client.Session.Username, operations, msg.Username, *msg.FromTick, toTick)
+	return nil, nil
+}
+
+func handleSetRole(client *Client, msg *protocol.SetRoleMessage) (protocol.Message, error) {
+	if msg.Username == client.Session.Username {
+		return nil, fmt.Errorf("admins can't change their own role")
+	}
+	err := setUserRole(msg.Username, msg.Role)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println(client.Session.Username, "made", msg.Username, "a", msg.Role)
+	return nil, nil
+}
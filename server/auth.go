@@ -0,0 +1,271 @@
+// auth.go
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"growth-protocol"
+)
+
+// Users are kept in a local JSON file. Logging in with an unknown username
+// registers it as a player with the given password. Nobody becomes an admin
+// by registering: the server's -admin flag makes the named user one, and
+// after that roles can be changed with setRole or by editing the file.
+type User struct {
+	Name         string `json:"name"`
+	Salt         string `json:"salt"`
+	PasswordHash string `json:"passwordHash"`
+	Role         string `json:"role,omitempty"`
+}
+
+// A Session lets a client reconnect with its token instead of logging in again
+type Session struct {
+	Token    string
+	Username string
+	Role     string
+	Viewport protocol.Viewport
+	CursorX  float64 // the tile under the mouse, shown to other players
+	CursorY  float64
+	Overlays []string // fields streamed for the viewport
+	Expires  time.Time
+}
+
+const (
+	userStorePath      = "users.json"
+	sessionTTL         = 24 * time.Hour
+	sessionSweepPeriod = time.Hour
+	passwordHashRounds = 10000
+	minPasswordLength  = 4
+	maxUsernameLength  = 24
+)
+
+var users = make(map[string]*User)
+var sessions = make(map[string]*Session)
+var authLock sync.Mutex // guards users and sessions
+
+func loadUsers() error {
+	authLock.Lock()
+	defer authLock.Unlock()
+	data, err := os.ReadFile(userStorePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var stored []*User
+	err = json.Unmarshal(data, &stored)
+	if err != nil {
+		return err
+	}
+	for _, user := range stored {
+		if !protocol.IsRole(user.Role) {
+			user.Role = protocol.RolePlayer
+		}
+		users[user.Name] = user
+	}
+	return nil
+}
+
+// Makes the named user an admin. If they haven't registered yet the account
+// is created with a random password, which is printed so the operator can
+// log in with it.
+func bootstrapAdmin(username string) error {
+	if len(username) > maxUsernameLength {
+		return fmt.Errorf("usernames must be between 1 and %d characters", maxUsernameLength)
+	}
+	authLock.Lock()
+	defer authLock.Unlock()
+	user, ok := users[username]
+	if !ok {
+		password, err := randomHex(12)
+		if err != nil {
+			return err
+		}
+		salt, err := randomHex(16)
+		if err != nil {
+			return err
+		}
+		user = &User{Name: username, Salt: salt, PasswordHash: hashPassword(password, salt)}
+		users[username] = user
+		fmt.Printf("Registered admin %s with password %s\n", username, password)
+	}
+	if ok && user.Role == protocol.RoleAdmin {
+		return nil
+	}
+	user.Role = protocol.RoleAdmin
+	fmt.Println("Made", username, "an admin")
+	return saveUsers()
+}
+
+// Must be called with authLock held
+func saveUsers() error {
+	stored := make([]*User, 0, len(users))
+	for _, user := range users {
+		stored = append(stored, user)
+	}
+	data, err := json.MarshalIndent(stored, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(userStorePath, data, 0600)
+}
+
+func randomHex(numBytes int) (string, error) {
+	buf := make([]byte, numBytes)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Salted and iterated so a leaked users.json isn't trivial to brute force
+func hashPassword(password, salt string) string {
+	sum := sha256.Sum256([]byte(salt + password))
+	for i := 1; i < passwordHashRounds; i++ {
+		sum = sha256.Sum256(append(sum[:], salt...))
+	}
+	return hex.EncodeToString(sum[:])
+}
+
+// Checks the password, registering the user if they don't exist yet, and starts a new session
+func authenticate(username, password string) (*Session, error) {
+	if username == "" || len(username) > maxUsernameLength {
+		return nil, fmt.Errorf("usernames must be between 1 and %d characters", maxUsernameLength)
+	}
+	authLock.Lock()
+	defer authLock.Unlock()
+
+	user, ok := users[username]
+	if !ok {
+		if len(password) < minPasswordLength {
+			return nil, fmt.Errorf("passwords must be at least %d characters", minPasswordLength)
+		}
+		salt, err := randomHex(16)
+		if err != nil {
+			return nil, err
+		}
+		user = &User{Name: username, Salt: salt, PasswordHash: hashPassword(password, salt), Role: protocol.RolePlayer}
+		users[username] = user
+		err = saveUsers()
+		if err != nil {
+			fmt.Println("Error saving users:", err)
+		}
+		fmt.Println("Registered user:", username)
+	} else if subtle.ConstantTimeCompare([]byte(hashPassword(password, user.Salt)), []byte(user.PasswordHash)) != 1 {
+		return nil, fmt.Errorf("wrong username or password")
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{Token: token, Username: username, Role: user.Role, Expires: time.Now().Add(sessionTTL)}
+	sessions[token] = session
+	return session, nil
+}
+
+// Picks an existing session back up after a reconnect
+func resumeSession(token string) (*Session, error) {
+	authLock.Lock()
+	defer authLock.Unlock()
+	session, ok := sessions[token]
+	if !ok {
+		return nil, fmt.Errorf("unknown session token")
+	}
+	if time.Now().After(session.Expires) {
+		delete(sessions, token)
+		return nil, fmt.Errorf("session expired")
+	}
+	session.Expires = time.Now().Add(sessionTTL)
+	return session, nil
+}
+
+// Forgets sessions that have expired. Sessions still in use by a connection
+// stay valid for that connection, but can't be resumed once they're gone.
+func sweepSessions(now time.Time) {
+	authLock.Lock()
+	defer authLock.Unlock()
+	for token, session := range sessions {
+		if now.After(session.Expires) {
+			delete(sessions, token)
+		}
+	}
+}
+
+// Sweeps expired sessions every sessionSweepPeriod, for as long as the server runs
+func runSessionSweeper() {
+	for now := range time.Tick(sessionSweepPeriod) {
+		sweepSessions(now)
+	}
+}
+
+func (s *Session) setViewport(viewport protocol.Viewport) {
+	authLock.Lock()
+	defer authLock.Unlock()
+	s.Viewport = viewport
+}
+
+func (s *Session) getViewport() protocol.Viewport {
+	authLock.Lock()
+	defer authLock.Unlock()
+	return s.Viewport
+}
+
+func (s *Session) setCursor(x, y float64) {
+	authLock.Lock()
+	defer authLock.Unlock()
+	s.CursorX, s.CursorY = x, y
+}
+
+// The session's username, role, viewport and cursor as other players see them
+func (s *Session) presence() protocol.UserPresence {
+	authLock.Lock()
+	defer authLock.Unlock()
+	return protocol.UserPresence{Username: s.Username, Role: s.Role, Viewport: s.Viewport, CursorX: s.CursorX, CursorY: s.CursorY}
+}
+
+func (s *Session) setOverlays(fields []string) {
+	authLock.Lock()
+	defer authLock.Unlock()
+	s.Overlays = fields
+}
+
+func (s *Session) getOverlays() []string {
+	authLock.Lock()
+	defer authLock.Unlock()
+	return s.Overlays
+}
+
+func (s *Session) getRole() string {
+	authLock.Lock()
+	defer authLock.Unlock()
+	return s.Role
+}
+
+// Changes a user's role, including on any sessions they already have
+func setUserRole(username, role string) error {
+	authLock.Lock()
+	defer authLock.Unlock()
+	user, ok := users[username]
+	if !ok {
+		return fmt.Errorf("unknown user %s", username)
+	}
+	user.Role = role
+	for _, session := range sessions {
+		if session.Username == username {
+			session.Role = role
+		}
+	}
+	return saveUsers()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"growth-protocol"
+)
+
+// Runs the test in a temporary directory so users.json isn't touched, with no
+// users or sessions. The users and sessions are put back when the test ends.
+func setUpAuth(t *testing.T) {
+	t.Helper()
+	chdirTemp(t)
+	savedUsers, savedSessions := users, sessions
+	t.Cleanup(func() { users, sessions = savedUsers, savedSessions })
+	users = make(map[string]*User)
+	sessions = make(map[string]*Session)
+}
+
+func TestRegisteringDoesNotMakeAdmins(t *testing.T) {
+	setUpAuth(t)
+	session, err := authenticate("ada", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Role != protocol.RolePlayer {
+		t.Errorf("the first user registered as %s", session.Role)
+	}
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	setUpAuth(t)
+	if _, err := authenticate("ada", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bootstrapAdmin("ada"); err != nil {
+		t.Fatal(err)
+	}
+	session, err := authenticate("ada", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Role != protocol.RoleAdmin {
+		t.Errorf("bootstrapped admin logged in as %s", session.Role)
+	}
+
+	// A new admin gets an account nobody can guess the password to
+	if err := bootstrapAdmin("grace"); err != nil {
+		t.Fatal(err)
+	}
+	if users["grace"].Role != protocol.RoleAdmin {
+		t.Errorf("new admin has role %s", users["grace"].Role)
+	}
+	if _, err := authenticate("grace", "hunter2"); err == nil {
+		t.Error("logged in to the new admin account with a made up password")
+	}
+	if _, err := os.Stat(userStorePath); err != nil {
+		t.Errorf("users weren't saved: %v", err)
+	}
+}
+
+func TestSweepSessions(t *testing.T) {
+	setUpAuth(t)
+	session, err := authenticate("ada", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sweepSessions(time.Now())
+	if _, err := resumeSession(session.Token); err != nil {
+		t.Fatalf("a live session was swept: %v", err)
+	}
+	sweepSessions(time.Now().Add(sessionTTL + time.Minute))
+	if len(sessions) != 0 {
+		t.Errorf("%d expired sessions are left", len(sessions))
+	}
+}
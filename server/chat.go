@@ -0,0 +1,154 @@
+// chat.go
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"growth-protocol"
+)
+
+// Chat isn't part of the world, so it has its own lock and isn't recorded in
+// replays. Global lines and announcements are kept so clients that connect
+// later can catch up, while local lines and whispers are only sent once.
+const (
+	chatHistoryLength = 50
+	localChatRadius   = 4 // how many territory chunks local chat reaches past the sender's territory
+)
+
+// Words that are masked with asterisks, along with anything they start
+var profanity = regexp.MustCompile(`(?i)\b(fuck|shit|cunt|bitch|bastard|asshole|dickhead|wanker|twat)\w*`)
+
+var (
+	chatHistory []protocol.ChatLine
+	chatLock    sync.Mutex // guards chatHistory
+)
+
+func filterChat(text string) string {
+	return profanity.ReplaceAllStringFunc(text, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	})
+}
+
+func chatLinesMessage(lines []protocol.ChatLine, history bool) *protocol.ChatLinesMessage {
+	return &protocol.ChatLinesMessage{Envelope: protocol.Envelope{Type: protocol.TypeChatLines}, Lines: lines, History: history}
+}
+
+// The recent global chat and announcements, sent to each client as it connects
+func chatHistoryMessage() *protocol.ChatLinesMessage {
+	chatLock.Lock()
+	defer chatLock.Unlock()
+	return chatLinesMessage(append([]protocol.ChatLine{}, chatHistory...), true)
+}
+
+// Sends a line to everyone connected and remembers it
+func broadcastChat(line protocol.ChatLine) {
+	chatLock.Lock()
+	chatHistory = append(chatHistory, line)
+	if len(chatHistory) > chatHistoryLength {
+		chatHistory = chatHistory[len(chatHistory)-chatHistoryLength:]
+	}
+	chatLock.Unlock()
+	broadcast(chatLinesMessage([]protocol.ChatLine{line}, false))
+}
+
+// Sends a system announcement to everyone. Safe to call without any locks
+// held; code holding worldLock should run it in a goroutine so slow clients
+// don't hold up the simulation.
+func announce(format string, args ...any) {
+	text := fmt.Sprintf(format, args...)
+	fmt.Println("Announcement:", text)
+	broadcastChat(protocol.ChatLine{Channel: protocol.ChannelSystem, Text: text})
+}
+
+// The players owning territory within localChatRadius chunks of the
+// sender's. Must be called with worldLock held.
+func localChatRecipients(sender string) (map[string]bool, error) {
+	recipients := map[string]bool{sender: true}
+	owned := false
+	for cx := 0; cx < chunksWide; cx++ {
+		for cy := 0; cy < chunksHigh; cy++ {
+			if chunkOwners[cx][cy] != sender {
+				continue
+			}
+			owned = true
+			for nx := max(cx-localChatRadius, 0); nx <= min(cx+localChatRadius, chunksWide-1); nx++ {
+				for ny := max(cy-localChatRadius, 0); ny <= min(cy+localChatRadius, chunksHigh-1); ny++ {
+					if owner := chunkOwners[nx][ny]; owner != "" {
+						recipients[owner] = true
+					}
+				}
+			}
+		}
+	}
+	if !owned {
+		return nil, protocol.Errorf(protocol.CodeNoHive, "local chat needs territory, place a hive first")
+	}
+	return recipients, nil
+}
+
+// Sends a line to the connections of the named players
+func sendChatTo(usernames map[string]bool, line protocol.ChatLine) {
+	msg := chatLinesMessage([]protocol.ChatLine{line}, false)
+	worldLock.Lock()
+	var targets []*Client
+	for _, c := range connectedClients() {
+		if c.Session != nil && usernames[c.Session.Username] {
+			targets = append(targets, c)
+		}
+	}
+	worldLock.Unlock()
+	for _, c := range targets {
+		err := c.send(msg)
+		if err != nil {
+			fmt.Println("Write error:", err)
+		}
+	}
+}
+
+// Whether the player has a connection open. Must be called with worldLock held.
+func isOnline(username string) bool {
+	for _, c := range connectedClients() {
+		if c.Session != nil && c.Session.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+func handleChat(client *Client, msg *protocol.ChatMessage) (protocol.Message, error) {
+	line := protocol.ChatLine{Channel: msg.Channel, From: client.Session.Username, To: msg.To, Text: filterChat(msg.Text)}
+	switch msg.Channel {
+	case protocol.ChannelGlobal:
+		broadcastChat(line)
+	case protocol.ChannelLocal:
+		worldLock.Lock()
+		recipients, err := localChatRecipients(line.From)
+		worldLock.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		sendChatTo(recipients, line)
+	case protocol.ChannelWhisper:
+		worldLock.Lock()
+		online := isOnline(msg.To)
+		worldLock.Unlock()
+		if !online {
+			return nil, protocol.Errorf(protocol.CodeRejected, "%s isn't online", msg.To)
+		}
+		sendChatTo(map[string]bool{line.From: true, msg.To: true}, line)
+	}
+	return nil, nil
+}
+
+// Announces the start of each day, and of each season
+func announceDay(day WorldTime, newSeason bool) {
+	if newSeason {
+		announce("Day %d: %s has begun", day.Day, protocol.Seasons[day.Season])
+	} else {
+		announce("Day %d has begun", day.Day)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"growth-protocol"
+)
+
+// Starts the test with no chat history, putting it back when the test ends
+func setUpChat(t *testing.T) {
+	t.Helper()
+	saved := chatHistory
+	t.Cleanup(func() { chatHistory = saved })
+	chatHistory = nil
+}
+
+func TestAnnounceIsKeptInHistory(t *testing.T) {
+	setUpChat(t)
+	client := &Client{Session: &Session{Username: "ada", Role: protocol.RoleAdmin}}
+	if _, err := handleAnnounce(client, protocol.NewAnnounce("Restarting soon")); err != nil {
+		t.Fatal(err)
+	}
+	lines := chatHistoryMessage().Lines
+	expected := protocol.ChatLine{Channel: protocol.ChannelSystem, Text: "ada: Restarting soon"}
+	if len(lines) != 1 || lines[0] != expected {
+		t.Errorf("history is %+v", lines)
+	}
+}
+
+func TestChatHistoryIsCapped(t *testing.T) {
+	setUpChat(t)
+	for i := 0; i < chatHistoryLength+5; i++ {
+		broadcastChat(protocol.ChatLine{Channel: protocol.ChannelGlobal, From: "ada", Text: "hi"})
+	}
+	if len(chatHistoryMessage().Lines) != chatHistoryLength {
+		t.Errorf("history holds %d lines", len(chatHistoryMessage().Lines))
+	}
+}
+
+func TestFilterChat(t *testing.T) {
+	if filtered := filterChat("oh SHITTY weather"); filtered != "oh ****** weather" {
+		t.Errorf("filterChat = %q", filtered)
+	}
+}
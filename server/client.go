@@ -0,0 +1,138 @@
+// client.go
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"growth-protocol"
+)
+
+// Limits on the connection itself. Clients must answer pings within
+// pongWait or they are dropped, and messages over maxMessageSize close the connection.
+const (
+	maxMessageSize = protocol.MaxMessageSize
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = pongWait * 9 / 10
+)
+
+// A Client is a single websocket connection. Gorilla connections only allow
+// one writer at a time, so everything sent to the client goes through send.
+type Client struct {
+	conn      *websocket.Conn
+	address   string // the remote host, without its port
+	writeLock sync.Mutex
+	Player    *Player  // nil until the client logs in, guarded by worldLock
+	Session   *Session // set by the read loop while holding worldLock
+	resync    []int    // chunks to send again in the next frame, guarded by worldLock
+	limiter   *rateLimiter
+	strikes   *tokenBucket // only used by the read loop
+}
+
+func NewClient(conn *websocket.Conn) *Client {
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	return &Client{
+		conn:    conn,
+		address: remoteHost(conn.RemoteAddr()),
+		limiter: newRateLimiter(),
+		strikes: newTokenBucket(maxStrikes, strikesPerSecond),
+	}
+}
+
+// Every connected client, so messages can be sent to all of them
+var clients = make(map[*Client]struct{})
+var clientsLock sync.Mutex
+
+func registerClient(c *Client) {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+	clients[c] = struct{}{}
+}
+
+func unregisterClient(c *Client) {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+	delete(clients, c)
+}
+
+func connectedClients() []*Client {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+	list := make([]*Client, 0, len(clients))
+	for c := range clients {
+		list = append(list, c)
+	}
+	return list
+}
+
+// Sends the message to every connected client
+func broadcast(msg protocol.Message) {
+	for _, c := range connectedClients() {
+		err := c.send(msg)
+		if err != nil {
+			fmt.Println("Write error:", err)
+		}
+	}
+}
+
+// Checks the message against both the connection's and the player's limits
+func (c *Client) checkRateLimit(msgType string) error {
+	if !c.limiter.allow(msgType) || (c.Player != nil && !c.Player.limiter.allow(msgType)) {
+		return protocol.Errorf(protocol.CodeRateLimited, "too many %s messages, slow down", msgType)
+	}
+	return nil
+}
+
+func (c *Client) send(msg protocol.Message) error {
+	msgJSON, err := protocol.Encode(msg)
+	if err != nil {
+		return err
+	}
+	return c.sendRaw(msgJSON)
+}
+
+// Tells the client why one of its requests was rejected
+func (c *Client) sendError(request protocol.Envelope, err error) {
+	sendErr := c.send(protocol.NewError(request, err))
+	if sendErr != nil {
+		fmt.Println("Write error:", sendErr)
+	}
+}
+
+func (c *Client) sendRaw(msgJSON []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.conn.WriteMessage(websocket.TextMessage, msgJSON)
+}
+
+// Pings are control frames, which gorilla allows alongside other writes
+func (c *Client) ping() error {
+	return c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+}
+
+// Tells the client why it's being disconnected and closes the connection
+func (c *Client) disconnect(reason string) {
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+	if err != nil {
+		fmt.Println("Write error:", err)
+	}
+	c.conn.Close()
+}
+
+// Returns the logged in player's hive. Must be called with worldLock held.
+func (c *Client) hive() (*Hive, bool) {
+	if c.Player == nil {
+		return nil, false
+	}
+	hive, ok := hivesByOwner[c.Player.Name]
+	return hive, ok
+}
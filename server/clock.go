@@ -0,0 +1,133 @@
+// clock.go
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"growth-protocol"
+)
+
+// The simulation runs in fixed steps, one per tick, independently of how
+// often tiles are broadcast. The clock checks every clockResolution how
+// much scaled time has passed and runs however many ticks are due. A server
+// that can't keep up drops ticks rather than falling further behind, so the
+// clock measures how many it actually runs and warns when it drops any.
+// At SpeedMax the clock runs ticks back to back, but only for maxSpeedBatch
+// at a time, letting go of worldLock in between so broadcasts and requests
+// still get their turn.
+const (
+	defaultTicksPerSecond = 4.0
+	clockResolution       = 5 * time.Millisecond
+	maxTicksBehind        = 8                    // ticks beyond this are dropped if the server falls behind
+	maxSpeedBatch         = 5 * time.Millisecond // how long SpeedMax may hold worldLock at once
+	measureWindow         = time.Second          // how often the tick rate is measured
+)
+
+// SimClock tracks simulated time. Guarded by worldLock.
+type SimClock struct {
+	Tick           int64
+	TicksPerSecond float64
+	Speed          float64
+	Paused         bool
+	Measured       float64       // ticks run per second over the last measureWindow
+	accumulated    time.Duration // scaled time not yet spent on ticks
+	windowStart    time.Time
+	windowTicks    int           // ticks run since windowStart
+	windowDropped  time.Duration // scaled time dropped since windowStart
+}
+
+var simClock = &SimClock{TicksPerSecond: defaultTicksPerSecond, Speed: 1}
+
+func (c *SimClock) tickDuration() time.Duration {
+	return time.Duration(float64(time.Second) / c.TicksPerSecond)
+}
+
+// Starts the world over at tick 0. Must be called with worldLock held.
+func (c *SimClock) reset() {
+	c.Tick = 0
+	c.accumulated = 0
+}
+
+func (c *SimClock) state() protocol.ClockState {
+	return protocol.ClockState{Tick: c.Tick, TicksPerSecond: c.TicksPerSecond, Speed: c.Speed, Paused: c.Paused, Measured: c.Measured}
+}
+
+// Runs step once per tick until stop is closed. Started once from main, which
+// never stops it.
+func (c *SimClock) run(step func(), stop <-chan struct{}) {
+	last := time.Now()
+	worldLock.Lock()
+	c.windowStart = last
+	worldLock.Unlock()
+	flatOut := false
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if flatOut {
+			// Give whoever is waiting on worldLock a chance at it before the next batch
+			runtime.Gosched()
+		} else {
+			time.Sleep(clockResolution)
+		}
+		now := time.Now()
+		elapsed := now.Sub(last)
+		last = now
+		worldLock.Lock()
+		flatOut = c.advance(elapsed, step)
+		c.measure(now)
+		worldLock.Unlock()
+	}
+}
+
+// Works out the tick rate once every measureWindow. Must be called with worldLock held.
+func (c *SimClock) measure(now time.Time) {
+	elapsed := now.Sub(c.windowStart)
+	if elapsed < measureWindow {
+		return
+	}
+	c.Measured = float64(c.windowTicks) / elapsed.Seconds()
+	if dropped := c.windowDropped.Seconds() * c.TicksPerSecond; dropped >= 1 {
+		fmt.Printf("Simulation can't keep up: ran %.1f ticks per second out of %g, dropping %.0f\n", c.Measured, c.TicksPerSecond*c.Speed, dropped)
+	}
+	c.windowStart = now
+	c.windowTicks = 0
+	c.windowDropped = 0
+}
+
+// Returns whether the clock is running flat out at SpeedMax, in which case
+// there's another batch due straight away. Must be called with worldLock held.
+func (c *SimClock) advance(elapsed time.Duration, step func()) bool {
+	if c.Paused || c.Speed == 0 {
+		c.accumulated = 0
+		return false
+	}
+	if c.Speed == protocol.SpeedMax {
+		start := time.Now()
+		for time.Since(start) < maxSpeedBatch {
+			step()
+			c.Tick++
+			c.windowTicks++
+		}
+		c.accumulated = 0
+		return true
+	}
+
+	tick := c.tickDuration()
+	c.accumulated += time.Duration(float64(elapsed) * c.Speed)
+	if c.accumulated > tick*maxTicksBehind {
+		c.windowDropped += c.accumulated - tick*maxTicksBehind
+		c.accumulated = tick * maxTicksBehind
+	}
+	for c.accumulated >= tick {
+		step()
+		c.Tick++
+		c.windowTicks++
+		c.accumulated -= tick
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"growth-protocol"
+)
+
+func TestClockRunsDueTicks(t *testing.T) {
+	c := &SimClock{TicksPerSecond: 4, Speed: 2}
+	steps := 0
+	c.advance(time.Second, func() { steps++ })
+	if steps != 8 || c.Tick != 8 {
+		t.Errorf("ran %d steps to tick %d, expected 8", steps, c.Tick)
+	}
+	c.Paused = true
+	c.advance(time.Second, func() { steps++ })
+	if steps != 8 {
+		t.Error("a paused clock ticked")
+	}
+}
+
+// A clock that falls behind drops the ticks past maxTicksBehind and measures
+// the rate it actually ran at
+func TestClockMeasuresDroppedTicks(t *testing.T) {
+	start := time.Now()
+	c := &SimClock{TicksPerSecond: 4, Speed: 4, windowStart: start}
+	steps := 0
+	c.advance(2*time.Second, func() { steps++ })
+	if steps != maxTicksBehind {
+		t.Fatalf("ran %d steps, expected %d", steps, maxTicksBehind)
+	}
+	if dropped := c.windowDropped.Seconds() * c.TicksPerSecond; dropped != 32-maxTicksBehind {
+		t.Errorf("dropped %v ticks, expected %d", dropped, 32-maxTicksBehind)
+	}
+
+	c.measure(start.Add(measureWindow / 2))
+	if c.Measured != 0 {
+		t.Error("measured before the window was up")
+	}
+	c.measure(start.Add(2 * time.Second))
+	if c.Measured != maxTicksBehind/2 {
+		t.Errorf("measured %v ticks per second, expected %d", c.Measured, maxTicksBehind/2)
+	}
+	if c.windowTicks != 0 || c.windowDropped != 0 || c.state().Measured != c.Measured {
+		t.Error("the window wasn't restarted")
+	}
+}
+
+// At SpeedMax the clock lets go of worldLock between batches, so frames can
+// still be broadcast while it runs flat out
+func TestMaxSpeedLetsBroadcastsIn(t *testing.T) {
+	c := &SimClock{TicksPerSecond: 4, Speed: protocol.SpeedMax}
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.run(func() { time.Sleep(50 * time.Microsecond) }, stop)
+	for started := false; !started; {
+		time.Sleep(clockResolution)
+		worldLock.Lock()
+		started = c.Tick > 0
+		worldLock.Unlock()
+	}
+
+	var longest time.Duration
+	lastTick := int64(-1)
+	for i := 0; i < 20; i++ {
+		time.Sleep(time.Millisecond)
+		start := time.Now()
+		worldLock.Lock()
+		if waited := time.Since(start); waited > longest {
+			longest = waited
+		}
+		tick := c.Tick
+		worldLock.Unlock()
+		if tick <= lastTick {
+			t.Fatalf("the clock stopped at tick %d", tick)
+		}
+		lastTick = tick
+	}
+	if longest > 50*time.Millisecond {
+		t.Errorf("a broadcast waited %v for worldLock", longest)
+	}
+}
@@ -0,0 +1,197 @@
+// economy.go
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"growth-protocol"
+)
+
+const (
+	resourceNutrients = protocol.ResourceNutrients
+	resourceOil       = protocol.ResourceOil
+	resourceStone     = protocol.ResourceStone
+	resourceMinerals  = protocol.ResourceMinerals
+)
+
+var resourceTypes = protocol.ResourceTypes
+
+// A Player is the account behind a login, and owns everything their hive and drones gather
+type Player struct {
+	Name             string
+	Inventory        map[string]int
+	InventoryVersion int // bumped on every change so clients know when to push an update
+	limiter          *rateLimiter
+}
+
+var startingInventory = map[string]int{
+	resourceNutrients: 60,
+	resourceStone:     40,
+}
+
+// What it costs to place things that aren't built by drones
+var droneCost = map[string]int{
+	resourceNutrients: 20,
+	resourceMinerals:  2,
+}
+
+// Gathered by a drone each harvest, indexed by tile type like the tile registry.
+// Mountains have to be harvested from an adjacent tile as drones can't stand on high mountains.
+var harvestRates = map[int]map[string]int{
+	grass:         {resourceNutrients: 1},
+	forest:        {resourceNutrients: 3},
+	shrub:         {resourceNutrients: 2},
+	burnt:         {resourceNutrients: 2},
+	mountains:     {resourceStone: 2},
+	highMountains: {resourceMinerals: 1},
+	oilspout:      {resourceOil: oilHarvestAmount},
+}
+
+// What each harvest takes from the tile. Plants are cut back, burnt ground
+// loses its nutrients and rock is quarried away, so the tile's type follows
+// as it runs out: grass and forest thin to dirt and mountains wear down.
+const (
+	harvestVegetation = 0.15
+	harvestNutrient   = 0.1
+	harvestAltitude   = 0.002
+)
+
+var players = make(map[string]*Player)
+
+// Returns the player's account, creating it on first login
+func getPlayer(name string) *Player {
+	player, ok := players[name]
+	if !ok {
+		player = &Player{Name: name, Inventory: make(map[string]int), limiter: newRateLimiter()}
+		for resource, amount := range startingInventory {
+			player.Inventory[resource] = amount
+		}
+		players[name] = player
+	}
+	return player
+}
+
+func (p *Player) canAfford(cost map[string]int) bool {
+	for resource, amount := range cost {
+		if p.Inventory[resource] < amount {
+			return false
+		}
+	}
+	return true
+}
+
+// Takes the cost out of the inventory, or returns an error without spending anything
+func (p *Player) spend(cost map[string]int) error {
+	if !p.canAfford(cost) {
+		return fmt.Errorf("%s can't afford %v", p.Name, cost)
+	}
+	for resource, amount := range cost {
+		p.Inventory[resource] -= amount
+	}
+	if len(cost) > 0 {
+		p.InventoryVersion++
+	}
+	return nil
+}
+
+func (p *Player) add(resources map[string]int) {
+	for resource, amount := range resources {
+		p.Inventory[resource] += amount
+	}
+	if len(resources) > 0 {
+		p.InventoryVersion++
+	}
+}
+
+// Resources are always sent in full so the client doesn't have to track missing keys
+func (p *Player) inventoryMessage() *protocol.InventoryMessage {
+	resources := make(map[string]int, len(resourceTypes))
+	for _, resource := range resourceTypes {
+		resources[resource] = p.Inventory[resource]
+	}
+	return &protocol.InventoryMessage{Envelope: protocol.Envelope{Type: protocol.TypeInventory}, Resources: resources}
+}
+
+// Oilspouts can only be harvested once they've filled up with some oil
+func isHarvestable(x, y int) bool {
+	if tiles[x][y].Type == oilspout {
+		return hasOil(x, y)
+	}
+	_, ok := harvestRates[tiles[x][y].Type]
+	return ok
+}
+
+// Takes a harvest from the tile and returns what the drone carries back.
+// Must be called with worldLock held.
+func harvestTile(x, y int) map[string]int {
+	tile := &tiles[x][y]
+	if tile.Type == oilspout {
+		return map[string]int{resourceOil: extractOil(x, y, oilHarvestAmount)}
+	}
+	// The rates are shared, so the cargo gets its own copy
+	cargo := make(map[string]int)
+	for resource, amount := range harvestRates[tile.Type] {
+		cargo[resource] = amount
+	}
+	switch tile.Type {
+	case grass, forest, shrub:
+		tile.Vegetation = math.Max(tile.Vegetation-harvestVegetation, 0)
+	case burnt:
+		tile.Nutrient = math.Max(tile.Nutrient-harvestNutrient, 0)
+	case mountains, highMountains:
+		tile.Altitude = math.Max(tile.Altitude-harvestAltitude, 0)
+	}
+	// Quarried mountains and bare grass change type, and drones route around them, straight away
+	refreshTileType(x, y)
+	return cargo
+}
+
+// Finds the closest harvestable tile to the hive, searching outwards in rings.
+// Drones prefer whatever resource the player has least of.
+func (h *Hive) findHarvestSite() (int, int, bool) {
+	wanted := resourceTypes[0]
+	for _, resource := range resourceTypes {
+		if h.Player.Inventory[resource] < h.Player.Inventory[wanted] {
+			wanted = resource
+		}
+	}
+
+	fallbackX, fallbackY, found := 0, 0, false
+	for r := 1; r <= harvestRadius; r++ {
+		for i := h.X - r; i <= h.X+r; i++ {
+			for j := h.Y - r; j <= h.Y+r; j++ {
+				if i != h.X-r && i != h.X+r && j != h.Y-r && j != h.Y+r {
+					continue
+				}
+				if i < 0 || i >= tilesWide || j < 0 || j >= tilesHigh || !isHarvestable(i, j) || h.isHarvestClaimed(i, j) {
+					continue
+				}
+				if _, ok := harvestRates[tiles[i][j].Type][wanted]; ok {
+					return i, j, true
+				}
+				if !found {
+					fallbackX, fallbackY, found = i, j, true
+				}
+			}
+		}
+	}
+	return fallbackX, fallbackY, found
+}
+
+func (h *Hive) isHarvestClaimed(x, y int) bool {
+	for _, drone := range h.Drones {
+		if drone.State == droneHarvesting && drone.TargetX == x && drone.TargetY == y {
+			return true
+		}
+	}
+	return false
+}
+
+// Spawns a new drone at the hive in exchange for resources
+func (h *Hive) buyDrone() (*Drone, error) {
+	if err := h.Player.spend(droneCost); err != nil {
+		return nil, err
+	}
+	return h.spawnDrone(), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"growth-server/pathfinding"
+)
+
+func TestHarvestDepletesTile(t *testing.T) {
+	setUpWorld(t)
+	tiles[0][0] = Tile{Type: forest, Vegetation: 0.8, Altitude: 0.6}
+	tiles[1][0] = Tile{Type: mountains, Altitude: 0.85}
+	tiles[2][0] = Tile{Type: burnt, Nutrient: 0.05, Altitude: 0.45, Scorch: 1}
+
+	if cargo := harvestTile(0, 0); cargo[resourceNutrients] != harvestRates[forest][resourceNutrients] {
+		t.Errorf("forest gave %v", cargo)
+	}
+	if tiles[0][0].Vegetation != 0.8-harvestVegetation {
+		t.Errorf("forest has %v vegetation left", tiles[0][0].Vegetation)
+	}
+	harvestTile(1, 0)
+	if tiles[1][0].Altitude != 0.85-harvestAltitude {
+		t.Errorf("mountain is at altitude %v", tiles[1][0].Altitude)
+	}
+	harvestTile(2, 0)
+	if tiles[2][0].Nutrient != 0 {
+		t.Errorf("burnt ground has %v nutrients left", tiles[2][0].Nutrient)
+	}
+}
+
+// A mountain quarried down to dirt is dirt from then on, to the tile hashes
+// and to the drones' routes
+func TestQuarriedMountainChangesType(t *testing.T) {
+	setUpWorld(t)
+	worldTime = worldTimeAt(ticksPerSeason + ticksPerDay/4)
+	for x := 39; x <= 45; x++ {
+		setTileType(x, 49, deepWater)
+		setTileType(x, 51, deepWater)
+	}
+	tiles[42][50].Altitude = dirtAltitude + harvestAltitude/2
+	setTileType(42, 50, mountains)
+	route := pathCache.Path(pathfinding.Point{X: 40, Y: 50}, pathfinding.Point{X: 44, Y: 50})
+	if !route.Found {
+		t.Fatal("no route along the causeway")
+	}
+
+	harvestTile(42, 50)
+	if tiles[42][50].Type != dirt {
+		t.Errorf("the quarried mountain is tile type %d", tiles[42][50].Type)
+	}
+	if err := verifyTileHashes(); err != nil {
+		t.Error(err)
+	}
+	if route.Valid() {
+		t.Error("the route over the mountain wasn't invalidated")
+	}
+}
+
+// Cargo is the drone's own, so adding to it can't change the harvest rates
+func TestHarvestCargoIsCopied(t *testing.T) {
+	setUpWorld(t)
+	tiles[0][0] = Tile{Type: grass, Vegetation: 1}
+	rate := harvestRates[grass][resourceNutrients]
+	cargo := harvestTile(0, 0)
+	cargo[resourceNutrients] += 100
+	if harvestRates[grass][resourceNutrients] != rate {
+		t.Errorf("the grass harvest rate changed to %d", harvestRates[grass][resourceNutrients])
+	}
+}
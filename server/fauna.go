@@ -0,0 +1,353 @@
+// fauna.go
+package main
+
+import (
+	"math"
+
+	"growth-protocol"
+	"growth-server/pathfinding"
+)
+
+// Grazers wander towards the greenest ground and eat the vegetation, and
+// predators hunt the grazers. Both breed when they've eaten well and starve
+// when they haven't, so the two populations rise and fall in turn. Animals
+// can't swim, and burn or drown if fire or water reaches them.
+const (
+	grazerKind   = protocol.AnimalGrazer
+	predatorKind = protocol.AnimalPredator
+
+	startingGrazers   = 3000
+	startingPredators = 150
+	maxAnimals        = 20000
+	minPopulation     = 10  // below this, new animals wander in from off the map
+	immigrationChance = 0.1 // per tick, while a population is below the minimum
+
+	grazerMetabolism   = 0.008 // energy used per tick
+	grazerBite         = 0.03  // vegetation eaten per tick
+	grazeEnergy        = 0.5   // energy for each unit of vegetation eaten
+	grazeNutrientLoss  = 0.5   // nutrient taken from the ground with each unit of vegetation
+	minGrazeVegetation = 0.2   // grazers move on when there's less than this left
+	grazerLifespan     = 8 * ticksPerDay
+
+	predatorMetabolism = 0.003
+	preyEnergy         = 0.3
+	catchChance        = 0.25 // of catching an adjacent grazer each tick
+	huntRadius         = 6
+	predatorLifespan   = 12 * ticksPerDay
+
+	birthEnergy     = 1.0 // animals with this much energy give half of it to a newborn
+	startingEnergy  = 0.5
+	migrateRadius   = 16 // how far animals look for somewhere better to go
+	migrateSamples  = 8
+	faunaBucketSize = 8 // grazers are bucketed so predators only search nearby
+
+	statsInterval = 20 // ticks between population counts
+)
+
+type Animal struct {
+	Kind    string
+	X       int
+	Y       int
+	Energy  float64
+	Age     int
+	targetX int // where the animal is migrating to
+	targetY int
+	migrate bool
+	dead    bool
+}
+
+type population struct {
+	births int
+	deaths int
+}
+
+// Guarded by worldLock
+var (
+	animals      []*Animal
+	populations  = make(map[string]*population)
+	faunaStats   *protocol.StatsMessage // the latest population count
+	statsVersion int                    // bumped on every count so clients know when to push an update
+)
+
+// Fills the map with animals on random grassland and forest. Must be called with worldLock held.
+func resetFauna() {
+	animals = nil
+	for i := 0; i < startingGrazers; i++ {
+		spawnAnimal(grazerKind)
+	}
+	for i := 0; i < startingPredators; i++ {
+		spawnAnimal(predatorKind)
+	}
+	resetPopulations()
+}
+
+// Clears the birth and death counts and counts the animals again
+func resetPopulations() {
+	populations = make(map[string]*population)
+	for _, kind := range protocol.AnimalKinds {
+		populations[kind] = &population{}
+	}
+	countPopulations()
+}
+
+// Places an animal on a random tile with something to graze, giving up after a few tries
+func spawnAnimal(kind string) {
+	for try := 0; try < 100; try++ {
+		x, y := rng.Intn(tilesWide), rng.Intn(tilesHigh)
+		if canWalk(x, y) && forage(x, y) > 0 {
+			animals = append(animals, &Animal{Kind: kind, X: x, Y: y, Energy: startingEnergy})
+			return
+		}
+	}
+}
+
+// Animals keep out of water, fire and anything drones can't cross either
+func canWalk(x, y int) bool {
+	if x < 0 || x >= tilesWide || y < 0 || y >= tilesHigh {
+		return false
+	}
+	tile := &tiles[x][y]
+	return tile.Water < shallowWaterDepth && tile.Fire == 0 && tileMoveCost(tile.Type) != pathfinding.Impassable
+}
+
+// The vegetation a grazer could eat on a tile
+func forage(x, y int) float64 {
+	switch tiles[x][y].Type {
+	case grass, shrub, forest:
+		return tiles[x][y].Vegetation
+	}
+	return 0
+}
+
+func simulateFauna() {
+	grazers := bucketGrazers()
+	// Newborns are appended as the animals breed, and first act next tick
+	count := len(animals)
+	for i := 0; i < count; i++ {
+		a := animals[i]
+		if a.dead {
+			continue
+		}
+		a.Age++
+		lifespan := grazerLifespan
+		if a.Kind == grazerKind {
+			a.graze()
+		} else {
+			a.hunt(grazers)
+			lifespan = predatorLifespan
+		}
+		if a.Energy <= 0 || a.Age > lifespan || !canWalk(a.X, a.Y) {
+			a.die()
+			continue
+		}
+		if a.Energy >= birthEnergy && len(animals) < maxAnimals {
+			a.breed()
+		}
+	}
+
+	counts := make(map[string]int)
+	alive := animals[:0]
+	for _, a := range animals {
+		if !a.dead {
+			alive = append(alive, a)
+			counts[a.Kind]++
+		}
+	}
+	clear(animals[len(alive):])
+	animals = alive
+	for _, kind := range protocol.AnimalKinds {
+		if counts[kind] < minPopulation && rng.Float64() < immigrationChance {
+			spawnAnimal(kind)
+		}
+	}
+	if simClock.Tick%statsInterval == 0 {
+		countPopulations()
+	}
+}
+
+// Eats the tile bare, then moves on to greener ground
+func (a *Animal) graze() {
+	a.Energy -= grazerMetabolism
+	if forage(a.X, a.Y) < minGrazeVegetation {
+		a.forage()
+		return
+	}
+	tile := &tiles[a.X][a.Y]
+	bite := math.Min(grazerBite, tile.Vegetation)
+	tile.Vegetation -= bite
+	tile.Nutrient = math.Max(tile.Nutrient-bite*grazeNutrientLoss, 0)
+	a.Energy += bite * grazeEnergy
+	a.migrate = false
+}
+
+// Moves to the greenest neighbour, or migrates if there's nothing good nearby
+func (a *Animal) forage() {
+	bestX, bestY, best := 0, 0, 0.0
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			x, y := a.X+dx, a.Y+dy
+			if (dx == 0 && dy == 0) || !canWalk(x, y) {
+				continue
+			}
+			if value := forage(x, y); value > best {
+				bestX, bestY, best = x, y, value
+			}
+		}
+	}
+	if best >= minGrazeVegetation {
+		a.X, a.Y = bestX, bestY
+		return
+	}
+	a.wander()
+}
+
+// Heads for the greenest of a few tiles further away, picking a new
+// destination on arrival. As the seasons change the vegetation moves, and
+// the herds follow it.
+func (a *Animal) wander() {
+	if !a.migrate || (a.X == a.targetX && a.Y == a.targetY) {
+		a.targetX, a.targetY = a.X+rng.Intn(2*migrateRadius+1)-migrateRadius, a.Y+rng.Intn(2*migrateRadius+1)-migrateRadius
+		best := -1.0
+		for i := 0; i < migrateSamples; i++ {
+			x, y := a.X+rng.Intn(2*migrateRadius+1)-migrateRadius, a.Y+rng.Intn(2*migrateRadius+1)-migrateRadius
+			if !canWalk(x, y) {
+				continue
+			}
+			if value := forage(x, y); value > best {
+				a.targetX, a.targetY, best = x, y, value
+			}
+		}
+		a.migrate = true
+	}
+	a.stepToward(a.targetX, a.targetY)
+}
+
+// Takes one step towards (x, y), sidestepping anything in the way. Gives up
+// on the destination if there's no way forward.
+func (a *Animal) stepToward(x, y int) {
+	dx, dy := sign(x-a.X), sign(y-a.Y)
+	for _, step := range [][2]int{{dx, dy}, {dx, 0}, {0, dy}} {
+		if (step[0] != 0 || step[1] != 0) && canWalk(a.X+step[0], a.Y+step[1]) {
+			a.X += step[0]
+			a.Y += step[1]
+			return
+		}
+	}
+	a.migrate = false
+}
+
+func sign(n int) int {
+	switch {
+	case n > 0:
+		return 1
+	case n < 0:
+		return -1
+	}
+	return 0
+}
+
+// Chases down the nearest grazer, or follows the herds if there's none in sight
+func (a *Animal) hunt(grazers [][]*Animal) {
+	a.Energy -= predatorMetabolism
+	prey := nearestGrazer(a.X, a.Y, grazers)
+	if prey == nil {
+		a.wander()
+		return
+	}
+	if max(abs(prey.X-a.X), abs(prey.Y-a.Y)) > 1 {
+		a.stepToward(prey.X, prey.Y)
+		return
+	}
+	if rng.Float64() < catchChance {
+		prey.die()
+		a.Energy += preyEnergy
+	}
+}
+
+// Buckets the living grazers by position so predators only search nearby
+func bucketGrazers() [][]*Animal {
+	bucketsWide := (tilesWide + faunaBucketSize - 1) / faunaBucketSize
+	bucketsHigh := (tilesHigh + faunaBucketSize - 1) / faunaBucketSize
+	buckets := make([][]*Animal, bucketsWide*bucketsHigh)
+	for _, a := range animals {
+		if a.Kind == grazerKind {
+			index := (a.X/faunaBucketSize)*bucketsHigh + a.Y/faunaBucketSize
+			buckets[index] = append(buckets[index], a)
+		}
+	}
+	return buckets
+}
+
+func nearestGrazer(x, y int, buckets [][]*Animal) *Animal {
+	bucketsHigh := (tilesHigh + faunaBucketSize - 1) / faunaBucketSize
+	var nearest *Animal
+	nearestDistance := huntRadius + 1
+	for bx := max(x-huntRadius, 0) / faunaBucketSize; bx <= min(x+huntRadius, tilesWide-1)/faunaBucketSize; bx++ {
+		for by := max(y-huntRadius, 0) / faunaBucketSize; by <= min(y+huntRadius, tilesHigh-1)/faunaBucketSize; by++ {
+			for _, grazer := range buckets[bx*bucketsHigh+by] {
+				distance := max(abs(grazer.X-x), abs(grazer.Y-y))
+				if !grazer.dead && distance < nearestDistance {
+					nearest, nearestDistance = grazer, distance
+				}
+			}
+		}
+	}
+	return nearest
+}
+
+func (a *Animal) die() {
+	a.dead = true
+	populations[a.Kind].deaths++
+}
+
+// Gives half of the animal's energy to a newborn on the same tile
+func (a *Animal) breed() {
+	a.Energy /= 2
+	animals = append(animals, &Animal{Kind: a.Kind, X: a.X, Y: a.Y, Energy: a.Energy})
+	populations[a.Kind].births++
+}
+
+// Records the size of each population, and the births and deaths since the last count
+func countPopulations() {
+	counts := make(map[string]int)
+	energy := make(map[string]float64)
+	for _, a := range animals {
+		counts[a.Kind]++
+		energy[a.Kind] += a.Energy
+	}
+	stats := &protocol.StatsMessage{
+		Envelope:    protocol.Envelope{Type: protocol.TypeStats},
+		Tick:        simClock.Tick,
+		Populations: make([]protocol.PopulationStats, 0, len(protocol.AnimalKinds)),
+	}
+	for _, kind := range protocol.AnimalKinds {
+		average := 0.0
+		if counts[kind] > 0 {
+			average = math.Min(energy[kind]/float64(counts[kind])/birthEnergy, 1)
+		}
+		stats.Populations = append(stats.Populations, protocol.PopulationStats{
+			Kind:   kind,
+			Count:  counts[kind],
+			Births: populations[kind].births,
+			Deaths: populations[kind].deaths,
+			Energy: average,
+		})
+		populations[kind].births = 0
+		populations[kind].deaths = 0
+	}
+	faunaStats = stats
+	statsVersion++
+}
+
+// The animals in a client's viewport
+func animalStates(viewport protocol.Viewport) []protocol.AnimalState {
+	states := []protocol.AnimalState{}
+	for _, a := range animals {
+		x, y := float64(a.X), float64(a.Y)
+		if x+1 < viewport.X || x > viewport.X+viewport.Width || y+1 < viewport.Y || y > viewport.Y+viewport.Height {
+			continue
+		}
+		states = append(states, protocol.AnimalState{Kind: a.Kind, X: a.X, Y: a.Y})
+	}
+	return states
+}
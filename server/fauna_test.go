@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+
+	"growth-protocol"
+)
+
+// A client can't be sent every animal on the map by asking for a huge viewport
+func TestLargeViewportIsClamped(t *testing.T) {
+	setUpWorld(t)
+	animals = []*Animal{{Kind: grazerKind, X: 10, Y: 10}, {Kind: grazerKind, X: 10 + 2*protocol.MaxViewportSize, Y: 10}}
+	client := &Client{Session: &Session{Username: "ada", Role: protocol.RoleSpectator}}
+	if _, err := handleViewport(client, protocol.NewViewport(protocol.Viewport{Width: 1e9, Height: 1e9})); err != nil {
+		t.Fatal(err)
+	}
+	if states := animalStates(client.Session.getViewport()); len(states) != 1 {
+		t.Errorf("sent %d animals", len(states))
+	}
+}
+
+// Grazers eat the vegetation and the nutrients in the ground under them, and
+// grow stronger on it
+func TestGrazersEat(t *testing.T) {
+	setUpWorld(t)
+	tiles[20][20].Nutrient = 0.5
+	grazer := &Animal{Kind: grazerKind, X: 20, Y: 20, Energy: startingEnergy}
+	animals = []*Animal{grazer}
+	for tick := 0; tick < 5; tick++ {
+		simulateFauna()
+	}
+	if grazer.X != 20 || grazer.Y != 20 {
+		t.Fatalf("the grazer left a full meal for (%d, %d)", grazer.X, grazer.Y)
+	}
+	if tiles[20][20].Vegetation >= 1 || tiles[20][20].Nutrient >= 0.5 {
+		t.Errorf("grazing left %v vegetation and %v nutrient", tiles[20][20].Vegetation, tiles[20][20].Nutrient)
+	}
+	if grazer.Energy <= startingEnergy {
+		t.Errorf("the grazer's energy fell to %v", grazer.Energy)
+	}
+}
+
+// Predators catch the grazers next to them, and starve with none about
+func TestPredatorsNeedPrey(t *testing.T) {
+	setUpWorld(t)
+	hunter := &Animal{Kind: predatorKind, X: 30, Y: 30, Energy: startingEnergy}
+	prey := &Animal{Kind: grazerKind, X: 31, Y: 30, Energy: startingEnergy}
+	loner := &Animal{Kind: predatorKind, X: 100, Y: 100, Energy: startingEnergy}
+	animals = []*Animal{hunter, prey, loner}
+	for tick := 0; tick < 100 && !prey.dead; tick++ {
+		simulateFauna()
+	}
+	if !prey.dead {
+		t.Fatal("the predator never caught the grazer next to it")
+	}
+	if hunter.Energy <= loner.Energy {
+		t.Errorf("the predator that ate has %v energy, and the one that didn't %v", hunter.Energy, loner.Energy)
+	}
+	for tick := 0; tick < ticksPerDay && !loner.dead; tick++ {
+		simulateFauna()
+	}
+	if !loner.dead {
+		t.Errorf("a predator with nothing to hunt has %v energy left", loner.Energy)
+	}
+}
+
+// Every statsInterval ticks the stats message counts the animals alive
+func TestPopulationStats(t *testing.T) {
+	setUpWorld(t)
+	for i := 0; i < 12; i++ {
+		animals = append(animals, &Animal{Kind: grazerKind, X: 10 + i, Y: 40, Energy: startingEnergy})
+	}
+	for i := 0; i < 3; i++ {
+		animals = append(animals, &Animal{Kind: predatorKind, X: 10 + i, Y: 80, Energy: startingEnergy})
+	}
+	version := statsVersion
+	for simClock.Tick = 1; simClock.Tick < statsInterval; simClock.Tick++ {
+		simulateFauna()
+	}
+	if statsVersion != version {
+		t.Fatal("counted the animals before the interval was up")
+	}
+	simulateFauna()
+	if statsVersion != version+1 || faunaStats.Tick != statsInterval {
+		t.Fatalf("stats are from tick %d", faunaStats.Tick)
+	}
+	counts := make(map[string]int)
+	for _, a := range animals {
+		counts[a.Kind]++
+	}
+	for _, stats := range faunaStats.Populations {
+		if stats.Count != counts[stats.Kind] {
+			t.Errorf("stats count %d %ss, but %d are alive", stats.Count, stats.Kind, counts[stats.Kind])
+		}
+	}
+}
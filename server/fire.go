@@ -0,0 +1,166 @@
+// fire.go
+package main
+
+import (
+	"math"
+)
+
+// Fires start from lightning or players and spread through grass, shrubs and
+// forest, most easily through dry, overgrown tiles and in the direction the
+// wind is blowing. Burnt ground is left covered in nutrient rich ash, which
+// regrows through grass and shrubs before it turns back into forest.
+const (
+	lightningChance      = 0.002 // per tick for each storm cell
+	dryLightningChance   = 0.001 // per tick for each cell in severe drought
+	dryLightningSeverity = 0.5
+
+	ignitionMoisture = 0.5  // wetter ground than this won't burn
+	fireSpreadChance = 0.2  // per tick for each neighbour, scaled by how flammable it is
+	windSpreadBias   = 0.8  // fires spread up to this much more often downwind, and less often upwind
+	burnRate         = 0.05 // vegetation burnt per tick
+	rainQuenchRate   = 0.1  // fuel put out per tick of full rain
+	ashNutrient      = 0.6  // nutrient added to the ground for each unit of vegetation burnt
+
+	scorchRecoveryRate = 1.0 / (3 * ticksPerDay) // burnt ground takes three days to recover
+	ashScorch          = 0.75                    // nothing grows back until the ash has settled
+	nutrientUptake     = 0.5                     // nutrient used up for each unit of vegetation regrown
+)
+
+// The tiles that are on fire, in the order they caught. Guarded by worldLock.
+var fires [][2]int
+
+// How readily a tile catches fire, from 0 to 1. Fires barely spread at the
+// ambient moisture and race through dry ground in a drought.
+func flammability(x, y int) float64 {
+	tile := &tiles[x][y]
+	if tile.Fire > 0 || tile.Water >= puddleDepth {
+		return 0
+	}
+	switch tile.Type {
+	case grass, shrub, forest:
+		drought := droughtSeverity(weather.Cells[x/weatherCellSize][y/weatherCellSize])
+		dryness := math.Max(1-tile.Moisture/ignitionMoisture, 0)
+		return math.Min(tile.Vegetation*dryness*(dryness+drought), 1)
+	}
+	return 0
+}
+
+// Sets the tile alight, returning false if there's nothing there to burn
+func ignite(x, y int) bool {
+	if flammability(x, y) == 0 {
+		return false
+	}
+	tiles[x][y].Fire = tiles[x][y].Vegetation
+	fires = append(fires, [2]int{x, y})
+	setTileType(x, y, fire)
+	return true
+}
+
+// Strikes lightning, burns the fires down and spreads them to their neighbours
+func simulateFire() {
+	strikeLightning()
+	burning := fires
+	fires = nil
+	for _, position := range burning {
+		x, y := position[0], position[1]
+		tile := &tiles[x][y]
+		// Editing the tile or building over it puts the fire out
+		if tile.Fire == 0 || isFixedTile(tile.Type) {
+			tile.Fire = 0
+			continue
+		}
+		cell := weather.Cells[x/weatherCellSize][y/weatherCellSize]
+		burned := math.Min(burnRate, tile.Fire)
+		tile.Vegetation = math.Max(tile.Vegetation-burned, 0)
+		tile.Nutrient = math.Min(tile.Nutrient+burned*ashNutrient, 1)
+		tile.Fire -= burned + rainQuenchRate*rainIntensity(cell)
+		if tile.Fire <= 0 {
+			tile.Fire = 0
+			tile.Scorch = 1
+			continue
+		}
+		fires = append(fires, position)
+		spreadFire(x, y)
+	}
+}
+
+// Lightning strikes a random tile in storm cells, and now and then in drought
+func strikeLightning() {
+	for cx := 0; cx < weatherCellsWide; cx++ {
+		for cy := 0; cy < weatherCellsHigh; cy++ {
+			cell := weather.Cells[cx][cy]
+			chance := 0.0
+			if cell > stormThreshold {
+				chance = lightningChance
+			} else if droughtSeverity(cell) > dryLightningSeverity {
+				chance = dryLightningChance
+			}
+			if chance == 0 || rng.Float64() >= chance {
+				continue
+			}
+			x := min(cx*weatherCellSize+rng.Intn(weatherCellSize), tilesWide-1)
+			y := min(cy*weatherCellSize+rng.Intn(weatherCellSize), tilesHigh-1)
+			if rng.Float64() < flammability(x, y) {
+				ignite(x, y)
+			}
+		}
+	}
+}
+
+func spreadFire(x, y int) {
+	windX, windY := weather.WindX/windSpeed, weather.WindY/windSpeed
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			nx, ny := x+dx, y+dy
+			if (dx == 0 && dy == 0) || nx < 0 || nx >= tilesWide || ny < 0 || ny >= tilesHigh {
+				continue
+			}
+			downwind := (float64(dx)*windX + float64(dy)*windY) / math.Hypot(float64(dx), float64(dy))
+			chance := fireSpreadChance * flammability(nx, ny) * (1 + windSpreadBias*downwind)
+			if rng.Float64() < chance {
+				ignite(nx, ny)
+			}
+		}
+	}
+}
+
+// Scorched ground slowly recovers, then goes back to its usual type
+func recoverFromFire(tile *Tile) {
+	if tile.Scorch > 0 {
+		tile.Scorch = math.Max(tile.Scorch-scorchRecoveryRate, 0)
+	}
+}
+
+// Burning tiles are fire, and scorched ground is burnt until the ash settles
+// and something grows back, then regrows as grass and shrubs before the
+// forest returns
+func successionTileType(tile *Tile, tileType int) int {
+	if tile.Fire > 0 {
+		return fire
+	}
+	if tile.Scorch == 0 {
+		return tileType
+	}
+	switch tileType {
+	case grass, dirt, forest:
+		if tile.Scorch > ashScorch || tile.Vegetation < barrenVegetation {
+			return burnt
+		}
+		if tileType == forest {
+			return shrub
+		}
+	}
+	return tileType
+}
+
+// Rebuilds the list of fires from the tiles, after loading a world
+func resetFires() {
+	fires = nil
+	for i := 0; i < tilesWide; i++ {
+		for j := 0; j < tilesHigh; j++ {
+			if tiles[i][j].Fire > 0 {
+				fires = append(fires, [2]int{i, j})
+			}
+		}
+	}
+}
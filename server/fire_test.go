@@ -0,0 +1,97 @@
+package main
+
+import "testing"
+
+// A fire on a dry tile in wet surroundings burns out into ash without spreading
+func TestFireBurnsOut(t *testing.T) {
+	setUpWorld(t)
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			tiles[i][j].Moisture = 1
+		}
+	}
+	tiles[5][5].Moisture = 0
+	tiles[5][5].Vegetation = 0.5
+	if !ignite(5, 5) || tiles[5][5].Type != fire {
+		t.Fatal("the dry tile didn't catch")
+	}
+	for tick := 0; len(fires) > 0; tick++ {
+		if tick > 100 {
+			t.Fatal("the fire never burnt out")
+		}
+		simulateFire()
+	}
+	tile := tiles[5][5]
+	if tile.Fire != 0 || tile.Scorch != 1 || tile.Vegetation != 0 || tile.Nutrient <= 0 {
+		t.Errorf("the burnt out tile is %+v", tile)
+	}
+	if tileType := successionTileType(&tile, grass); tileType != burnt {
+		t.Errorf("burnt out grass is tile type %d", tileType)
+	}
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			if (i != 5 || j != 5) && tiles[i][j].Scorch != 0 {
+				t.Errorf("the fire spread to wet ground at (%d, %d)", i, j)
+			}
+		}
+	}
+}
+
+func TestFireSpreadsThroughDryGround(t *testing.T) {
+	setUpWorld(t)
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			tiles[i][j].Moisture = 0
+		}
+	}
+	ignite(5, 5)
+	for tick := 0; tick < 20; tick++ {
+		simulateFire()
+	}
+	burning := 0
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			if tiles[i][j].Fire > 0 || tiles[i][j].Scorch > 0 {
+				burning++
+			}
+		}
+	}
+	if burning < 2 {
+		t.Error("the fire didn't spread through dry grass")
+	}
+	tiles[20][20].Moisture = ignitionMoisture
+	if ignite(20, 20) {
+		t.Error("damp ground caught fire")
+	}
+}
+
+// Burnt ground regrows as grass and shrubs before the forest returns
+func TestSuccession(t *testing.T) {
+	tests := []struct {
+		name     string
+		tile     Tile
+		natural  int
+		expected int
+	}{
+		{"fresh ash", Tile{Scorch: 1, Vegetation: 0.8}, forest, burnt},
+		{"settled but bare", Tile{Scorch: ashScorch / 2}, grass, burnt},
+		{"regrown forest", Tile{Scorch: ashScorch / 2, Vegetation: 0.8}, forest, shrub},
+		{"regrown grass", Tile{Scorch: ashScorch / 2, Vegetation: 0.8}, grass, grass},
+		{"recovered", Tile{Vegetation: 0.8}, forest, forest},
+		{"burning", Tile{Fire: 0.3}, forest, fire},
+	}
+	for _, test := range tests {
+		if tileType := successionTileType(&test.tile, test.natural); tileType != test.expected {
+			t.Errorf("%s: tile type %d, expected %d", test.name, tileType, test.expected)
+		}
+	}
+
+	// Scorch recovers over three days
+	tile := Tile{Scorch: 1}
+	for tick := 0; tick < 3*ticksPerDay; tick++ {
+		recoverFromFire(&tile)
+	}
+	if tile.Scorch > 1e-9 {
+		t.Errorf("scorch is %v after three days", tile.Scorch)
+	}
+}
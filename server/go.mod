@@ -2,6 +2,11 @@ module growth-server
 
 go 1.23.2
 
-require github.com/gorilla/websocket v1.5.3
+require (
+	github.com/gorilla/websocket v1.5.3
+	growth-protocol v0.0.0
+)
 
 require github.com/aquilax/go-perlin v1.1.0 // indirect
+
+replace growth-protocol => ../protocol
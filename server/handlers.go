@@ -0,0 +1,300 @@
+// handlers.go
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"growth-protocol"
+)
+
+// A messageHandler acts on one type of client request, already decoded and validated.
+// It returns the reply to send, or nil for a plain ack.
+type messageHandler struct {
+	role   string // the least privileged role allowed to send the request, empty if no login is needed
+	world  bool   // whether the request changes the world, see worldHandler
+	handle func(client *Client, msg protocol.Message) (protocol.Message, error)
+}
+
+// Wraps a typed handler so it can be stored in the registry
+func handler[T protocol.Message](role string, fn func(client *Client, msg T) (protocol.Message, error)) messageHandler {
+	return messageHandler{
+		role: role,
+		handle: func(client *Client, msg protocol.Message) (protocol.Message, error) {
+			return fn(client, msg.(T))
+		},
+	}
+}
+
+// Wraps a handler for a request that changes the world. These run with worldLock
+// held, are recorded in the replay log when they succeed, and are refused
+// while a replay is playing.
+func worldHandler[T protocol.Message](role string, fn func(client *Client, msg T) (protocol.Message, error)) messageHandler {
+	h := handler(role, fn)
+	h.world = true
+	return h
+}
+
+var handlers = map[string]messageHandler{
+	protocol.TypeLogin:       handler("", handleLogin),
+	protocol.TypeViewport:    handler(protocol.RoleSpectator, handleViewport),
+	protocol.TypeSetOverlays: handler(protocol.RoleSpectator, handleSetOverlays),
+	protocol.TypeResync:      handler(protocol.RoleSpectator, handleResync),
+	protocol.TypeCursor:      handler(protocol.RoleSpectator, handleCursor),
+	protocol.TypeChat:        handler(protocol.RoleSpectator, handleChat),
+	protocol.TypeUpdateTile:  worldHandler(protocol.RolePlayer, handleUpdateTile),
+	protocol.TypePlaceHive:   worldHandler(protocol.RolePlayer, handlePlaceHive),
+	protocol.TypeBuildNest:   worldHandler(protocol.RolePlayer, handleBuildNest),
+	protocol.TypePostTask:    worldHandler(protocol.RolePlayer, handlePostTask),
+	protocol.TypeCancelTask:  worldHandler(protocol.RolePlayer, handleCancelTask),
+	protocol.TypePlaceDrone:  worldHandler(protocol.RolePlayer, handlePlaceDrone),
+	protocol.TypeIgnite:      worldHandler(protocol.RolePlayer, handleIgnite),
+	protocol.TypeUndo:        worldHandler(protocol.RolePlayer, handleUndo),
+	protocol.TypeRedo:        worldHandler(protocol.RolePlayer, handleRedo),
+
+	protocol.TypePlaceStructure: worldHandler(protocol.RolePlayer, handlePlaceStructure),
+	protocol.TypeEditTiles:      worldHandler(protocol.RolePlayer, handleEditTiles),
+
+	protocol.TypeResetTiles:  handler(protocol.RoleAdmin, handleResetTiles),
+	protocol.TypeSaveWorld:   handler(protocol.RoleAdmin, handleSaveWorld),
+	protocol.TypeLoadWorld:   handler(protocol.RoleAdmin, handleLoadWorld),
+	protocol.TypePause:       handler(protocol.RoleAdmin, handlePause),
+	protocol.TypeSetSpeed:    handler(protocol.RoleAdmin, handleSetSpeed),
+	protocol.TypeSetTickRate: handler(protocol.RoleAdmin, handleSetTickRate),
+	protocol.TypeSetParam:    worldHandler(protocol.RoleAdmin, handleSetParam),
+	protocol.TypeKick:        handler(protocol.RoleAdmin, handleKick),
+	protocol.TypeAnnounce:    handler(protocol.RoleAdmin, handleAnnounce),
+	protocol.TypeSetRole:     handler(protocol.RoleAdmin, handleSetRole),
+	protocol.TypeRollback:    worldHandler(protocol.RoleAdmin, handleRollback),
+}
+
+var roleRanks = map[string]int{
+	protocol.RoleSpectator: 1,
+	protocol.RolePlayer:    2,
+	protocol.RoleAdmin:     3,
+}
+
+// Decodes a raw message from the client, runs its handler and sends back
+// the reply, an ack if the request had an id, or an error. Returns an error
+// when the client has been rejected too often and should be disconnected.
+func dispatch(client *Client, data []byte) error {
+	// Requests aren't logged, as logins carry passwords and chat may be private
+	envelope, msg, err := protocol.Decode(data)
+	if err == nil {
+		err = client.checkRateLimit(envelope.Type)
+	}
+	if err != nil {
+		// Malformed and rate limited requests count against the client
+		client.sendError(envelope, err)
+		if !client.strikes.allow(time.Now()) {
+			return fmt.Errorf("too many rejected requests")
+		}
+		return nil
+	}
+
+	h, ok := handlers[envelope.Type]
+	if !ok {
+		// Server messages decode fine but can't be sent by clients
+		client.sendError(envelope, protocol.Errorf(protocol.CodeUnknownType, "clients can't send %q messages", envelope.Type))
+		return nil
+	}
+	if h.role != "" {
+		if client.Session == nil {
+			client.sendError(envelope, protocol.Errorf(protocol.CodeUnauthenticated, "%s requires a login", envelope.Type))
+			return nil
+		}
+		if roleRanks[client.Session.getRole()] < roleRanks[h.role] {
+			client.sendError(envelope, protocol.Errorf(protocol.CodeForbidden, "%s requires the %s role", envelope.Type, h.role))
+			return nil
+		}
+	}
+
+	var reply protocol.Message
+	if h.world {
+		reply, err = handleWorldRequest(h, client, msg)
+	} else {
+		reply, err = h.handle(client, msg)
+	}
+	if err != nil {
+		client.sendError(envelope, err)
+		return nil
+	}
+	if reply == nil {
+		if envelope.ID == "" {
+			return nil
+		}
+		reply = protocol.NewAck(envelope)
+	}
+	err = client.send(reply)
+	if err != nil {
+		fmt.Println("Write error:", err)
+	}
+	return nil
+}
+
+// Runs a world changing request under worldLock, recording it with the tick it
+// was applied on so replays apply it at the same point in the simulation
+func handleWorldRequest(h messageHandler, client *Client, msg protocol.Message) (protocol.Message, error) {
+	worldLock.Lock()
+	defer worldLock.Unlock()
+	if playback != nil {
+		return nil, protocol.Errorf(protocol.CodeForbidden, "the world can't be changed while a replay is playing")
+	}
+	reply, err := h.handle(client, msg)
+	if err == nil {
+		recordCommand(client.Player.Name, msg)
+	}
+	return reply, err
+}
+
+// Returns the client's hive, or an error if they haven't placed one.
+// Must be called with worldLock held.
+func requireHive(client *Client, action string) (*Hive, error) {
+	hive, ok := client.hive()
+	if !ok {
+		return nil, protocol.Errorf(protocol.CodeNoHive, "%s requires a hive", action)
+	}
+	return hive, nil
+}
+
+// Clients either log in with a password or resume an earlier session with its token
+func handleLogin(client *Client, msg *protocol.LoginMessage) (protocol.Message, error) {
+	err := checkLoginLimit(client.address, msg)
+	if err != nil {
+		return nil, err
+	}
+	var session *Session
+	resumed := msg.Token != ""
+	if resumed {
+		session, err = resumeSession(msg.Token)
+	} else {
+		session, err = authenticate(msg.Username, msg.Password)
+	}
+	if err != nil {
+		fmt.Println("Login failed:", err)
+		return nil, protocol.Errorf(protocol.CodeLoginFailed, "%v", err)
+	}
+	worldLock.Lock()
+	client.Player = getPlayer(session.Username)
+	client.Session = session
+	worldLock.Unlock()
+	fmt.Println("Client logged in:", session.Username, "role:", session.getRole(), "resumed:", resumed)
+	return &protocol.SessionMessage{
+		Envelope: protocol.Envelope{Type: protocol.TypeSession, ID: msg.ID},
+		Version:  protocol.Version,
+		Token:    session.Token,
+		Username: session.Username,
+		Role:     session.getRole(),
+		Viewport: session.getViewport(),
+		Resumed:  resumed,
+	}, nil
+}
+
+// Viewports are clamped in size, as animals and overlays are sent for the whole of one
+func handleViewport(client *Client, msg *protocol.ViewportMessage) (protocol.Message, error) {
+	viewport := msg.Viewport
+	viewport.Width = min(viewport.Width, protocol.MaxViewportSize)
+	viewport.Height = min(viewport.Height, protocol.MaxViewportSize)
+	client.Session.setViewport(viewport)
+	return nil, nil
+}
+
+func handleSetOverlays(client *Client, msg *protocol.SetOverlaysMessage) (protocol.Message, error) {
+	client.Session.setOverlays(msg.Fields)
+	return nil, nil
+}
+
+func handleCursor(client *Client, msg *protocol.CursorMessage) (protocol.Message, error) {
+	client.Session.setCursor(msg.X, msg.Y)
+	return nil, nil
+}
+
+func handleResync(client *Client, msg *protocol.ResyncMessage) (protocol.Message, error) {
+	worldLock.Lock()
+	defer worldLock.Unlock()
+	client.resync = append(client.resync, msg.Chunks...)
+	return nil, nil
+}
+
+func handleUpdateTile(client *Client, msg *protocol.UpdateTileMessage) (protocol.Message, error) {
+	err := checkTileEdit(client.Player, *msg.X, *msg.Y, *msg.Value)
+	if err != nil {
+		return nil, err
+	}
+	before := saveTileState(*msg.X, *msg.Y)
+	applyTileEdit(*msg.X, *msg.Y, *msg.Value)
+	recordEdit(client.Player, []tileChange{{X: *msg.X, Y: *msg.Y, Before: before, After: saveTileState(*msg.X, *msg.Y)}})
+	return nil, nil
+}
+
+func handleEditTiles(client *Client, msg *protocol.EditTilesMessage) (protocol.Message, error) {
+	return nil, editTiles(client.Player, msg)
+}
+
+func handleUndo(client *Client, msg *protocol.UndoMessage) (protocol.Message, error) {
+	return nil, undoEdit(client.Player)
+}
+
+func handleRedo(client *Client, msg *protocol.RedoMessage) (protocol.Message, error) {
+	return nil, redoEdit(client.Player)
+}
+
+func handlePlaceHive(client *Client, msg *protocol.PlaceHiveMessage) (protocol.Message, error) {
+	_, err := addHive(client.Player, *msg.X, *msg.Y)
+	return nil, err
+}
+
+func handleBuildNest(client *Client, msg *protocol.BuildNestMessage) (protocol.Message, error) {
+	hive, err := requireHive(client, protocol.TypeBuildNest)
+	if err != nil {
+		return nil, err
+	}
+	return nil, hive.queueJob(*msg.X, *msg.Y, *msg.Value)
+}
+
+func handlePlaceStructure(client *Client, msg *protocol.PlaceStructureMessage) (protocol.Message, error) {
+	hive, err := requireHive(client, protocol.TypePlaceStructure)
+	if err != nil {
+		return nil, err
+	}
+	structure, _ := protocol.FindStructure(msg.Structure)
+	return nil, hive.placeStructure(structure, *msg.X, *msg.Y, msg.Rotation)
+}
+
+func handlePostTask(client *Client, msg *protocol.PostTaskMessage) (protocol.Message, error) {
+	hive, err := requireHive(client, protocol.TypePostTask)
+	if err != nil {
+		return nil, err
+	}
+	_, err = hive.postTask(msg.Kind, *msg.X, *msg.Y)
+	return nil, err
+}
+
+func handleCancelTask(client *Client, msg *protocol.CancelTaskMessage) (protocol.Message, error) {
+	hive, err := requireHive(client, protocol.TypeCancelTask)
+	if err != nil {
+		return nil, err
+	}
+	return nil, hive.cancelTask(*msg.X, *msg.Y)
+}
+
+// Players can only start fires in their own territory
+func handleIgnite(client *Client, msg *protocol.IgniteMessage) (protocol.Message, error) {
+	err := checkOwnership(client.Player, *msg.X, *msg.Y)
+	if err != nil {
+		return nil, err
+	}
+	if !ignite(*msg.X, *msg.Y) {
+		return nil, fmt.Errorf("nothing at (%d, %d) will burn", *msg.X, *msg.Y)
+	}
+	return nil, nil
+}
+
+func handlePlaceDrone(client *Client, msg *protocol.PlaceDroneMessage) (protocol.Message, error) {
+	hive, err := requireHive(client, protocol.TypePlaceDrone)
+	if err != nil {
+		return nil, err
+	}
+	_, err = hive.buyDrone()
+	return nil, err
+}
@@ -0,0 +1,169 @@
+// history.go
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"growth-protocol"
+)
+
+// Every hand edit is recorded along with who made it, when, and the state of
+// each tile before and after, so players can undo and redo their own edits
+// and admins can roll back everything a player did over a stretch of time.
+// Players only undo and redo edits on tiles they still own, while rollbacks
+// reach every tile. Neither touches tiles drones have built over since.
+const maxEditHistory = 200 // operations kept per player
+
+// The parts of a tile a hand edit changes
+type tileState struct {
+	Type       int
+	Altitude   float64
+	Water      float64
+	Vegetation float64
+	Fire       float64
+	Scorch     float64
+}
+
+type tileChange struct {
+	X      int
+	Y      int
+	Before tileState
+	After  tileState
+}
+
+// An editOperation is one updateTile or editTiles request
+type editOperation struct {
+	Author  string
+	Tick    int64
+	Time    time.Time
+	Changes []tileChange
+}
+
+type editHistory struct {
+	done   []*editOperation // oldest first
+	undone []*editOperation // most recently undone last
+}
+
+// Guarded by worldLock
+var editHistories = make(map[string]*editHistory)
+
+func resetEditHistory() {
+	editHistories = make(map[string]*editHistory)
+}
+
+func historyFor(name string) *editHistory {
+	history, ok := editHistories[name]
+	if !ok {
+		history = &editHistory{}
+		editHistories[name] = history
+	}
+	return history
+}
+
+func saveTileState(x, y int) tileState {
+	tile := &tiles[x][y]
+	return tileState{Type: tile.Type, Altitude: tile.Altitude, Water: tile.Water, Vegetation: tile.Vegetation, Fire: tile.Fire, Scorch: tile.Scorch}
+}
+
+func restoreTileState(x, y int, state tileState) {
+	tile := &tiles[x][y]
+	tile.Altitude = state.Altitude
+	tile.Water = state.Water
+	tile.Vegetation = state.Vegetation
+	tile.Fire = state.Fire
+	tile.Scorch = state.Scorch
+	setTileType(x, y, state.Type)
+}
+
+// Records a player's edit. A new edit can't be redone over, so it clears the
+// player's redo stack.
+func recordEdit(player *Player, changes []tileChange) {
+	if len(changes) == 0 {
+		return
+	}
+	history := historyFor(player.Name)
+	history.done = append(history.done, &editOperation{Author: player.Name, Tick: simClock.Tick, Time: time.Now(), Changes: changes})
+	if len(history.done) > maxEditHistory {
+		history.done = history.done[len(history.done)-maxEditHistory:]
+	}
+	history.undone = nil
+}
+
+// Puts each tile of the operation back to its before or after state, in
+// reverse order when undoing so overlapping changes unwind correctly. Tiles
+// the player doesn't own are skipped, unless player is nil as it is for
+// rollbacks. Returns how many tiles were changed.
+func replayOperation(player *Player, op *editOperation, undo bool) int {
+	changed := 0
+	for i := range op.Changes {
+		change := op.Changes[i]
+		state := change.After
+		if undo {
+			change = op.Changes[len(op.Changes)-1-i]
+			state = change.Before
+		}
+		if (player != nil && checkOwnership(player, change.X, change.Y) != nil) || isFixedTile(tiles[change.X][change.Y].Type) {
+			continue
+		}
+		restoreTileState(change.X, change.Y, state)
+		changed++
+	}
+	return changed
+}
+
+func undoEdit(player *Player) error {
+	history := historyFor(player.Name)
+	if len(history.done) == 0 {
+		return protocol.Errorf(protocol.CodeRejected, "there is nothing to undo")
+	}
+	op := history.done[len(history.done)-1]
+	if replayOperation(player, op, true) == 0 {
+		return protocol.Errorf(protocol.CodeRejected, "none of the edit could be undone")
+	}
+	history.done = history.done[:len(history.done)-1]
+	history.undone = append(history.undone, op)
+	return nil
+}
+
+func redoEdit(player *Player) error {
+	history := historyFor(player.Name)
+	if len(history.undone) == 0 {
+		return protocol.Errorf(protocol.CodeRejected, "there is nothing to redo")
+	}
+	op := history.undone[len(history.undone)-1]
+	if replayOperation(player, op, false) == 0 {
+		return protocol.Errorf(protocol.CodeRejected, "none of the edit could be redone")
+	}
+	history.undone = history.undone[:len(history.undone)-1]
+	history.done = append(history.done, op)
+	return nil
+}
+
+// Undoes every edit the player made between the two ticks, newest first,
+// including on tiles that have changed hands since. Rolled back edits can't
+// be redone.
+func rollbackEdits(name string, fromTick, toTick int64) (int, error) {
+	history, ok := editHistories[name]
+	if !ok {
+		return 0, fmt.Errorf("%s hasn't made any edits", name)
+	}
+	operations := 0
+	kept := history.done[:0]
+	for i := len(history.done) - 1; i >= 0; i-- {
+		op := history.done[i]
+		if op.Tick >= fromTick && op.Tick <= toTick {
+			replayOperation(nil, op, true)
+			operations++
+		}
+	}
+	for _, op := range history.done {
+		if op.Tick < fromTick || op.Tick > toTick {
+			kept = append(kept, op)
+		}
+	}
+	clear(history.done[len(kept):])
+	history.done = kept
+	history.undone = nil
+	return operations, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+// Gives ada the first territory chunk of an empty world, and records an edit
+// of its corner tile to dirt
+func setUpEditHistory(t *testing.T) *Player {
+	setUpWorld(t)
+	chunkOwners[0][0] = "ada"
+
+	player := &Player{Name: "ada", Inventory: map[string]int{}}
+	before := saveTileState(0, 0)
+	setTileType(0, 0, dirt)
+	recordEdit(player, []tileChange{{X: 0, Y: 0, Before: before, After: saveTileState(0, 0)}})
+	return player
+}
+
+func TestUndoRedo(t *testing.T) {
+	player := setUpEditHistory(t)
+	if err := undoEdit(player); err != nil {
+		t.Fatal(err)
+	}
+	if tiles[0][0].Type != grass {
+		t.Fatalf("undo left tile type %d", tiles[0][0].Type)
+	}
+	if err := redoEdit(player); err != nil {
+		t.Fatal(err)
+	}
+	if tiles[0][0].Type != dirt {
+		t.Fatalf("redo left tile type %d", tiles[0][0].Type)
+	}
+	if undoEdit(player) != nil || undoEdit(player) == nil {
+		t.Error("undid more edits than were made")
+	}
+}
+
+// An undo that can't change anything leaves the edit where it was
+func TestFailedUndoKeepsEdit(t *testing.T) {
+	player := setUpEditHistory(t)
+	chunkOwners[0][0] = "grace"
+	if undoEdit(player) == nil {
+		t.Fatal("undid an edit on someone else's tile")
+	}
+	history := historyFor(player.Name)
+	if len(history.done) != 1 || len(history.undone) != 0 {
+		t.Fatalf("failed undo moved the edit: %d done, %d undone", len(history.done), len(history.undone))
+	}
+
+	chunkOwners[0][0] = "ada"
+	if err := undoEdit(player); err != nil {
+		t.Fatal(err)
+	}
+	chunkOwners[0][0] = "grace"
+	if redoEdit(player) == nil {
+		t.Fatal("redid an edit on someone else's tile")
+	}
+	if len(history.done) != 0 || len(history.undone) != 1 {
+		t.Errorf("failed redo moved the edit: %d done, %d undone", len(history.done), len(history.undone))
+	}
+}
+
+// Rollbacks undo edits even on tiles the player no longer owns
+func TestRollbackIgnoresOwnership(t *testing.T) {
+	setUpEditHistory(t)
+	chunkOwners[0][0] = ""
+	operations, err := rollbackEdits("ada", 0, simClock.Tick)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if operations != 1 || tiles[0][0].Type != grass {
+		t.Errorf("rolled back %d edits, leaving tile type %d", operations, tiles[0][0].Type)
+	}
+}
+
+func TestRollbackSkipsFixedTiles(t *testing.T) {
+	setUpEditHistory(t)
+	setTileType(0, 0, nest)
+	if _, err := rollbackEdits("ada", 0, simClock.Tick); err != nil {
+		t.Fatal(err)
+	}
+	if tiles[0][0].Type != nest {
+		t.Errorf("rollback replaced a nest with tile type %d", tiles[0][0].Type)
+	}
+}
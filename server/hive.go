@@ -0,0 +1,453 @@
+// hive.go
+package main
+
+import (
+	"fmt"
+
+	"growth-protocol"
+	"growth-server/pathfinding"
+)
+
+// A Hive is the immobile 'hivemind' that leads a player's drones.
+// The nest grows around it as drones deliver materials to queued build jobs.
+type Hive struct {
+	ID         int
+	Owner      string
+	Player     *Player
+	X          int
+	Y          int
+	Jobs       []*BuildJob
+	Tasks      []*Task
+	Drones     []*Drone
+	NestRadius int
+}
+
+// A BuildJob is a single nest tile waiting to be built by a drone
+type BuildJob struct {
+	X        int
+	Y        int
+	TileType int
+	Drone    *Drone
+}
+
+const (
+	droneIdle = iota
+	droneFetching
+	droneDelivering
+	droneBuilding
+	droneHarvesting
+	droneReturning
+)
+
+type Drone struct {
+	ID           int
+	X            int
+	Y            int
+	Hive         *Hive
+	Job          *BuildJob
+	State        int
+	Progress     int
+	Capabilities []string
+	Task         *Task
+	Bidding      *Task
+	Path         *pathfinding.Path
+	TargetX      int
+	TargetY      int
+	Cargo        map[string]int
+}
+
+const (
+	hivePadSize    = 7
+	startingDrones = 6
+	maxNestRadius  = 12
+	buildTicks     = 4
+	harvestTicks   = 3
+	harvestRadius  = 24
+)
+
+// Materials a drone has to carry from the hive to build each nest tile type
+var nestTileCosts = map[int]map[string]int{
+	concrete: {resourceStone: 2},
+	nest:     {resourceNutrients: 3},
+	pump:     {resourceStone: 6, resourceMinerals: 1},
+}
+
+var hives []*Hive
+var hivesByOwner = make(map[string]*Hive)
+var nextHiveID = 1
+var nextDroneID = 1
+
+func resetHives() {
+	hives = nil
+	hivesByOwner = make(map[string]*Hive)
+	nextHiveID = 1
+	nextDroneID = 1
+	nextTaskID = 1
+}
+
+func isBuildableTile(tileType int) bool {
+	return protocol.IsOpenGround(tileType)
+}
+
+func canBuildOn(tileType, ground int) bool {
+	return protocol.CanBuildOn(tileType, ground)
+}
+
+// Places a player's hive on a concrete pad like the old starting platform.
+// Each player may only place one hive.
+func addHive(player *Player, x, y int) (*Hive, error) {
+	owner := player.Name
+	if _, ok := hivesByOwner[owner]; ok {
+		return nil, fmt.Errorf("%s already has a hive", owner)
+	}
+	half := hivePadSize / 2
+	if x-half < 0 || x+half >= tilesWide || y-half < 0 || y+half >= tilesHigh {
+		return nil, fmt.Errorf("hive at (%d, %d) is too close to the edge of the map", x, y)
+	}
+	// The pad is the platform structure, a 7x7 square of concrete missing its
+	// corners, and every tile of it has to be open ground
+	platform, _ := protocol.FindStructure(protocol.StructurePlatform)
+	platformTiles := platform.Tiles(x, y, 0)
+	for _, tile := range platformTiles {
+		if ground := tiles[tile.X][tile.Y].Type; !canBuildOn(tile.Type, ground) {
+			return nil, fmt.Errorf("cannot place a hive over tile type %d at (%d, %d)", ground, tile.X, tile.Y)
+		}
+	}
+	for i := x - half; i <= x+half; i++ {
+		for j := y - half; j <= y+half; j++ {
+			if claimedBy := chunkOwner(i, j); claimedBy != "" && claimedBy != owner {
+				return nil, fmt.Errorf("(%d, %d) belongs to %s", i, j, claimedBy)
+			}
+		}
+	}
+
+	for _, tile := range platformTiles {
+		setTileType(tile.X, tile.Y, tile.Type)
+	}
+	setTileType(x, y, hiveCore)
+
+	hive := newHive(player, x, y, half, startingDrones)
+	fmt.Printf("Hive %d placed for %s at (%d, %d)\n", hive.ID, owner, x, y)
+	return hive, nil
+}
+
+// Registers a hive whose tiles are already in place, and claims its territory
+func newHive(player *Player, x, y, nestRadius, drones int) *Hive {
+	hive := &Hive{
+		ID:         nextHiveID,
+		Owner:      player.Name,
+		Player:     player,
+		X:          x,
+		Y:          y,
+		NestRadius: nestRadius,
+	}
+	nextHiveID++
+	for i := 0; i < drones; i++ {
+		hive.spawnDrone()
+	}
+	hives = append(hives, hive)
+	hivesByOwner[player.Name] = hive
+	hive.claimTerritory()
+	return hive
+}
+
+func (h *Hive) spawnDrone() *Drone {
+	capabilities := droneCapabilities[nextDroneID%len(droneCapabilities)]
+	drone := &Drone{ID: nextDroneID, X: h.X, Y: h.Y, Hive: h, Capabilities: capabilities}
+	nextDroneID++
+	h.Drones = append(h.Drones, drone)
+	return drone
+}
+
+// Queues a nest tile to be built by the hive's drones
+func (h *Hive) queueJob(x, y, tileType int) error {
+	if err := h.checkJob(x, y, tileType); err != nil {
+		return err
+	}
+	h.Jobs = append(h.Jobs, &BuildJob{X: x, Y: y, TileType: tileType})
+	return nil
+}
+
+func (h *Hive) checkJob(x, y, tileType int) error {
+	if _, ok := nestTileCosts[tileType]; !ok {
+		return fmt.Errorf("tile type %d is not a nest tile", tileType)
+	}
+	if err := checkOwnership(h.Player, x, y); err != nil {
+		return err
+	}
+	if !canBuildOn(tileType, tiles[x][y].Type) {
+		return fmt.Errorf("cannot build tile type %d on tile type %d", tileType, tiles[x][y].Type)
+	}
+	for _, job := range h.Jobs {
+		if job.X == x && job.Y == y {
+			return fmt.Errorf("a job is already queued at (%d, %d)", x, y)
+		}
+	}
+	return nil
+}
+
+// Queues a job for every tile of a structure. The whole structure has to fit
+// and be affordable, or nothing is queued.
+func (h *Hive) placeStructure(structure protocol.Structure, x, y, rotation int) error {
+	structureTiles := structure.Tiles(x, y, rotation)
+	cost := make(map[string]int)
+	for _, tile := range structureTiles {
+		if err := h.checkJob(tile.X, tile.Y, tile.Type); err != nil {
+			return err
+		}
+		for resource, amount := range nestTileCosts[tile.Type] {
+			cost[resource] += amount
+		}
+	}
+	if !h.Player.canAfford(cost) {
+		return fmt.Errorf("%s can't afford %v for a %s", h.Owner, cost, structure.Name)
+	}
+	for _, tile := range structureTiles {
+		h.Jobs = append(h.Jobs, &BuildJob{X: tile.X, Y: tile.Y, TileType: tile.Type})
+	}
+	return nil
+}
+
+func (h *Hive) canAfford(tileType int) bool {
+	return h.Player.canAfford(nestTileCosts[tileType])
+}
+
+// When the job queue runs dry the hive plans the next ring of nest tiles
+func (h *Hive) planNestExpansion() {
+	if len(h.Jobs) > 0 || h.NestRadius >= maxNestRadius {
+		return
+	}
+	h.NestRadius++
+	h.claimTerritory()
+	r := h.NestRadius
+	for i := h.X - r; i <= h.X+r; i++ {
+		for j := h.Y - r; j <= h.Y+r; j++ {
+			if i != h.X-r && i != h.X+r && j != h.Y-r && j != h.Y+r {
+				continue
+			}
+			if i < 0 || i >= tilesWide || j < 0 || j >= tilesHigh {
+				continue
+			}
+			if isBuildableTile(tiles[i][j].Type) && chunkOwner(i, j) == h.Owner {
+				h.Jobs = append(h.Jobs, &BuildJob{X: i, Y: j, TileType: nest})
+			}
+		}
+	}
+}
+
+// Hands unassigned jobs to idle drones, as long as the hive can pay for them
+func (h *Hive) assignJobs() {
+	for _, drone := range h.Drones {
+		if drone.State != droneIdle || drone.Task != nil || drone.Bidding != nil {
+			continue
+		}
+		for _, job := range h.Jobs {
+			if job.Drone == nil && h.canAfford(job.TileType) {
+				job.Drone = drone
+				drone.Job = job
+				drone.State = droneFetching
+				break
+			}
+		}
+	}
+}
+
+// Drones with nothing else to do go out and gather resources for the player
+func (h *Hive) assignHarvests() {
+	for _, drone := range h.Drones {
+		if drone.State != droneIdle || drone.Task != nil || drone.Bidding != nil {
+			continue
+		}
+		x, y, ok := h.findHarvestSite()
+		if !ok {
+			return
+		}
+		drone.TargetX, drone.TargetY = x, y
+		drone.State = droneHarvesting
+		drone.Progress = 0
+	}
+}
+
+// Returns where a drone should stand to harvest a tile, which is next to it for impassable tiles
+func harvestStandingTile(x, y int) (int, int) {
+	if tileMoveCost(tiles[x][y].Type) >= 0 {
+		return x, y
+	}
+	for i := x - 1; i <= x+1; i++ {
+		for j := y - 1; j <= y+1; j++ {
+			if i >= 0 && i < tilesWide && j >= 0 && j < tilesHigh && tileMoveCost(tiles[i][j].Type) >= 0 {
+				return i, j
+			}
+		}
+	}
+	return x, y
+}
+
+func (h *Hive) removeJob(job *BuildJob) {
+	for i, j := range h.Jobs {
+		if j == job {
+			h.Jobs = append(h.Jobs[:i], h.Jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Drones heading for a shared destination like the hive follow its flow field,
+// falling back to their own path when they are outside of it
+func (d *Drone) followField(x, y int) bool {
+	field := pathCache.FlowField(pathfinding.Point{X: x, Y: y}, flowFieldRadius)
+	next, ok := field.Next(pathfinding.Point{X: d.X, Y: d.Y})
+	if !ok {
+		return d.followPath(x, y)
+	}
+	d.X, d.Y = next.X, next.Y
+	return true
+}
+
+// Moves the drone one tile along its A* path, returning false if (x, y) can't be reached
+func (d *Drone) followPath(x, y int) bool {
+	if d.X == x && d.Y == y {
+		return true
+	}
+	goal := pathfinding.Point{X: x, Y: y}
+	if d.Path == nil || !d.Path.Valid() || d.Path.Done() || d.Path.Goal() != goal {
+		d.Path = pathCache.Path(pathfinding.Point{X: d.X, Y: d.Y}, goal)
+	}
+	next, ok := d.Path.Next()
+	if !ok {
+		return false
+	}
+	d.X, d.Y = next.X, next.Y
+	return true
+}
+
+// Moves the drone one tile and advances its job
+func (d *Drone) step() {
+	switch d.State {
+	case droneFetching:
+		// Walk back to the hive to pick up the materials for the job
+		if !d.followField(d.Hive.X, d.Hive.Y) {
+			// The hive can't be reached, so leave the job for another drone
+			d.Job.Drone = nil
+			d.Job = nil
+			d.State = droneIdle
+			return
+		}
+		if d.X == d.Hive.X && d.Y == d.Hive.Y {
+			if err := d.Hive.Player.spend(nestTileCosts[d.Job.TileType]); err != nil {
+				d.Job.Drone = nil
+				d.Job = nil
+				d.State = droneIdle
+				return
+			}
+			d.State = droneDelivering
+		}
+	case droneDelivering:
+		if !d.followPath(d.Job.X, d.Job.Y) {
+			// The site can't be reached, so return the materials and drop the job
+			d.Hive.Player.add(nestTileCosts[d.Job.TileType])
+			d.Hive.removeJob(d.Job)
+			d.Job = nil
+			d.State = droneIdle
+			return
+		}
+		if d.X == d.Job.X && d.Y == d.Job.Y {
+			d.State = droneBuilding
+			d.Progress = 0
+		}
+	case droneBuilding:
+		d.Progress++
+		if d.Progress >= buildTicks {
+			// The tile may have flooded since the job was queued, in which case the materials are lost
+			if canBuildOn(d.Job.TileType, tiles[d.X][d.Y].Type) {
+				setTileType(d.X, d.Y, d.Job.TileType)
+			}
+			d.Hive.removeJob(d.Job)
+			d.Job = nil
+			d.State = droneIdle
+		}
+	case droneHarvesting:
+		standX, standY := harvestStandingTile(d.TargetX, d.TargetY)
+		if d.X != standX || d.Y != standY {
+			if !d.followPath(standX, standY) {
+				d.State = droneIdle
+			}
+			return
+		}
+		d.Progress++
+		if d.Progress >= harvestTicks {
+			d.Cargo = harvestTile(d.TargetX, d.TargetY)
+			d.State = droneReturning
+		}
+	case droneReturning:
+		d.followField(d.Hive.X, d.Hive.Y)
+		if d.X == d.Hive.X && d.Y == d.Hive.Y {
+			d.Hive.Player.add(d.Cargo)
+			d.Cargo = nil
+			d.State = droneIdle
+		}
+	}
+}
+
+func simulateHives() {
+	for _, hive := range hives {
+		hive.planNestExpansion()
+		hive.allocateTasks()
+		hive.assignJobs()
+		hive.assignHarvests()
+		for _, drone := range hive.Drones {
+			if drone.Task == nil {
+				drone.step()
+			}
+		}
+		// Tasks remove themselves from the hive when they finish
+		tasks := append([]*Task(nil), hive.Tasks...)
+		for _, task := range tasks {
+			task.step()
+		}
+	}
+}
+
+// Simplified hive and drone state for the clients
+// The inventory is copied as the states are marshalled after worldLock is released
+func hiveStates() []protocol.HiveState {
+	states := make([]protocol.HiveState, 0, len(hives))
+	for _, hive := range hives {
+		inventory := make(map[string]int, len(hive.Player.Inventory))
+		for resource, amount := range hive.Player.Inventory {
+			inventory[resource] = amount
+		}
+		states = append(states, protocol.HiveState{
+			ID:        hive.ID,
+			Owner:     hive.Owner,
+			X:         hive.X,
+			Y:         hive.Y,
+			Inventory: inventory,
+			Jobs:      len(hive.Jobs),
+			Tasks:     len(hive.Tasks),
+		})
+	}
+	return states
+}
+
+func droneStates() []protocol.DroneState {
+	states := []protocol.DroneState{}
+	for _, hive := range hives {
+		for _, drone := range hive.Drones {
+			task := 0
+			if drone.Task != nil {
+				task = drone.Task.ID
+			}
+			states = append(states, protocol.DroneState{
+				ID:           drone.ID,
+				Hive:         hive.ID,
+				X:            drone.X,
+				Y:            drone.Y,
+				State:        drone.State,
+				Task:         task,
+				Capabilities: drone.Capabilities,
+			})
+		}
+	}
+	return states
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+
+	"growth-protocol"
+)
+
+func TestAddHiveBuildsPlatform(t *testing.T) {
+	setUpWorld(t)
+	player := &Player{Name: "ada", Inventory: map[string]int{}}
+	hive, err := addHive(player, 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tiles[10][10].Type != hiveCore || tiles[7][10].Type != concrete || tiles[7][7].Type != grass {
+		t.Errorf("platform tiles are %d, %d and %d", tiles[10][10].Type, tiles[7][10].Type, tiles[7][7].Type)
+	}
+	if len(hive.Drones) != startingDrones || hivesByOwner["ada"] != hive {
+		t.Error("the hive wasn't registered with its drones")
+	}
+}
+
+func TestAddHiveChecksWholePlatform(t *testing.T) {
+	tests := []struct {
+		name   string
+		x      int
+		y      int
+		ground int
+	}{
+		{"water under the edge", 13, 10, shallowWater},
+		{"deep water under the pad", 12, 8, deepWater},
+		{"nest under the center", 10, 10, nest},
+	}
+	for _, test := range tests {
+		setUpWorld(t)
+		setTileType(test.x, test.y, test.ground)
+		if _, err := addHive(&Player{Name: "ada", Inventory: map[string]int{}}, 10, 10); err == nil {
+			t.Errorf("%s: placed a hive", test.name)
+			continue
+		}
+		if len(hives) != 0 || tiles[10][10].Type == hiveCore {
+			t.Errorf("%s: a rejected hive changed the world", test.name)
+		}
+	}
+
+	// The corners missing from the platform don't matter
+	setUpWorld(t)
+	setTileType(7, 7, shallowWater)
+	if _, err := addHive(&Player{Name: "ada", Inventory: map[string]int{}}, 10, 10); err != nil {
+		t.Errorf("hive over water outside the platform: %v", err)
+	}
+}
+
+// A drone cut off from its hive gives its job up for another drone
+func TestStrandedDroneDropsJob(t *testing.T) {
+	setUpWorld(t)
+	hive, err := addHive(&Player{Name: "ada", Inventory: map[string]int{}}, 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 38; i <= 42; i++ {
+		for j := 38; j <= 42; j++ {
+			if i == 38 || i == 42 || j == 38 || j == 42 {
+				setTileType(i, j, deepWater)
+			}
+		}
+	}
+	job := &BuildJob{X: 20, Y: 10, TileType: concrete}
+	hive.Jobs = append(hive.Jobs, job)
+	drone := hive.Drones[0]
+	drone.X, drone.Y = 40, 40
+	drone.Job, job.Drone, drone.State = job, drone, droneFetching
+
+	drone.step()
+	if drone.State != droneIdle || drone.Job != nil || job.Drone != nil {
+		t.Errorf("stranded drone is in state %d with job %v", drone.State, drone.Job)
+	}
+	if len(hive.Jobs) != 1 {
+		t.Error("the job was dropped from the hive")
+	}
+}
+
+// A structure queues a job for each of its tiles, or none at all if any of
+// them can't be built or the player can't pay for the lot
+func TestPlaceStructure(t *testing.T) {
+	setUpWorld(t)
+	player := &Player{Name: "ada", Inventory: map[string]int{resourceStone: 1000}}
+	hive, err := addHive(player, 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	road, _ := protocol.FindStructure(protocol.StructureRoad)
+	if err := hive.placeStructure(road, 20, 10, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(hive.Jobs) != 5 || hive.Jobs[0].X != 20 || hive.Jobs[0].Y != 8 || hive.Jobs[4].Y != 12 {
+		t.Fatalf("a road turned a quarter queued %d jobs from (%d, %d)", len(hive.Jobs), hive.Jobs[0].X, hive.Jobs[0].Y)
+	}
+
+	setTileType(24, 12, deepWater)
+	if hive.placeStructure(road, 24, 10, 1) == nil {
+		t.Error("placed a road over water")
+	}
+	player.Inventory[resourceStone] = 0
+	if hive.placeStructure(road, 26, 10, 1) == nil {
+		t.Error("placed a road nobody paid for")
+	}
+	if len(hive.Jobs) != 5 {
+		t.Errorf("rejected structures queued %d jobs", len(hive.Jobs)-5)
+	}
+}
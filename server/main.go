@@ -1,60 +1,169 @@
 package main
 
 import (
-	"encoding/json"
+	"flag"
 	"fmt"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"growth-protocol"
 )
 
 const (
-	tilesWide = 80 * 30
-	tilesHigh = 45 * 30
+	tilesWide = protocol.TilesWide
+	tilesHigh = protocol.TilesHigh
 )
 
 var interval float64 = 0.125
 
+// How often tiles are broadcast, independent of the simulation tick rate
 var updateInterval = time.Duration(interval * float64(time.Second))
-var tiles [tilesWide][tilesHigh]Tile // 80x45 grid of tiles
+var tiles [tilesWide][tilesHigh]Tile // the whole map, indexed [x][y]
+var worldLock sync.Mutex             // guards tiles, hives and drones
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all connections
-	},
-}
+// The default origin check lets native clients in, as they send no Origin
+// header, but turns away web pages on other hosts
+var upgrader = websocket.Upgrader{}
 
-// Sends tile updates to a connected client
-func sendTileUpdates(conn *websocket.Conn) {
+// Sends tile updates to a connected client, along with their inventory whenever it changes.
+// Also pings the client so that dead connections time out.
+func sendTileUpdates(client *Client) {
 	ticker := time.NewTicker(updateInterval)
 	defer ticker.Stop()
+	pingTicker := time.NewTicker(pingPeriod)
+	defer pingTicker.Stop()
+	sentInventoryVersion := -1
+	sentTerritoryVersion := -1
+	sentStatsVersion := -1
+	var sentPresence *protocol.PresenceMessage
+	// The hash of each chunk as it was last sent, so only changed chunks are sent again
+	var sentHashes [protocol.HashChunks]uint64
+	var sent [protocol.HashChunks]bool
 	for {
 		select {
+		case <-pingTicker.C:
+			err := client.ping()
+			if err != nil {
+				fmt.Println("Ping error:", err)
+				return
+			}
 		case <-ticker.C:
-			// Convert the tiles to a JSON object with type="tiles"
-			simplifiedTiles := make([][]int, tilesWide)
-			for i := 0; i < tilesWide; i++ {
-				simplifiedTiles[i] = make([]int, tilesHigh)
-				for j := 0; j < tilesHigh; j++ {
-					simplifiedTiles[i][j] = tiles[i][j].Type
+			// Collect the chunks that changed since the last frame, and the hashes the client checks its tiles against
+			worldLock.Lock()
+			for _, index := range client.resync {
+				sent[index] = false
+			}
+			client.resync = nil
+			chunks := []protocol.TileChunk{}
+			shortHashes := make([]uint32, protocol.HashChunks)
+			for index, hash := range chunkHashes {
+				if !sent[index] || sentHashes[index] != hash {
+					chunks = append(chunks, chunkTiles(index))
+					sentHashes[index] = hash
+					sent[index] = true
+				}
+				shortHashes[index] = protocol.ShortHash(hash)
+			}
+			hash := tilesHash()
+			hiveList := hiveStates()
+			droneList := droneStates()
+			taskList := taskStates()
+			clock := simClock.state()
+			dayTime := worldTime.state()
+			weatherState := weather.state()
+			var overlays []protocol.FieldOverlay
+			animalList := []protocol.AnimalState{}
+			if client.Session != nil {
+				animalList = animalStates(client.Session.getViewport())
+				if fields := client.Session.getOverlays(); len(fields) > 0 {
+					overlays = fieldOverlays(fields, client.Session.getViewport())
 				}
 			}
+			var inventory *protocol.InventoryMessage
+			if client.Player != nil && client.Player.InventoryVersion != sentInventoryVersion {
+				inventory = client.Player.inventoryMessage()
+				sentInventoryVersion = client.Player.InventoryVersion
+			}
+			var territory *protocol.TerritoryMessage
+			if territoryVersion != sentTerritoryVersion {
+				territory = territoryMessage()
+				sentTerritoryVersion = territoryVersion
+			}
+			var stats *protocol.StatsMessage
+			if statsVersion != sentStatsVersion {
+				stats = faunaStats
+				sentStatsVersion = statsVersion
+			}
+			presence := presenceMessage(client)
+			if samePresence(presence, sentPresence) {
+				presence = nil
+			} else {
+				sentPresence = presence
+			}
+			worldLock.Unlock()
 
 			// Send the JSON to the client
-			tilesJson, err := json.Marshal(map[string]interface{}{"type": "tiles", "tiles": simplifiedTiles})
+			tilesJson, err := protocol.Encode(&protocol.TilesMessage{
+				Envelope:    protocol.Envelope{Type: protocol.TypeTiles},
+				Clock:       clock,
+				Time:        dayTime,
+				Weather:     weatherState,
+				Overlays:    overlays,
+				Chunks:      chunks,
+				Hash:        hash,
+				ChunkHashes: shortHashes,
+				Hives:       hiveList,
+				Drones:      droneList,
+				Tasks:       taskList,
+				Animals:     animalList,
+			})
 			if err != nil {
 				fmt.Println("JSON marshal error:", err)
 				return
 			}
 
 			// Send the JSON to the client
-			err = conn.WriteMessage(websocket.TextMessage, tilesJson)
+			err = client.sendRaw(tilesJson)
 			if err != nil {
 				fmt.Println("Write error:", err)
 				return
 			}
 
+			if inventory != nil {
+				err = client.send(inventory)
+				if err != nil {
+					fmt.Println("Write error:", err)
+					return
+				}
+			}
+
+			if territory != nil {
+				err = client.send(territory)
+				if err != nil {
+					fmt.Println("Write error:", err)
+					return
+				}
+			}
+
+			if stats != nil {
+				err = client.send(stats)
+				if err != nil {
+					fmt.Println("Write error:", err)
+					return
+				}
+			}
+
+			if presence != nil {
+				err = client.send(presence)
+				if err != nil {
+					fmt.Println("Write error:", err)
+					return
+				}
+			}
+
 			// fmt.Println("Sent tiles JSON to client")
 		}
 	}
@@ -72,7 +181,15 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("Client connected:", conn.RemoteAddr())
 
 	// Start a goroutine to send tile updates to the client
-	go sendTileUpdates(conn)
+	client := NewClient(conn)
+	registerClient(client)
+	defer unregisterClient(client)
+	err = client.send(chatHistoryMessage())
+	if err != nil {
+		fmt.Println("Write error:", err)
+		return
+	}
+	go sendTileUpdates(client)
 
 	for {
 		// Read message from client
@@ -82,48 +199,11 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
-		// Handle client message (if necessary)
-		fmt.Printf("Received from client: %s\n", message)
-		// parse a JSON message from the client
-		var msg map[string]interface{}
-		err = json.Unmarshal(message, &msg)
+		err = dispatch(client, message)
 		if err != nil {
-			fmt.Println("JSON unmarshal error:", err)
-			continue
-		}
-
-		if msg["type"] == "login" {
-			username, ok := msg["username"].(string)
-			if !ok {
-				fmt.Println("Invalid username format")
-				continue
-			}
-			fmt.Println("Received login message from client:", username)
-		}
-
-		if msg["type"] == "updateTile" {
-			x, ok := msg["x"].(float64)
-			if !ok {
-				fmt.Println("Invalid x format")
-				continue
-			}
-			y, ok := msg["y"].(float64)
-			if !ok {
-				fmt.Println("Invalid y format")
-			}
-			tile, ok := msg["value"].(float64)
-			if !ok {
-				fmt.Println("Invalid value format")
-			}
-			if x < 0 || x >= tilesWide || y < 0 || y >= tilesHigh {
-				fmt.Println("Invalid tile coordinates")
-				continue
-			}
-			tiles[int(x)][int(y)].Type = int(tile)
-		}
-
-		if msg["type"] == "resetTiles" {
-			resetSimulation()
+			fmt.Println("Disconnecting client:", conn.RemoteAddr(), err)
+			client.disconnect(err.Error())
+			break
 		}
 	}
 
@@ -131,12 +211,51 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	replayPath := flag.String("replay", "", "play back a replay log instead of generating a world")
+	serveReplay := flag.Bool("serve", false, "with -replay, stream the replay to clients rather than checking it and exiting")
+	admin := flag.String("admin", "", "make this user an admin, registering them with a random password if they're new")
+	flag.Parse()
+	if *replayPath != "" && !*serveReplay {
+		err := runReplay(*replayPath)
+		if err != nil {
+			fmt.Println("Replay failed:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	err := loadUsers()
+	if err != nil {
+		fmt.Println("Error loading users:", err)
+		return
+	}
+	if *admin != "" {
+		err = bootstrapAdmin(*admin)
+		if err != nil {
+			fmt.Println("Error making admin:", err)
+			return
+		}
+	}
+	go runSessionSweeper()
 	http.HandleFunc("/ws", wsHandler)
-	go resetSimulation()
-	// go runSimulation()
+	if *replayPath != "" {
+		playback, err = loadReplay(*replayPath)
+		if err != nil {
+			fmt.Println("Error loading replay:", err)
+			return
+		}
+		worldLock.Lock()
+		playback.start()
+		worldLock.Unlock()
+		go simClock.run(playback.streamStep, nil)
+	} else {
+		// The world is generated before the clock starts so the first tick has one to step
+		resetSimulation()
+		go simClock.run(stepSimulation, nil)
+	}
 
 	fmt.Println("WebSocket server starting on :8152")
-	err := http.ListenAndServe(":8152", nil)
+	err = http.ListenAndServe(":8152", nil)
 	if err != nil {
 		fmt.Println("ListenAndServe error:", err)
 	}
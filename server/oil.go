@@ -0,0 +1,113 @@
+// oil.go
+package main
+
+import (
+	"math"
+)
+
+// Oilspouts slowly fill with oil. Drones can carry it back to their hive, or
+// a pump built on the spout sends it straight to whoever owns the territory.
+// A full spout overflows, and the spilled oil spreads as a slick that kills
+// vegetation until it dissipates. Slicks spread much faster over water.
+const (
+	oilProductionRate = 0.05 // per tick
+	oilCapacity       = 20.0
+	oilHarvestAmount  = 2
+	pumpRate          = 0.2  // oil pumped per tick while the spout has any
+	slickPerOil       = 0.25 // pollution added to the spout's tile for each unit spilled
+
+	pollutionSpreadLand  = 0.02 // fraction of the difference that spreads to a neighbour per tick
+	pollutionSpreadWater = 0.2
+	pollutionDissipation = 0.002
+	minPollution         = 0.001
+	pollutionKillRate    = 0.05 // vegetation lost per tick under a full slick
+)
+
+type Oilspout struct {
+	X      int
+	Y      int
+	Stored float64
+	pumped float64 // pumped oil not yet added to an inventory
+}
+
+// Indexed by position. Guarded by worldLock.
+var oilspouts = make(map[[2]int]*Oilspout)
+
+func addOilspout(x, y int, stored float64) {
+	retypeTile(x, y, oilspout)
+	oilspouts[[2]int{x, y}] = &Oilspout{X: x, Y: y, Stored: stored}
+}
+
+// Fills the spouts, runs the pumps and spills anything over capacity
+func simulateOil() {
+	for _, spout := range oilspouts {
+		spout.Stored += oilProductionRate
+		if tiles[spout.X][spout.Y].Type == pump {
+			spout.runPump()
+		}
+		if spout.Stored > oilCapacity {
+			tile := &tiles[spout.X][spout.Y]
+			tile.Pollution = math.Min(tile.Pollution+(spout.Stored-oilCapacity)*slickPerOil, 1)
+			spout.Stored = oilCapacity
+		}
+	}
+}
+
+// Pumps pay the owner of the territory they stand on in whole units of oil
+func (s *Oilspout) runPump() {
+	amount := math.Min(pumpRate, s.Stored)
+	s.Stored -= amount
+	s.pumped += amount
+	player, ok := players[chunkOwner(s.X, s.Y)]
+	if !ok || s.pumped < 1 {
+		return
+	}
+	whole := math.Floor(s.pumped)
+	s.pumped -= whole
+	player.add(map[string]int{resourceOil: int(whole)})
+}
+
+// Takes up to amount whole units of oil from the spout at (x, y)
+func extractOil(x, y, amount int) int {
+	spout, ok := oilspouts[[2]int{x, y}]
+	if !ok {
+		return 0
+	}
+	extracted := min(amount, int(spout.Stored))
+	spout.Stored -= float64(extracted)
+	return extracted
+}
+
+func hasOil(x, y int) bool {
+	spout, ok := oilspouts[[2]int{x, y}]
+	return ok && spout.Stored >= 1
+}
+
+// Spreads the tile's pollution to its neighbours to the right and below,
+// like flowWater, and lets some of it dissipate
+func spreadPollution(i, j int) {
+	tile := &tiles[i][j]
+	if i+1 < tilesWide {
+		spread(tile, &tiles[i+1][j])
+	}
+	if j+1 < tilesHigh {
+		spread(tile, &tiles[i][j+1])
+	}
+	tile.Pollution -= tile.Pollution * pollutionDissipation
+	if tile.Pollution < minPollution {
+		tile.Pollution = 0
+	}
+}
+
+func spread(a, b *Tile) {
+	if a.Pollution == 0 && b.Pollution == 0 {
+		return
+	}
+	rate := pollutionSpreadLand
+	if a.Water >= shallowWaterDepth && b.Water >= shallowWaterDepth {
+		rate = pollutionSpreadWater
+	}
+	amount := (a.Pollution - b.Pollution) * rate
+	a.Pollution -= amount
+	b.Pollution += amount
+}
@@ -0,0 +1,130 @@
+// overlays.go
+package main
+
+import (
+	"math"
+
+	"growth-protocol"
+)
+
+// Tiles further than this from water have a water proximity of 0
+const maxWaterDistance = 16
+
+// An overlayField quantizes one field for a rectangle of tiles, indexed [x*height+y]
+type overlayField func(x0, y0, width, height int) []byte
+
+var overlayFields = map[string]overlayField{
+	protocol.FieldAltitude:       tileFieldOverlay(func(tile *Tile) float64 { return tile.Altitude }),
+	protocol.FieldNutrient:       tileFieldOverlay(func(tile *Tile) float64 { return tile.Nutrient }),
+	protocol.FieldMoisture:       tileFieldOverlay(func(tile *Tile) float64 { return tile.Moisture }),
+	protocol.FieldVegetation:     tileFieldOverlay(func(tile *Tile) float64 { return tile.Vegetation }),
+	protocol.FieldWaterProximity: waterProximityOverlay,
+	protocol.FieldPollution:      tileFieldOverlay(func(tile *Tile) float64 { return tile.Pollution }),
+}
+
+// Scales a value from 0 to 1 into a byte
+func quantize(value float64) byte {
+	return byte(math.Round(math.Min(math.Max(value, 0), 1) * 255))
+}
+
+// Overlays a field stored on each tile that ranges from 0 to 1
+func tileFieldOverlay(value func(tile *Tile) float64) overlayField {
+	return func(x0, y0, width, height int) []byte {
+		values := make([]byte, width*height)
+		for x := 0; x < width; x++ {
+			for y := 0; y < height; y++ {
+				values[x*height+y] = quantize(value(&tiles[x0+x][y0+y]))
+			}
+		}
+		return values
+	}
+}
+
+func isWaterTile(tileType int) bool {
+	return tileType == deepWater || tileType == shallowWater || tileType == puddle
+}
+
+// Measures the distance to the nearest water with a two pass chamfer distance
+// transform, over the rectangle plus a margin so water just outside it counts
+func waterProximityOverlay(x0, y0, width, height int) []byte {
+	// Orthogonal steps cost 3 and diagonal steps 4, roughly 3 per tile
+	const straight, diagonal = 3, 4
+	const far = math.MaxInt32 / 2
+	left := max(x0-maxWaterDistance, 0)
+	top := max(y0-maxWaterDistance, 0)
+	right := min(x0+width+maxWaterDistance, tilesWide)
+	bottom := min(y0+height+maxWaterDistance, tilesHigh)
+	w, h := right-left, bottom-top
+	distances := make([]int, w*h)
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			if isWaterTile(tiles[left+x][top+y].Type) {
+				distances[x*h+y] = 0
+			} else {
+				distances[x*h+y] = far
+			}
+		}
+	}
+	relax := func(x, y, dx, dy, cost int) {
+		nx, ny := x+dx, y+dy
+		if nx < 0 || nx >= w || ny < 0 || ny >= h {
+			return
+		}
+		if d := distances[nx*h+ny] + cost; d < distances[x*h+y] {
+			distances[x*h+y] = d
+		}
+	}
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			relax(x, y, -1, 0, straight)
+			relax(x, y, 0, -1, straight)
+			relax(x, y, -1, -1, diagonal)
+			relax(x, y, -1, 1, diagonal)
+		}
+	}
+	for x := w - 1; x >= 0; x-- {
+		for y := h - 1; y >= 0; y-- {
+			relax(x, y, 1, 0, straight)
+			relax(x, y, 0, 1, straight)
+			relax(x, y, 1, 1, diagonal)
+			relax(x, y, 1, -1, diagonal)
+		}
+	}
+
+	values := make([]byte, width*height)
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			distance := float64(distances[(x0-left+x)*h+(y0-top+y)]) / straight
+			values[x*height+y] = quantize(1 - distance/maxWaterDistance)
+		}
+	}
+	return values
+}
+
+// The fields a client asked for over its viewport, capped at
+// protocol.MaxOverlaySize tiles each way. Must be called with worldLock held.
+func fieldOverlays(fields []string, viewport protocol.Viewport) []protocol.FieldOverlay {
+	x0 := max(int(math.Floor(viewport.X)), 0)
+	y0 := max(int(math.Floor(viewport.Y)), 0)
+	width := min(int(math.Ceil(viewport.X+viewport.Width))+1, tilesWide, x0+protocol.MaxOverlaySize) - x0
+	height := min(int(math.Ceil(viewport.Y+viewport.Height))+1, tilesHigh, y0+protocol.MaxOverlaySize) - y0
+	if width <= 0 || height <= 0 {
+		return nil
+	}
+	overlays := make([]protocol.FieldOverlay, 0, len(fields))
+	for _, field := range fields {
+		overlay, ok := overlayFields[field]
+		if !ok {
+			continue
+		}
+		overlays = append(overlays, protocol.FieldOverlay{
+			Field:  field,
+			X:      x0,
+			Y:      y0,
+			Width:  width,
+			Height: height,
+			Values: overlay(x0, y0, width, height),
+		})
+	}
+	return overlays
+}
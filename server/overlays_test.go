@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"growth-protocol"
+)
+
+func TestFieldOverlaysCoverViewport(t *testing.T) {
+	setUpWorld(t)
+	tiles[4][5].Vegetation = 0.5
+	overlays := fieldOverlays([]string{protocol.FieldVegetation, "heat"}, protocol.Viewport{X: 2.5, Y: 3, Width: 10, Height: 5})
+	if len(overlays) != 1 {
+		t.Fatalf("got %d overlays", len(overlays))
+	}
+	overlay := overlays[0]
+	if overlay.X != 2 || overlay.Y != 3 || overlay.Width != 12 || overlay.Height != 6 || len(overlay.Values) != 12*6 {
+		t.Fatalf("overlay covers %dx%d tiles from (%d, %d) with %d values", overlay.Width, overlay.Height, overlay.X, overlay.Y, len(overlay.Values))
+	}
+	if overlay.Values[0] != 255 || overlay.Values[2*overlay.Height+2] != 128 {
+		t.Errorf("vegetation overlay starts %v", overlay.Values[:overlay.Height*3])
+	}
+
+	overlays = fieldOverlays([]string{protocol.FieldAltitude}, protocol.Viewport{X: -5, Y: -5, Width: 1e6, Height: 1e6})
+	if overlays[0].X != 0 || overlays[0].Width != protocol.MaxOverlaySize || overlays[0].Height != protocol.MaxOverlaySize {
+		t.Errorf("huge viewport gave a %dx%d overlay from x %d", overlays[0].Width, overlays[0].Height, overlays[0].X)
+	}
+	if fieldOverlays([]string{protocol.FieldAltitude}, protocol.Viewport{X: tilesWide + 10, Y: 0, Width: 10, Height: 10}) != nil {
+		t.Error("sent an overlay for a viewport off the map")
+	}
+}
+
+// Water proximity falls off with distance from water, including water just
+// outside the overlay
+func TestWaterProximityOverlay(t *testing.T) {
+	setUpWorld(t)
+	tiles[50][50].Type = shallowWater
+	values := waterProximityOverlay(51, 50, maxWaterDistance+1, 1)
+	if values[0] != quantize(1-1.0/maxWaterDistance) {
+		t.Errorf("next to water the proximity is %d", values[0])
+	}
+	for i := 1; i < len(values); i++ {
+		if values[i] >= values[i-1] && values[i-1] != 0 {
+			t.Errorf("proximity rose from %d to %d moving away from water", values[i-1], values[i])
+		}
+	}
+	if values[len(values)-1] != 0 {
+		t.Errorf("%d tiles from water the proximity is %d", maxWaterDistance+1, values[len(values)-1])
+	}
+	if values := waterProximityOverlay(50, 50, 1, 1); values[0] != 255 {
+		t.Errorf("on water the proximity is %d", values[0])
+	}
+}
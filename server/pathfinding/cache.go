@@ -0,0 +1,126 @@
+package pathfinding
+
+// Path is a route handed out by a Cache. It stops being valid when a tile
+// along the remaining route changes, and the agent following it should ask for a new one.
+type Path struct {
+	Points []Point
+	Found  bool
+	goal   Point
+	step   int
+	valid  bool
+}
+
+// Goal returns the tile the path was requested for
+func (p *Path) Goal() Point {
+	return p.goal
+}
+
+// Valid reports whether the route is still up to date with the grid
+func (p *Path) Valid() bool {
+	return p.valid
+}
+
+// Done reports whether the whole route has been walked
+func (p *Path) Done() bool {
+	return !p.Found || p.step >= len(p.Points)-1
+}
+
+// Next advances along the route and returns the tile to step onto
+func (p *Path) Next() (Point, bool) {
+	if p.Done() {
+		return p.goal, false
+	}
+	p.step++
+	return p.Points[p.step], true
+}
+
+type fieldKey struct {
+	target Point
+	radius int
+}
+
+// Cache hands out paths and flow fields and invalidates them when tiles change
+type Cache struct {
+	finder *Pathfinder
+	paths  []*Path
+	fields map[fieldKey]*FlowField
+}
+
+func NewCache(finder *Pathfinder) *Cache {
+	return &Cache{finder: finder, fields: make(map[fieldKey]*FlowField)}
+}
+
+// Path finds a route with A* and keeps track of it so it can be invalidated
+func (c *Cache) Path(start, goal Point) *Path {
+	points, found := c.finder.FindPath(start, goal)
+	path := &Path{Points: points, Found: found, goal: goal, valid: true}
+
+	// Drop the paths that have been walked to the end while we're here
+	live := c.paths[:0]
+	for _, p := range c.paths {
+		if p.valid && !p.Done() {
+			live = append(live, p)
+		}
+	}
+	c.paths = append(live, path)
+	return path
+}
+
+// FlowField returns the cached field for the target, building it if needed
+func (c *Cache) FlowField(target Point, radius int) *FlowField {
+	key := fieldKey{target: target, radius: radius}
+	field, ok := c.fields[key]
+	if !ok {
+		field = c.finder.FlowField(target, radius)
+		c.fields[key] = field
+	}
+	return field
+}
+
+// Invalidate marks every path and flow field touching a changed tile as stale.
+// Unfound paths are always invalidated, as any change might open a route.
+func (c *Cache) Invalidate(changed []Point) {
+	if len(changed) == 0 {
+		return
+	}
+	changedSet := make(map[Point]struct{}, len(changed))
+	for _, pt := range changed {
+		changedSet[pt] = struct{}{}
+	}
+
+	live := c.paths[:0]
+	for _, path := range c.paths {
+		if path.valid && path.Found {
+			for _, pt := range path.Points[path.step:] {
+				if _, ok := changedSet[pt]; ok {
+					path.valid = false
+					break
+				}
+			}
+		} else {
+			path.valid = false
+		}
+		if path.valid {
+			live = append(live, path)
+		}
+	}
+	c.paths = live
+
+	for key, field := range c.fields {
+		for _, pt := range changed {
+			if field.Contains(pt) {
+				delete(c.fields, key)
+				break
+			}
+		}
+	}
+}
+
+// InvalidateAll drops every cached route, e.g. when the world is regenerated
+func (c *Cache) InvalidateAll() {
+	for _, path := range c.paths {
+		path.valid = false
+	}
+	c.paths = nil
+	c.fields = make(map[fieldKey]*FlowField)
+}
@@ -0,0 +1,362 @@
+// Package pathfinding finds routes across a weighted tile grid.
+// A* is used for single agents, and flow fields for many agents heading to the same target.
+package pathfinding
+
+import (
+	"math"
+)
+
+// Point is a tile coordinate on the grid
+type Point struct {
+	X int
+	Y int
+}
+
+// Impassable is the cost of a tile that can never be entered
+const Impassable = -1.0
+
+// Grid reports the cost of entering each tile. Negative costs are impassable.
+type Grid interface {
+	Size() (int, int)
+	Cost(x, y int) float64
+}
+
+// The first four neighbours are orthogonal, the rest are diagonal
+var neighbours = [8][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}, {1, 1}, {1, -1}, {-1, 1}, {-1, -1}}
+
+// reverse[k] is the neighbour direction that undoes direction k
+var reverse = [8]int{1, 0, 3, 2, 7, 6, 5, 4}
+
+// Pathfinder holds scratch buffers sized to the whole grid so that searches
+// don't allocate per node. It is not safe for concurrent use.
+type Pathfinder struct {
+	grid    Grid
+	width   int
+	height  int
+	minCost float64
+
+	// MaxNodes stops a search after expanding this many tiles, 0 means no limit
+	MaxNodes int
+
+	g      []float64
+	parent []int32
+	seen   []uint32
+	closed []uint32
+	gen    uint32
+	open   minHeap
+}
+
+// NewPathfinder creates a pathfinder for the grid. minCost is the cheapest
+// tile cost on the grid and keeps the A* heuristic admissible.
+func NewPathfinder(grid Grid, minCost float64) *Pathfinder {
+	width, height := grid.Size()
+	size := width * height
+	return &Pathfinder{
+		grid:    grid,
+		width:   width,
+		height:  height,
+		minCost: minCost,
+		g:       make([]float64, size),
+		parent:  make([]int32, size),
+		seen:    make([]uint32, size),
+		closed:  make([]uint32, size),
+	}
+}
+
+func (p *Pathfinder) inBounds(x, y int) bool {
+	return x >= 0 && x < p.width && y >= 0 && y < p.height
+}
+
+func (p *Pathfinder) index(x, y int) int32 {
+	return int32(y*p.width + x)
+}
+
+func (p *Pathfinder) point(i int32) Point {
+	return Point{X: int(i) % p.width, Y: int(i) / p.width}
+}
+
+// Starts a new search without clearing the scratch buffers
+func (p *Pathfinder) nextGeneration() {
+	p.gen++
+	if p.gen == 0 {
+		clear(p.seen)
+		clear(p.closed)
+		p.gen = 1
+	}
+	p.open.reset()
+}
+
+// Octile distance scaled by the cheapest tile
+func (p *Pathfinder) heuristic(x, y int, goal Point) float64 {
+	dx := math.Abs(float64(x - goal.X))
+	dy := math.Abs(float64(y - goal.Y))
+	return p.minCost * (math.Max(dx, dy) + (math.Sqrt2-1)*math.Min(dx, dy))
+}
+
+// Returns the cost of stepping from (x, y) in direction k, or a negative value
+// when the step is blocked. Diagonal steps may not cut impassable corners.
+func (p *Pathfinder) stepCost(x, y, k int) float64 {
+	nx, ny := x+neighbours[k][0], y+neighbours[k][1]
+	if !p.inBounds(nx, ny) {
+		return Impassable
+	}
+	cost := p.grid.Cost(nx, ny)
+	if cost < 0 {
+		return Impassable
+	}
+	if k >= 4 {
+		if p.grid.Cost(nx, y) < 0 || p.grid.Cost(x, ny) < 0 {
+			return Impassable
+		}
+		cost *= math.Sqrt2
+	}
+	return cost
+}
+
+// FindPath returns the cheapest route from start to goal, including both ends.
+// The bool is false when the goal can't be reached.
+func (p *Pathfinder) FindPath(start, goal Point) ([]Point, bool) {
+	if !p.inBounds(start.X, start.Y) || !p.inBounds(goal.X, goal.Y) || p.grid.Cost(goal.X, goal.Y) < 0 {
+		return nil, false
+	}
+	p.nextGeneration()
+	startIndex := p.index(start.X, start.Y)
+	goalIndex := p.index(goal.X, goal.Y)
+	p.g[startIndex] = 0
+	p.parent[startIndex] = -1
+	p.seen[startIndex] = p.gen
+	p.open.push(startIndex, p.heuristic(start.X, start.Y, goal))
+
+	expanded := 0
+	for p.open.len() > 0 {
+		current := p.open.pop()
+		if p.closed[current] == p.gen {
+			continue
+		}
+		p.closed[current] = p.gen
+		if current == goalIndex {
+			return p.reconstruct(current), true
+		}
+		expanded++
+		if p.MaxNodes > 0 && expanded > p.MaxNodes {
+			break
+		}
+
+		x, y := int(current)%p.width, int(current)/p.width
+		for k := range neighbours {
+			cost := p.stepCost(x, y, k)
+			if cost < 0 {
+				continue
+			}
+			nx, ny := x+neighbours[k][0], y+neighbours[k][1]
+			next := p.index(nx, ny)
+			if p.closed[next] == p.gen {
+				continue
+			}
+			g := p.g[current] + cost
+			if p.seen[next] != p.gen || g < p.g[next] {
+				p.seen[next] = p.gen
+				p.g[next] = g
+				p.parent[next] = current
+				p.open.push(next, g+p.heuristic(nx, ny, goal))
+			}
+		}
+	}
+	return nil, false
+}
+
+func (p *Pathfinder) reconstruct(end int32) []Point {
+	length := 0
+	for i := end; i != -1; i = p.parent[i] {
+		length++
+	}
+	path := make([]Point, length)
+	for i := end; i != -1; i = p.parent[i] {
+		length--
+		path[length] = p.point(i)
+	}
+	return path
+}
+
+// FlowField points every tile within radius of the target along its cheapest route there.
+// A radius of 0 covers the whole grid.
+type FlowField struct {
+	Target Point
+	minX   int
+	minY   int
+	maxX   int
+	maxY   int
+	width  int
+	next   []int32
+	dist   []float64
+}
+
+// FlowField runs Dijkstra outwards from the target
+func (p *Pathfinder) FlowField(target Point, radius int) *FlowField {
+	field := &FlowField{Target: target, minX: 0, minY: 0, maxX: p.width - 1, maxY: p.height - 1}
+	if radius > 0 {
+		field.minX = max(target.X-radius, 0)
+		field.minY = max(target.Y-radius, 0)
+		field.maxX = min(target.X+radius, p.width-1)
+		field.maxY = min(target.Y+radius, p.height-1)
+	}
+	field.width = field.maxX - field.minX + 1
+	size := field.width * (field.maxY - field.minY + 1)
+	field.next = make([]int32, size)
+	field.dist = make([]float64, size)
+	for i := range field.next {
+		field.next[i] = -1
+		field.dist[i] = math.Inf(1)
+	}
+	if !p.inBounds(target.X, target.Y) {
+		return field
+	}
+
+	p.nextGeneration()
+	targetIndex := p.index(target.X, target.Y)
+	p.g[targetIndex] = 0
+	p.parent[targetIndex] = -1
+	p.seen[targetIndex] = p.gen
+	p.open.push(targetIndex, 0)
+
+	for p.open.len() > 0 {
+		current := p.open.pop()
+		if p.closed[current] == p.gen {
+			continue
+		}
+		p.closed[current] = p.gen
+		x, y := int(current)%p.width, int(current)/p.width
+		local := field.local(x, y)
+		field.dist[local] = p.g[current]
+		field.next[local] = p.parent[current]
+
+		// Agents standing on an impassable tile may still leave it, but nobody can route through it
+		if current != targetIndex && p.grid.Cost(x, y) < 0 {
+			continue
+		}
+		for k := range neighbours {
+			nx, ny := x+neighbours[k][0], y+neighbours[k][1]
+			if !field.Contains(Point{X: nx, Y: ny}) {
+				continue
+			}
+			// The reverse step enters the current tile from its neighbour
+			cost := p.stepCost(nx, ny, reverse[k])
+			if cost < 0 {
+				continue
+			}
+			next := p.index(nx, ny)
+			if p.closed[next] == p.gen {
+				continue
+			}
+			g := p.g[current] + cost
+			if p.seen[next] != p.gen || g < p.g[next] {
+				p.seen[next] = p.gen
+				p.g[next] = g
+				p.parent[next] = current
+				p.open.push(next, g)
+			}
+		}
+	}
+	// The parents are stored as grid indices, so convert them once the search is done
+	return field.withGridWidth(p.width)
+}
+
+func (f *FlowField) local(x, y int) int {
+	return (y-f.minY)*f.width + (x - f.minX)
+}
+
+func (f *FlowField) withGridWidth(gridWidth int) *FlowField {
+	for i, next := range f.next {
+		if next < 0 {
+			continue
+		}
+		x, y := int(next)%gridWidth, int(next)/gridWidth
+		f.next[i] = int32(f.local(x, y))
+	}
+	return f
+}
+
+// Contains reports whether the point is inside the field's bounds
+func (f *FlowField) Contains(pt Point) bool {
+	return pt.X >= f.minX && pt.X <= f.maxX && pt.Y >= f.minY && pt.Y <= f.maxY
+}
+
+// Next returns the tile to step onto from pt. The bool is false when pt is
+// outside the field, can't reach the target, or is the target itself.
+func (f *FlowField) Next(pt Point) (Point, bool) {
+	if !f.Contains(pt) {
+		return pt, false
+	}
+	next := f.next[f.local(pt.X, pt.Y)]
+	if next < 0 {
+		return pt, false
+	}
+	return Point{X: int(next)%f.width + f.minX, Y: int(next)/f.width + f.minY}, true
+}
+
+// Cost returns the cost of reaching the target from pt
+func (f *FlowField) Cost(pt Point) float64 {
+	if !f.Contains(pt) {
+		return math.Inf(1)
+	}
+	return f.dist[f.local(pt.X, pt.Y)]
+}
+
+// minHeap is a binary heap of grid indices ordered by priority
+type minHeap struct {
+	items      []int32
+	priorities []float64
+}
+
+func (h *minHeap) reset() {
+	h.items = h.items[:0]
+	h.priorities = h.priorities[:0]
+}
+
+func (h *minHeap) len() int {
+	return len(h.items)
+}
+
+func (h *minHeap) push(item int32, priority float64) {
+	h.items = append(h.items, item)
+	h.priorities = append(h.priorities, priority)
+	i := len(h.items) - 1
+	for i > 0 {
+		parent := (i - 1) / 2
+		if h.priorities[parent] <= h.priorities[i] {
+			break
+		}
+		h.swap(i, parent)
+		i = parent
+	}
+}
+
+func (h *minHeap) pop() int32 {
+	top := h.items[0]
+	last := len(h.items) - 1
+	h.swap(0, last)
+	h.items = h.items[:last]
+	h.priorities = h.priorities[:last]
+	i := 0
+	for {
+		left, right := 2*i+1, 2*i+2
+		smallest := i
+		if left < last && h.priorities[left] < h.priorities[smallest] {
+			smallest = left
+		}
+		if right < last && h.priorities[right] < h.priorities[smallest] {
+			smallest = right
+		}
+		if smallest == i {
+			break
+		}
+		h.swap(i, smallest)
+		i = smallest
+	}
+	return top
+}
+
+func (h *minHeap) swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.priorities[i], h.priorities[j] = h.priorities[j], h.priorities[i]
+}
@@ -0,0 +1,320 @@
+package pathfinding
+
+import (
+	"math"
+	"testing"
+)
+
+// testGrid is a grid of costs, with every tile costing 1 unless set
+type testGrid struct {
+	width  int
+	height int
+	cost   []float64
+}
+
+func newTestGrid(width, height int) *testGrid {
+	g := &testGrid{width: width, height: height, cost: make([]float64, width*height)}
+	for i := range g.cost {
+		g.cost[i] = 1
+	}
+	return g
+}
+
+func (g *testGrid) Size() (int, int) {
+	return g.width, g.height
+}
+
+func (g *testGrid) Cost(x, y int) float64 {
+	return g.cost[y*g.width+x]
+}
+
+func (g *testGrid) set(x, y int, cost float64) {
+	g.cost[y*g.width+x] = cost
+}
+
+// Walls off a vertical line of tiles, leaving gaps at the given rows
+func (g *testGrid) wall(x int, gaps ...int) {
+	for y := 0; y < g.height; y++ {
+		g.set(x, y, Impassable)
+	}
+	for _, y := range gaps {
+		g.set(x, y, 1)
+	}
+}
+
+// Checks that the path is made of legal steps and returns what it costs
+func pathCost(t *testing.T, g *testGrid, path []Point) float64 {
+	t.Helper()
+	total := 0.0
+	for i := 1; i < len(path); i++ {
+		from, to := path[i-1], path[i]
+		dx, dy := to.X-from.X, to.Y-from.Y
+		if dx < -1 || dx > 1 || dy < -1 || dy > 1 || (dx == 0 && dy == 0) {
+			t.Fatalf("step %d from %v to %v isn't to a neighbour", i, from, to)
+		}
+		cost := g.Cost(to.X, to.Y)
+		if cost < 0 {
+			t.Fatalf("step %d enters impassable tile %v", i, to)
+		}
+		if dx != 0 && dy != 0 {
+			if g.Cost(to.X, from.Y) < 0 || g.Cost(from.X, to.Y) < 0 {
+				t.Fatalf("step %d from %v to %v cuts an impassable corner", i, from, to)
+			}
+			cost *= math.Sqrt2
+		}
+		total += cost
+	}
+	return total
+}
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestFindPathOpenGrid(t *testing.T) {
+	g := newTestGrid(20, 10)
+	p := NewPathfinder(g, 1)
+	path, ok := p.FindPath(Point{1, 1}, Point{15, 4})
+	if !ok {
+		t.Fatal("no path across an open grid")
+	}
+	if path[0] != (Point{1, 1}) || path[len(path)-1] != (Point{15, 4}) {
+		t.Fatalf("path runs from %v to %v", path[0], path[len(path)-1])
+	}
+	// 3 diagonal steps and 11 straight ones
+	if cost, expected := pathCost(t, g, path), 11+3*math.Sqrt2; !closeTo(cost, expected) {
+		t.Errorf("path costs %v, expected %v", cost, expected)
+	}
+}
+
+func TestFindPathStartIsGoal(t *testing.T) {
+	p := NewPathfinder(newTestGrid(5, 5), 1)
+	path, ok := p.FindPath(Point{2, 2}, Point{2, 2})
+	if !ok || len(path) != 1 || path[0] != (Point{2, 2}) {
+		t.Errorf("FindPath to the start = %v, %v", path, ok)
+	}
+}
+
+func TestFindPathAroundWall(t *testing.T) {
+	g := newTestGrid(20, 10)
+	g.wall(10, 8)
+	p := NewPathfinder(g, 1)
+	path, ok := p.FindPath(Point{2, 1}, Point{18, 1})
+	if !ok {
+		t.Fatal("no path through the gap in the wall")
+	}
+	pathCost(t, g, path)
+	through := false
+	for _, pt := range path {
+		if pt == (Point{10, 8}) {
+			through = true
+		}
+	}
+	if !through {
+		t.Errorf("path %v doesn't go through the gap", path)
+	}
+}
+
+func TestFindPathAvoidsExpensiveTiles(t *testing.T) {
+	g := newTestGrid(9, 5)
+	for x := 1; x < 8; x++ {
+		g.set(x, 2, 10)
+	}
+	p := NewPathfinder(g, 1)
+	path, ok := p.FindPath(Point{0, 2}, Point{8, 2})
+	if !ok {
+		t.Fatal("no path")
+	}
+	for _, pt := range path[1 : len(path)-1] {
+		if g.Cost(pt.X, pt.Y) > 1 {
+			t.Fatalf("path %v crosses an expensive tile at %v", path, pt)
+		}
+	}
+}
+
+func TestFindPathDoesNotCutCorners(t *testing.T) {
+	g := newTestGrid(3, 3)
+	g.set(1, 0, Impassable)
+	g.set(0, 1, Impassable)
+	p := NewPathfinder(g, 1)
+	if path, ok := p.FindPath(Point{0, 0}, Point{1, 1}); ok {
+		t.Errorf("found path %v between two impassable corners", path)
+	}
+}
+
+func TestFindPathUnreachable(t *testing.T) {
+	g := newTestGrid(20, 10)
+	g.wall(10)
+	g.set(3, 3, Impassable)
+	p := NewPathfinder(g, 1)
+	tests := []struct {
+		name  string
+		start Point
+		goal  Point
+	}{
+		{"walled off", Point{2, 2}, Point{15, 5}},
+		{"impassable goal", Point{2, 2}, Point{3, 3}},
+		{"goal out of bounds", Point{2, 2}, Point{20, 5}},
+		{"start out of bounds", Point{-1, 2}, Point{5, 5}},
+	}
+	for _, test := range tests {
+		if path, ok := p.FindPath(test.start, test.goal); ok || path != nil {
+			t.Errorf("%s: FindPath = %v, %v", test.name, path, ok)
+		}
+	}
+	// The pathfinder is still usable after failed searches
+	if _, ok := p.FindPath(Point{2, 2}, Point{8, 8}); !ok {
+		t.Error("no path after failed searches")
+	}
+}
+
+func TestFindPathMaxNodes(t *testing.T) {
+	g := newTestGrid(50, 50)
+	g.wall(25)
+	p := NewPathfinder(g, 1)
+	p.MaxNodes = 100
+	if _, ok := p.FindPath(Point{0, 0}, Point{49, 49}); ok {
+		t.Error("found a path that needed more nodes than allowed")
+	}
+}
+
+func TestFlowFieldMatchesFindPath(t *testing.T) {
+	g := newTestGrid(30, 20)
+	g.wall(10, 3, 15)
+	g.wall(20, 10)
+	for x := 12; x < 18; x++ {
+		g.set(x, 7, 4)
+	}
+	p := NewPathfinder(g, 1)
+	target := Point{27, 12}
+	field := p.FlowField(target, 0)
+	for x := 0; x < g.width; x++ {
+		for y := 0; y < g.height; y++ {
+			start := Point{x, y}
+			if g.Cost(x, y) < 0 {
+				continue
+			}
+			path, ok := p.FindPath(start, target)
+			if !ok {
+				t.Fatalf("no path from %v", start)
+			}
+			if !closeTo(field.Cost(start), pathCost(t, g, path)) {
+				t.Fatalf("flow field cost from %v is %v, A* found %v", start, field.Cost(start), pathCost(t, g, path))
+			}
+
+			// Following the field reaches the target at the same cost
+			route := []Point{start}
+			for pt := start; pt != target; {
+				next, ok := field.Next(pt)
+				if !ok {
+					t.Fatalf("flow field stops at %v on the way from %v", pt, start)
+				}
+				route = append(route, next)
+				pt = next
+				if len(route) > g.width*g.height {
+					t.Fatalf("flow field loops from %v", start)
+				}
+			}
+			if !closeTo(pathCost(t, g, route), field.Cost(start)) {
+				t.Fatalf("following the field from %v costs %v, expected %v", start, pathCost(t, g, route), field.Cost(start))
+			}
+		}
+	}
+	if _, ok := field.Next(target); ok {
+		t.Error("the target points somewhere")
+	}
+}
+
+func TestFlowFieldUnreachable(t *testing.T) {
+	g := newTestGrid(20, 10)
+	g.wall(10)
+	p := NewPathfinder(g, 1)
+	field := p.FlowField(Point{15, 5}, 0)
+	if _, ok := field.Next(Point{2, 2}); ok {
+		t.Error("a tile behind the wall points somewhere")
+	}
+	if !math.IsInf(field.Cost(Point{2, 2}), 1) {
+		t.Errorf("cost behind the wall is %v", field.Cost(Point{2, 2}))
+	}
+	if _, ok := field.Next(Point{12, 2}); !ok {
+		t.Error("a reachable tile doesn't point anywhere")
+	}
+}
+
+func TestFlowFieldRadius(t *testing.T) {
+	g := newTestGrid(40, 40)
+	p := NewPathfinder(g, 1)
+	field := p.FlowField(Point{20, 20}, 5)
+	if !field.Contains(Point{15, 25}) || field.Contains(Point{14, 20}) || field.Contains(Point{20, 26}) {
+		t.Error("field bounds don't match its radius")
+	}
+	if _, ok := field.Next(Point{30, 30}); ok {
+		t.Error("a tile outside the field points somewhere")
+	}
+	if next, ok := field.Next(Point{15, 15}); !ok || next != (Point{16, 16}) {
+		t.Errorf("Next from the corner = %v, %v", next, ok)
+	}
+}
+
+// A full-size map with rough terrain: mostly cheap land, some expensive
+// patches and scattered impassable tiles
+func newBenchmarkGrid() *testGrid {
+	const width, height = 2400, 1350
+	g := newTestGrid(width, height)
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			h := uint32(x*73856093) ^ uint32(y*19349663)
+			h ^= h >> 13
+			h *= 0x5bd1e995
+			h ^= h >> 15
+			switch {
+			case h%100 < 8:
+				g.set(x, y, Impassable)
+			case h%100 < 30:
+				g.set(x, y, 3)
+			}
+		}
+	}
+	g.set(5, 5, 1)
+	g.set(width-6, height-6, 1)
+	return g
+}
+
+func BenchmarkFindPath(b *testing.B) {
+	g := newBenchmarkGrid()
+	p := NewPathfinder(g, 1)
+	tests := []struct {
+		name string
+		goal Point
+	}{
+		{"short", Point{100, 80}},
+		{"across", Point{g.width - 6, g.height - 6}},
+	}
+	for _, test := range tests {
+		b.Run(test.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, ok := p.FindPath(Point{5, 5}, test.goal); !ok {
+					b.Fatal("no path")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFlowField(b *testing.B) {
+	g := newBenchmarkGrid()
+	p := NewPathfinder(g, 1)
+	target := Point{g.width / 2, g.height / 2}
+	g.set(target.X, target.Y, 1)
+	for _, radius := range []int{64, 0} {
+		name := "radius64"
+		if radius == 0 {
+			name = "whole"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p.FlowField(target, radius)
+			}
+		})
+	}
+}
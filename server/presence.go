@@ -0,0 +1,35 @@
+// presence.go
+package main
+
+import (
+	"cmp"
+	"slices"
+
+	"growth-protocol"
+)
+
+// Lists everyone connected except the viewer, sorted so that unchanged
+// presence compares equal from one frame to the next. Must be called with
+// worldLock held, as it reads each client's session.
+func presenceMessage(viewer *Client) *protocol.PresenceMessage {
+	msg := &protocol.PresenceMessage{Envelope: protocol.Envelope{Type: protocol.TypePresence}, Users: []protocol.UserPresence{}}
+	for _, c := range connectedClients() {
+		if c == viewer {
+			continue
+		}
+		if c.Session == nil {
+			msg.Anonymous++
+			continue
+		}
+		msg.Users = append(msg.Users, c.Session.presence())
+	}
+	slices.SortFunc(msg.Users, func(a, b protocol.UserPresence) int {
+		return cmp.Or(cmp.Compare(a.Username, b.Username), cmp.Compare(a.Viewport.X, b.Viewport.X), cmp.Compare(a.Viewport.Y, b.Viewport.Y),
+			cmp.Compare(a.CursorX, b.CursorX), cmp.Compare(a.CursorY, b.CursorY))
+	})
+	return msg
+}
+
+func samePresence(a, b *protocol.PresenceMessage) bool {
+	return a != nil && b != nil && a.Anonymous == b.Anonymous && slices.Equal(a.Users, b.Users)
+}
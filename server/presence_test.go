@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"growth-protocol"
+)
+
+// Connects clients for the test, disconnecting them when it ends
+func connectTestClients(t *testing.T, connected ...*Client) {
+	t.Helper()
+	for _, c := range connected {
+		registerClient(c)
+		t.Cleanup(func() { unregisterClient(c) })
+	}
+}
+
+func TestPresenceMessage(t *testing.T) {
+	viewer := &Client{Session: &Session{Username: "ada", Role: protocol.RolePlayer}}
+	grace := &Client{Session: &Session{Username: "grace", Role: protocol.RoleAdmin, Viewport: protocol.Viewport{X: 5, Width: 40, Height: 20}}}
+	bob := &Client{Session: &Session{Username: "bob", Role: protocol.RoleSpectator}}
+	connectTestClients(t, grace, viewer, &Client{}, bob, &Client{})
+
+	presence := presenceMessage(viewer)
+	if presence.Anonymous != 2 || len(presence.Users) != 2 {
+		t.Fatalf("presence lists %d users and %d anonymous connections", len(presence.Users), presence.Anonymous)
+	}
+	if presence.Users[0].Username != "bob" || presence.Users[1] != grace.Session.presence() {
+		t.Errorf("presence lists %+v", presence.Users)
+	}
+	if !samePresence(presence, presenceMessage(viewer)) {
+		t.Error("unchanged presence compared different")
+	}
+
+	if _, err := handleCursor(grace, protocol.NewCursor(12.5, 7)); err != nil {
+		t.Fatal(err)
+	}
+	moved := presenceMessage(viewer)
+	if samePresence(presence, moved) || moved.Users[1].CursorX != 12.5 {
+		t.Errorf("grace's cursor didn't move: %+v", moved.Users[1])
+	}
+	if samePresence(nil, moved) {
+		t.Error("presence compared the same as nothing having been sent")
+	}
+}
@@ -0,0 +1,182 @@
+// ratelimit.go
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"growth-protocol"
+)
+
+// A tokenBucket holds up to burst tokens and refills at perSecond.
+// Each request takes one token and is refused when the bucket is empty.
+type tokenBucket struct {
+	burst     float64
+	perSecond float64
+	tokens    float64
+	last      time.Time
+}
+
+func newTokenBucket(burst, perSecond float64) *tokenBucket {
+	return &tokenBucket{burst: burst, perSecond: perSecond, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.perSecond
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type rateLimit struct {
+	burst     float64
+	perSecond float64
+}
+
+// How often each message type may be sent. The same limits apply to every
+// connection and again to each player, so opening more connections doesn't help.
+var messageRateLimits = map[string]rateLimit{
+	protocol.TypeLogin:       {burst: 3, perSecond: 0.2},
+	protocol.TypeViewport:    {burst: 10, perSecond: 8},
+	protocol.TypeUpdateTile:  {burst: 30, perSecond: 10},
+	protocol.TypePlaceHive:   {burst: 3, perSecond: 0.5},
+	protocol.TypeBuildNest:   {burst: 30, perSecond: 10},
+	protocol.TypePostTask:    {burst: 5, perSecond: 1},
+	protocol.TypeCancelTask:  {burst: 5, perSecond: 1},
+	protocol.TypeIgnite:      {burst: 3, perSecond: 0.5},
+	protocol.TypeUndo:        {burst: 10, perSecond: 4},
+	protocol.TypeRedo:        {burst: 10, perSecond: 4},
+	protocol.TypePlaceDrone:  {burst: 5, perSecond: 2},
+	protocol.TypeResetTiles:  {burst: 1, perSecond: 1.0 / 60},
+	protocol.TypeSetOverlays: {burst: 10, perSecond: 4},
+	protocol.TypeResync:      {burst: 3, perSecond: 1},
+	protocol.TypeCursor:      {burst: 20, perSecond: 12},
+	protocol.TypeChat:        {burst: 5, perSecond: 1},
+
+	protocol.TypePlaceStructure: {burst: 5, perSecond: 1},
+	protocol.TypeEditTiles:      {burst: 10, perSecond: 4},
+
+	protocol.TypeSaveWorld:   {burst: 2, perSecond: 0.1},
+	protocol.TypeLoadWorld:   {burst: 2, perSecond: 0.1},
+	protocol.TypePause:       {burst: 5, perSecond: 2},
+	protocol.TypeSetSpeed:    {burst: 5, perSecond: 2},
+	protocol.TypeSetTickRate: {burst: 5, perSecond: 2},
+	protocol.TypeSetParam:    {burst: 20, perSecond: 5},
+	protocol.TypeKick:        {burst: 5, perSecond: 1},
+	protocol.TypeAnnounce:    {burst: 3, perSecond: 0.5},
+	protocol.TypeSetRole:     {burst: 5, perSecond: 1},
+	protocol.TypeRollback:    {burst: 5, perSecond: 1},
+}
+
+// Applies to malformed messages and types without their own limit
+var defaultRateLimit = rateLimit{burst: 5, perSecond: 1}
+
+// Every rejected request costs the client a strike. Strikes recover slowly,
+// and a client that runs out is disconnected.
+const (
+	maxStrikes       = 20
+	strikesPerSecond = 0.5
+)
+
+// A rateLimiter keeps a token bucket per message type
+type rateLimiter struct {
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+func (l *rateLimiter) allow(msgType string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	limit, ok := messageRateLimits[msgType]
+	if !ok {
+		msgType = ""
+		limit = defaultRateLimit
+	}
+	bucket, ok := l.buckets[msgType]
+	if !ok {
+		bucket = newTokenBucket(limit.burst, limit.perSecond)
+		l.buckets[msgType] = bucket
+	}
+	return bucket.allow(time.Now())
+}
+
+// Password logins are also limited by the username tried from each address,
+// so guessing a password is slow however many connections it's spread over.
+// The username's bucket is kept per address so that someone else's guesses
+// can't lock its owner out. Resuming a session only counts against the address.
+var (
+	loginsByUsername = newKeyedLimiter(rateLimit{burst: 5, perSecond: 1.0 / 30})
+	loginsByAddress  = newKeyedLimiter(rateLimit{burst: 10, perSecond: 1.0 / 10})
+)
+
+// Buckets are pruned once a keyedLimiter holds this many
+const maxKeyedBuckets = 1024
+
+// A keyedLimiter keeps a token bucket per key, such as a username or an address
+type keyedLimiter struct {
+	limit   rateLimit
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newKeyedLimiter(limit rateLimit) *keyedLimiter {
+	return &keyedLimiter{limit: limit, buckets: make(map[string]*tokenBucket)}
+}
+
+func (l *keyedLimiter) allow(key string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxKeyedBuckets {
+			l.prune(now)
+		}
+		bucket = newTokenBucket(l.limit.burst, l.limit.perSecond)
+		bucket.last = now
+		l.buckets[key] = bucket
+	}
+	return bucket.allow(now)
+}
+
+// Drops the buckets that have refilled, as a new bucket would be just the
+// same. Must be called with the lock held.
+func (l *keyedLimiter) prune(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.perSecond >= bucket.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// The host part of a connection's remote address
+func remoteHost(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// Checks a login against the limits for its address and, when it has a
+// password, its username
+func checkLoginLimit(address string, msg *protocol.LoginMessage) error {
+	now := time.Now()
+	if !loginsByAddress.allow(address, now) {
+		return protocol.Errorf(protocol.CodeRateLimited, "too many logins from %s, slow down", address)
+	}
+	if msg.Token == "" && !loginsByUsername.allow(msg.Username+" "+address, now) {
+		return protocol.Errorf(protocol.CodeRateLimited, "too many logins as %s, slow down", msg.Username)
+	}
+	return nil
+}
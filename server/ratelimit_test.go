@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"growth-protocol"
+)
+
+func TestKeyedLimiterKeepsKeysApart(t *testing.T) {
+	limiter := newKeyedLimiter(rateLimit{burst: 2, perSecond: 1})
+	now := time.Now()
+	if !limiter.allow("a", now) || !limiter.allow("a", now) {
+		t.Fatal("refused a request within the burst")
+	}
+	if limiter.allow("a", now) {
+		t.Error("allowed a request past the burst")
+	}
+	if !limiter.allow("b", now) {
+		t.Error("one key's requests counted against another")
+	}
+	if !limiter.allow("a", now.Add(time.Second)) {
+		t.Error("the bucket didn't refill")
+	}
+}
+
+func TestKeyedLimiterPrunesFullBuckets(t *testing.T) {
+	limiter := newKeyedLimiter(rateLimit{burst: 1, perSecond: 1})
+	now := time.Now()
+	for i := 0; i < maxKeyedBuckets; i++ {
+		limiter.allow(strconv.Itoa(i), now)
+	}
+	limiter.allow("late", now.Add(time.Second))
+	if len(limiter.buckets) != 1 {
+		t.Errorf("%d buckets are left after pruning", len(limiter.buckets))
+	}
+
+	// Buckets that are still empty aren't pruned, so they can't be reset by flooding
+	limiter.allow("late", now.Add(time.Second))
+	for i := 0; i < maxKeyedBuckets; i++ {
+		limiter.allow(strconv.Itoa(i), now.Add(time.Second))
+	}
+	if limiter.allow("late", now.Add(time.Second)) {
+		t.Error("pruning reset an empty bucket")
+	}
+}
+
+func TestCheckLoginLimit(t *testing.T) {
+	byUsername, byAddress := loginsByUsername, loginsByAddress
+	t.Cleanup(func() { loginsByUsername, loginsByAddress = byUsername, byAddress })
+	loginsByUsername = newKeyedLimiter(rateLimit{burst: 2, perSecond: 0.001})
+	loginsByAddress = newKeyedLimiter(rateLimit{burst: 3, perSecond: 0.001})
+	login := protocol.NewLogin("ada", "guess")
+
+	// Guesses at one username from one address are limited
+	for i := 0; i < 3; i++ {
+		err := checkLoginLimit("10.0.0.1", login)
+		var protoErr *protocol.Error
+		limited := errors.As(err, &protoErr) && protoErr.Code == protocol.CodeRateLimited
+		if limited != (i == 2) {
+			t.Errorf("login %d: %v", i+1, err)
+		}
+	}
+
+	// But they don't lock the user out from elsewhere
+	if err := checkLoginLimit("10.0.0.2", login); err != nil {
+		t.Errorf("login from another address: %v", err)
+	}
+
+	// And guesses at many usernames from one address
+	for i, username := range []string{"b", "c", "d", "e"} {
+		err := checkLoginLimit("10.0.0.9", protocol.NewLogin(username, "guess"))
+		if (err != nil) != (i == 3) {
+			t.Errorf("login as %s: %v", username, err)
+		}
+	}
+	if checkLoginLimit("10.0.0.9", protocol.NewResume("token")) == nil {
+		t.Error("resumed a session from an address past its limit")
+	}
+}
+
+func TestRemoteHost(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("192.168.1.20"), Port: 51234}
+	if host := remoteHost(addr); host != "192.168.1.20" {
+		t.Errorf("remoteHost = %q", host)
+	}
+}
@@ -0,0 +1,281 @@
+// replay.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"growth-protocol"
+)
+
+// Every world the server generates is recorded in replaysDir as JSON lines: a
+// header with the seed, the tunable parameters and the players' inventories,
+// then each world changing request with the tick it was applied on, and a
+// hash of the world every checkpointInterval ticks. Everything random in the
+// simulation comes from rng, which is seeded from the world seed, so applying
+// the same requests on the same ticks rebuilds the same world. A replay that
+// doesn't match a checkpoint has found some nondeterminism.
+const (
+	replaysDir    = "replays"
+	maxReplayLine = 4 << 20 // the longest request a replay can hold, in bytes
+)
+
+// A variable so tests can check more often without stepping hundreds of ticks
+var checkpointInterval int64 = 100
+
+type replayHeader struct {
+	Seed    int64                     `json:"seed"`
+	Params  map[string]float64        `json:"params"`
+	Players map[string]map[string]int `json:"players"` // inventories carried over from the previous world
+}
+
+// A replayEntry is either a request or a checkpoint
+type replayEntry struct {
+	Tick    int64           `json:"tick"`
+	Player  string          `json:"player,omitempty"`
+	Request json.RawMessage `json:"request,omitempty"`
+	Hash    string          `json:"hash,omitempty"`
+}
+
+// Guarded by worldLock
+var (
+	recording     *json.Encoder
+	recordingFile *os.File
+)
+
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// Starts a new log for a freshly generated world. Must be called with worldLock held.
+func startRecording(worldSeed int64) {
+	stopRecording()
+	err := os.MkdirAll(replaysDir, 0755)
+	if err != nil {
+		fmt.Println("Error starting replay:", err)
+		return
+	}
+	name := fmt.Sprintf("%s-%d.jsonl", time.Now().Format("20060102-150405"), worldSeed)
+	file, err := os.Create(filepath.Join(replaysDir, name))
+	if err != nil {
+		fmt.Println("Error starting replay:", err)
+		return
+	}
+	header := replayHeader{Seed: worldSeed, Params: make(map[string]float64), Players: make(map[string]map[string]int)}
+	for paramName, param := range tunableParams {
+		header.Params[paramName] = *param.value
+	}
+	for playerName, player := range players {
+		inventory := make(map[string]int, len(player.Inventory))
+		for resource, amount := range player.Inventory {
+			inventory[resource] = amount
+		}
+		header.Players[playerName] = inventory
+	}
+	recordingFile = file
+	recording = json.NewEncoder(file)
+	writeReplay(header)
+	fmt.Println("Recording replay", name)
+}
+
+// Must be called with worldLock held
+func stopRecording() {
+	if recordingFile == nil {
+		return
+	}
+	recordingFile.Close()
+	recordingFile = nil
+	recording = nil
+}
+
+func writeReplay(value any) {
+	if recording == nil {
+		return
+	}
+	err := recording.Encode(value)
+	if err != nil {
+		fmt.Println("Error writing replay:", err)
+		stopRecording()
+	}
+}
+
+// Must be called with worldLock held
+func recordCommand(player string, msg protocol.Message) {
+	if recording == nil {
+		return
+	}
+	data, err := protocol.Encode(msg)
+	if err != nil {
+		fmt.Println("Error writing replay:", err)
+		return
+	}
+	writeReplay(replayEntry{Tick: simClock.Tick, Player: player, Request: data})
+}
+
+// Runs at the start of each step, after that tick's requests have been applied
+func recordCheckpoint() {
+	if recording == nil || simClock.Tick%checkpointInterval != 0 {
+		return
+	}
+	writeReplay(replayEntry{Tick: simClock.Tick, Hash: formatHash(worldHash())})
+}
+
+// A Replay plays a log back, checking it against the recorded hashes
+type Replay struct {
+	header   replayHeader
+	entries  []replayEntry
+	next     int   // the next entry to apply
+	checked  int   // checkpoints that matched
+	err      error // the first way the replay diverged from the recording
+	finished bool
+}
+
+// The replay being streamed to clients, or nil when the world is live. Set once
+// at startup, while the replay's progress is guarded by worldLock.
+var playback *Replay
+
+func loadReplay(path string) (*Replay, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxReplayLine)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	r := &Replay{}
+	err = json.Unmarshal(scanner.Bytes(), &r.header)
+	if err != nil {
+		return nil, fmt.Errorf("%s has a bad header: %v", path, err)
+	}
+	for line := 2; scanner.Scan(); line++ {
+		var entry replayEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", path, line, err)
+		}
+		r.entries = append(r.entries, entry)
+	}
+	return r, scanner.Err()
+}
+
+// Restores the parameters and inventories and generates the world. Must be
+// called with worldLock held.
+func (r *Replay) start() {
+	stopRecording()
+	for name, value := range r.header.Params {
+		if param, ok := tunableParams[name]; ok {
+			*param.value = value
+		}
+	}
+	// Players who joined later start out with the usual inventory
+	for name := range r.header.Players {
+		getPlayer(name)
+	}
+	for name, player := range players {
+		inventory, ok := r.header.Players[name]
+		if !ok {
+			inventory = startingInventory
+		}
+		player.Inventory = make(map[string]int, len(inventory))
+		for resource, amount := range inventory {
+			player.Inventory[resource] = amount
+		}
+		player.InventoryVersion++
+	}
+	generateWorld(r.header.Seed)
+}
+
+func (r *Replay) done() bool {
+	return r.next >= len(r.entries)
+}
+
+// Applies the requests recorded for the current tick and checks the hash at
+// checkpoints, then steps the simulation. Must be called with worldLock held.
+func (r *Replay) step() {
+	for !r.done() && r.entries[r.next].Tick <= simClock.Tick {
+		entry := r.entries[r.next]
+		r.next++
+		if entry.Request != nil {
+			r.apply(entry)
+		} else {
+			r.check(entry)
+		}
+	}
+	stepSimulation()
+}
+
+// Requests are replayed through their handlers as if the player had sent them
+func (r *Replay) apply(entry replayEntry) {
+	envelope, msg, err := protocol.Decode(entry.Request)
+	if err != nil {
+		r.fail(fmt.Errorf("tick %d: %v", entry.Tick, err))
+		return
+	}
+	h, ok := handlers[envelope.Type]
+	if !ok || !h.world {
+		r.fail(fmt.Errorf("tick %d: %s requests can't be replayed", entry.Tick, envelope.Type))
+		return
+	}
+	client := &Client{Player: getPlayer(entry.Player), Session: &Session{Username: entry.Player, Role: protocol.RoleAdmin}}
+	_, err = h.handle(client, msg)
+	if err != nil {
+		r.fail(fmt.Errorf("tick %d: %s from %s failed: %v", entry.Tick, envelope.Type, entry.Player, err))
+	}
+}
+
+func (r *Replay) check(entry replayEntry) {
+	err := verifyTileHashes()
+	if err != nil {
+		r.fail(fmt.Errorf("tick %d: %v", entry.Tick, err))
+	}
+	hash := formatHash(worldHash())
+	if hash != entry.Hash {
+		r.fail(fmt.Errorf("tick %d: the world hashed to %s, but %s was recorded", entry.Tick, hash, entry.Hash))
+		return
+	}
+	r.checked++
+}
+
+func (r *Replay) fail(err error) {
+	fmt.Println("Replay diverged:", err)
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Steps the replay being streamed to clients. Admins control its speed as usual,
+// and the clock is paused once the log runs out.
+func (r *Replay) streamStep() {
+	r.step()
+	if r.done() && !r.finished {
+		r.finished = true
+		simClock.Paused = true
+		fmt.Printf("Replay finished at tick %d, %d checkpoints matched\n", simClock.Tick, r.checked)
+	}
+}
+
+// Plays a replay as fast as possible without serving clients, stopping at the
+// first difference from the recording
+func runReplay(path string) error {
+	r, err := loadReplay(path)
+	if err != nil {
+		return err
+	}
+	worldLock.Lock()
+	defer worldLock.Unlock()
+	r.start()
+	startTime := time.Now()
+	for !r.done() && r.err == nil {
+		r.step()
+		simClock.Tick++
+	}
+	fmt.Printf("Replayed %d ticks in %v, %d checkpoints matched\n", simClock.Tick, time.Since(startTime), r.checked)
+	return r.err
+}
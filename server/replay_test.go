@@ -0,0 +1,83 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"growth-protocol"
+)
+
+// Places a hive at the first spot that takes one, searching out from the middle
+// of the map
+func placeTestHive(t *testing.T, client *Client) *Hive {
+	t.Helper()
+	for r := 0; r < 400; r += 8 {
+		for _, offset := range [][2]int{{r, 0}, {0, r}, {-r, 0}, {0, -r}} {
+			msg := protocol.NewPlaceHive(tilesWide/2+offset[0], tilesHigh/2+offset[1])
+			if _, err := handleWorldRequest(handlers[protocol.TypePlaceHive], client, msg); err == nil {
+				return hivesByOwner[client.Player.Name]
+			}
+		}
+	}
+	t.Fatal("nowhere to place a hive")
+	return nil
+}
+
+// Records a session of world changing requests and plays it back, checking
+// the replay hashes the same as the recording at every checkpoint
+func TestReplayReproducesRecording(t *testing.T) {
+	if testing.Short() {
+		t.Skip("generates two whole worlds")
+	}
+	interval := checkpointInterval
+	t.Cleanup(func() { checkpointInterval = interval })
+	checkpointInterval = 10
+	setUpGeneratedWorld(t, 20240611)
+	client := &Client{Player: getPlayer("ada"), Session: &Session{Username: "ada", Role: protocol.RoleAdmin}}
+	request := func(msg protocol.Message) {
+		t.Helper()
+		if _, err := handleWorldRequest(handlers[msg.Header().Type], client, msg); err != nil {
+			t.Fatalf("tick %d: %s: %v", simClock.Tick, msg.Header().Type, err)
+		}
+	}
+
+	var hive *Hive
+	for simClock.Tick <= 3*checkpointInterval {
+		switch simClock.Tick {
+		case 2:
+			hive = placeTestHive(t, client)
+		case 4:
+			request(protocol.NewEditAltitude(hive.X+5, hive.Y-1, 1, 3, []float64{0.02, 0.03, -0.02}))
+		case 12:
+			request(protocol.NewSetParam("forestAltitude", 0.68))
+		case 23:
+			request(protocol.NewEditAltitude(hive.X-5, hive.Y+4, 2, 1, []float64{-0.04, 0.01}))
+		}
+		stepSimulation()
+		simClock.Tick++
+	}
+	stopRecording()
+
+	paths, err := filepath.Glob(filepath.Join(replaysDir, "*.jsonl"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("found replays %v: %v", paths, err)
+	}
+	recorded, err := loadReplay(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoints, requests := 0, 0
+	for _, entry := range recorded.entries {
+		if entry.Request != nil {
+			requests++
+		} else {
+			checkpoints++
+		}
+	}
+	if checkpoints != 4 || requests != 4 {
+		t.Fatalf("recorded %d checkpoints and %d requests, expected 4 of each", checkpoints, requests)
+	}
+	if err := runReplay(paths[0]); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,258 @@
+// saves.go
+package main
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"growth-protocol"
+)
+
+// Saved worlds are gzipped gobs in savesDir. They hold the tiles, each hive
+// and how many drones it had, the oilspouts and animals, and player
+// inventories. Drones restart idle at their hive and jobs and tasks in
+// progress are dropped.
+const (
+	savesDir          = "saves"
+	saveFormatVersion = 7
+)
+
+type savedHive struct {
+	Owner      string
+	X          int
+	Y          int
+	NestRadius int
+	Drones     int
+}
+
+// The oil stored in a spout. Its tile is saved with the other tiles.
+type savedOilspout struct {
+	X      int
+	Y      int
+	Stored float64
+}
+
+// Animals forget where they were migrating to
+type savedAnimal struct {
+	Kind   string
+	X      int
+	Y      int
+	Energy float64
+	Age    int
+}
+
+type savedWorld struct {
+	Version    int
+	Tick       int64 // restores the time of day and season
+	TilesWide  int
+	TilesHigh  int
+	Types      []uint8
+	Nutrients  []float64
+	Altitudes  []float64
+	Vegetation []float64
+	Moisture   []float64
+	Water      []float64
+	Pollution  []float64
+	Fire       []float64
+	Scorch     []float64
+	Oilspouts  []savedOilspout
+	Animals    []savedAnimal
+	Hives      []savedHive
+	Players    map[string]map[string]int
+}
+
+func savePath(name string) string {
+	return filepath.Join(savesDir, name+".gob.gz")
+}
+
+// Must be called with worldLock held
+func snapshotWorld() *savedWorld {
+	world := &savedWorld{
+		Version:    saveFormatVersion,
+		Tick:       simClock.Tick,
+		TilesWide:  tilesWide,
+		TilesHigh:  tilesHigh,
+		Types:      make([]uint8, 0, tilesWide*tilesHigh),
+		Nutrients:  make([]float64, 0, tilesWide*tilesHigh),
+		Altitudes:  make([]float64, 0, tilesWide*tilesHigh),
+		Vegetation: make([]float64, 0, tilesWide*tilesHigh),
+		Moisture:   make([]float64, 0, tilesWide*tilesHigh),
+		Water:      make([]float64, 0, tilesWide*tilesHigh),
+		Pollution:  make([]float64, 0, tilesWide*tilesHigh),
+		Fire:       make([]float64, 0, tilesWide*tilesHigh),
+		Scorch:     make([]float64, 0, tilesWide*tilesHigh),
+		Players:    make(map[string]map[string]int),
+	}
+	for i := 0; i < tilesWide; i++ {
+		for j := 0; j < tilesHigh; j++ {
+			world.Types = append(world.Types, uint8(tiles[i][j].Type))
+			world.Nutrients = append(world.Nutrients, tiles[i][j].Nutrient)
+			world.Altitudes = append(world.Altitudes, tiles[i][j].Altitude)
+			world.Vegetation = append(world.Vegetation, tiles[i][j].Vegetation)
+			world.Moisture = append(world.Moisture, tiles[i][j].Moisture)
+			world.Water = append(world.Water, tiles[i][j].Water)
+			world.Pollution = append(world.Pollution, tiles[i][j].Pollution)
+			world.Fire = append(world.Fire, tiles[i][j].Fire)
+			world.Scorch = append(world.Scorch, tiles[i][j].Scorch)
+		}
+	}
+	for _, a := range animals {
+		world.Animals = append(world.Animals, savedAnimal{Kind: a.Kind, X: a.X, Y: a.Y, Energy: a.Energy, Age: a.Age})
+	}
+	for _, spout := range oilspouts {
+		world.Oilspouts = append(world.Oilspouts, savedOilspout{X: spout.X, Y: spout.Y, Stored: spout.Stored})
+	}
+	for _, hive := range hives {
+		world.Hives = append(world.Hives, savedHive{Owner: hive.Owner, X: hive.X, Y: hive.Y, NestRadius: hive.NestRadius, Drones: len(hive.Drones)})
+	}
+	for name, player := range players {
+		inventory := make(map[string]int, len(player.Inventory))
+		for resource, amount := range player.Inventory {
+			inventory[resource] = amount
+		}
+		world.Players[name] = inventory
+	}
+	return world
+}
+
+// The world is copied under the lock and written out after releasing it
+func saveWorld(name string) error {
+	startTime := time.Now()
+	worldLock.Lock()
+	world := snapshotWorld()
+	worldLock.Unlock()
+
+	err := os.MkdirAll(savesDir, 0755)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(savePath(name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	compressed, err := gzip.NewWriterLevel(file, gzip.BestSpeed)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(compressed).Encode(world)
+	if err != nil {
+		return err
+	}
+	err = compressed.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Saved world %s in %v\n", name, time.Since(startTime))
+	return nil
+}
+
+func loadWorld(name string) error {
+	startTime := time.Now()
+	file, err := os.Open(savePath(name))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("there is no saved world called %s", name)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	compressed, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer compressed.Close()
+	var world savedWorld
+	err = gob.NewDecoder(compressed).Decode(&world)
+	if err != nil {
+		return err
+	}
+	if world.Version != saveFormatVersion {
+		return fmt.Errorf("%s was saved in format %d, expected %d", name, world.Version, saveFormatVersion)
+	}
+	if world.TilesWide != tilesWide || world.TilesHigh != tilesHigh || len(world.Types) != tilesWide*tilesHigh {
+		return fmt.Errorf("%s is a %dx%d world, expected %dx%d", name, world.TilesWide, world.TilesHigh, tilesWide, tilesHigh)
+	}
+	for _, values := range [][]float64{world.Nutrients, world.Altitudes, world.Vegetation, world.Moisture, world.Water, world.Pollution, world.Fire, world.Scorch} {
+		if len(values) != len(world.Types) {
+			return fmt.Errorf("%s is missing tile data", name)
+		}
+	}
+	for _, saved := range world.Oilspouts {
+		if saved.X < 0 || saved.X >= tilesWide || saved.Y < 0 || saved.Y >= tilesHigh {
+			return fmt.Errorf("%s has an oilspout off the map at (%d, %d)", name, saved.X, saved.Y)
+		}
+	}
+	for _, saved := range world.Animals {
+		if !slices.Contains(protocol.AnimalKinds, saved.Kind) {
+			return fmt.Errorf("%s has an unknown kind of animal %q", name, saved.Kind)
+		}
+		if saved.X < 0 || saved.X >= tilesWide || saved.Y < 0 || saved.Y >= tilesHigh {
+			return fmt.Errorf("%s has an animal off the map at (%d, %d)", name, saved.X, saved.Y)
+		}
+		if math.IsNaN(saved.Energy) || math.IsInf(saved.Energy, 0) || saved.Age < 0 {
+			return fmt.Errorf("%s has an animal with %v energy at age %d", name, saved.Energy, saved.Age)
+		}
+	}
+
+	worldLock.Lock()
+	defer worldLock.Unlock()
+	// Saves don't hold everything the simulation needs to replay from them
+	if recordingFile != nil {
+		fmt.Println("Stopped recording replay, as", name, "was loaded")
+		stopRecording()
+	}
+	for i := 0; i < tilesWide; i++ {
+		for j := 0; j < tilesHigh; j++ {
+			index := i*tilesHigh + j
+			tiles[i][j] = Tile{
+				Type:       int(world.Types[index]),
+				Nutrient:   world.Nutrients[index],
+				Altitude:   world.Altitudes[index],
+				Vegetation: world.Vegetation[index],
+				Moisture:   world.Moisture[index],
+				Water:      world.Water[index],
+				Pollution:  world.Pollution[index],
+				Fire:       world.Fire[index],
+				Scorch:     world.Scorch[index],
+			}
+		}
+	}
+	rehashTiles()
+	simClock.reset()
+	simClock.Tick = world.Tick
+	worldTime = worldTimeAt(world.Tick)
+	oilspouts = make(map[[2]int]*Oilspout)
+	for _, saved := range world.Oilspouts {
+		oilspouts[[2]int{saved.X, saved.Y}] = &Oilspout{X: saved.X, Y: saved.Y, Stored: saved.Stored}
+	}
+	resetFires()
+	animals = make([]*Animal, 0, len(world.Animals))
+	for _, saved := range world.Animals {
+		animals = append(animals, &Animal{Kind: saved.Kind, X: saved.X, Y: saved.Y, Energy: saved.Energy, Age: saved.Age})
+	}
+	resetPopulations()
+	resetHives()
+	resetTerritory()
+	resetEditHistory()
+	pathCache.InvalidateAll()
+	for name, inventory := range world.Players {
+		if inventory == nil {
+			inventory = make(map[string]int)
+		}
+		player := getPlayer(name)
+		player.Inventory = inventory
+		player.InventoryVersion++
+	}
+	for _, saved := range world.Hives {
+		newHive(getPlayer(saved.Owner), saved.X, saved.Y, saved.NestRadius, saved.Drones)
+	}
+	fmt.Printf("Loaded world %s in %v\n", name, time.Since(startTime))
+	return nil
+}
@@ -8,17 +8,24 @@ import (
 	"time"
 
 	"github.com/aquilax/go-perlin"
+
+	"growth-protocol"
+	"growth-server/pathfinding"
 )
 
 type Tile struct {
-	Type     int
-	Nutrient float64
-	Altitude float64
+	Type       int
+	Nutrient   float64
+	Altitude   float64
+	Vegetation float64 // 0 to 1, how overgrown grass and forest tiles are
+	Moisture   float64 // 0 to 1, how wet the ground is
+	Water      float64 // depth of water above the ground
+	Pollution  float64 // 0 to 1, how much spilled oil covers the tile
+	Fire       float64 // vegetation left to burn, 0 if the tile isn't on fire
+	Scorch     float64 // 1 just after a fire, recovering to 0
 }
 
 const (
-	ticksPerCycle = 480.0 // 1 minute if 8 ticks per second
-
 	nutrientRate        = 0.0015
 	nutrientGreenCutOff = 0.18
 
@@ -28,8 +35,6 @@ const (
 	groundTileStartNutrient = 0.09
 )
 
-var endSimulation = false
-
 var nutrientsNearby = make(map[[2]int]struct{})
 var nutrientTiles = make(map[[2]int]struct{})
 var waterTiles = make(map[[2]int]struct{})
@@ -86,6 +91,10 @@ const startingTileType = 0
 
 var lehmer *Lehmer
 
+// Everything random in the simulation draws from rng, so a world replays the
+// same way from the same seed and commands
+var rng *rand.Rand
+
 func getRandomTileTypeByDistribution() int {
 	rand := lehmer.Int63() % 100
 	for i, v := range tileTypeStartingDistribution_Int64 {
@@ -114,20 +123,33 @@ var (
 	n     int32 = 9
 	seed  int64 = 100
 
-	deepWater     = 0
-	shallowWater  = 1
-	sand          = 2
-	grass         = 3
-	forest        = 4
-	dirt          = 5
-	mountains     = 6
-	highMountains = 7
+	deepWater     = protocol.DeepWater
+	shallowWater  = protocol.ShallowWater
+	sand          = protocol.Sand
+	grass         = protocol.Grass
+	forest        = protocol.Forest
+	dirt          = protocol.Dirt
+	mountains     = protocol.Mountains
+	highMountains = protocol.HighMountains
+	concrete      = protocol.Concrete
+	nest          = protocol.Nest
+	hiveCore      = protocol.HiveCore
+	bridge        = protocol.Bridge
+	oilspout      = protocol.Oilspout
+	ice           = protocol.Ice
+	snow          = protocol.Snow
+	puddle        = protocol.Puddle
+	pump          = protocol.Pump
+	fire          = protocol.Fire
+	burnt         = protocol.Burnt
+	shrub         = protocol.Shrub
 )
 
+// Nothing is carried over from the previous world
 func initTilesFloats() {
 	for i := 0; i < tilesWide; i++ {
 		for j := 0; j < tilesHigh; j++ {
-			tiles[i][j].Altitude = 0.5
+			tiles[i][j] = Tile{Altitude: 0.5, Vegetation: startingVegetation, Moisture: ambientMoisture}
 		}
 	}
 }
@@ -185,12 +207,65 @@ func getTileFromFloatSwitch(tile float64) int {
 	}
 }
 
-func setTileTypesFromAltitudes() {
+// Sets every tile's type from its altitude, water, vegetation and temperature
+func updateTileTypes() {
+	// Collect the tiles whose movement cost changed so their routes can be recalculated
+	var changed []pathfinding.Point
+	iceLine := worldTime.altitudeBelow(iceTemperature)
+	snowLine := worldTime.altitudeBelow(snowTemperature)
 	for i := 0; i < tilesWide; i++ {
 		for j := 0; j < tilesHigh; j++ {
-			tiles[i][j].Type = getTileFromFloatSwitch(tiles[i][j].Altitude)
+			changed = updateTileType(i, j, iceLine, snowLine, changed)
 		}
 	}
+	pathCache.Invalidate(changed)
+}
+
+// Sets the tile's type from its current state, adding it to changed if drones
+// need to route around it differently
+func updateTileType(i, j int, iceLine, snowLine float64, changed []pathfinding.Point) []pathfinding.Point {
+	if isFixedTile(tiles[i][j].Type) {
+		return changed
+	}
+	newType := naturalTileType(&tiles[i][j], iceLine, snowLine)
+	if tileMoveCost(newType) != tileMoveCost(tiles[i][j].Type) {
+		changed = append(changed, pathfinding.Point{X: i, Y: j})
+	}
+	retypeTile(i, j, newType)
+	return changed
+}
+
+// Sets the tile's type from its state straight away rather than on the next
+// tick, rerouting drones around it if it changed how they cross it
+func refreshTileType(x, y int) {
+	iceLine := worldTime.altitudeBelow(iceTemperature)
+	snowLine := worldTime.altitudeBelow(snowTemperature)
+	pathCache.Invalidate(updateTileType(x, y, iceLine, snowLine, nil))
+}
+
+// Runs the weather, water, pollution, fire recovery, vegetation and tile type updates for every tile
+// in a single pass over the map, which is much faster than a pass for each
+func simulateTiles() {
+	var changed []pathfinding.Point
+	sea := seaLevel()
+	iceLine := worldTime.altitudeBelow(iceTemperature)
+	snowLine := worldTime.altitudeBelow(snowTemperature)
+	growth := vegetationGrowthRate * worldTime.Growth * worldTime.Daylight
+	decay := vegetationDecayRate * worldTime.Decay
+	for i := 0; i < tilesWide; i++ {
+		for j := 0; j < tilesHigh; j++ {
+			tile := &tiles[i][j]
+			cell := weather.Cells[i/weatherCellSize][j/weatherCellSize]
+			drought := droughtSeverity(cell)
+			weatherTile(tile, rainIntensity(cell), drought)
+			flowWater(i, j, sea)
+			spreadPollution(i, j)
+			recoverFromFire(tile)
+			growVegetation(tile, growth, decay, drought, snowLine)
+			changed = updateTileType(i, j, iceLine, snowLine, changed)
+		}
+	}
+	pathCache.Invalidate(changed)
 }
 
 func setTilesRandomly_perlin(willModify bool) {
@@ -220,7 +295,8 @@ func generatePerlinMap(iterations int) {
 		setTilesRandomly_perlin(true)
 	}
 	normalizeTileAltitudes()
-	setTileTypesFromAltitudes()
+	floodToSeaLevel()
+	updateTileTypes()
 
 }
 
@@ -272,7 +348,7 @@ func addNutrients() {
 	// Nutrients are represented by a value of 2
 	for i := 0; i < tilesWide; i++ {
 		for j := 0; j < tilesHigh; j++ {
-			randFloat := rand.Float64()
+			randFloat := rng.Float64()
 			if tiles[i][j].Type == 0 && randFloat <= nutrientRate {
 				tiles[i][j].Type = 2
 				tiles[i][j].Nutrient = 1
@@ -292,31 +368,41 @@ func addNutrients() {
 	}
 }
 
+// Places the platform structure on open ground somewhere on the map
 func addStartingPlatform() {
-	// The starting platform is a 5x5 square (missing outermost corners) of concrete tiles
-	// concrete tiles are represented by a value of 6
-	randX := rand.Intn(tilesWide - 7)
-	randY := rand.Intn(tilesHigh - 7)
-	randX = 10
-	randY = 10
-	for i := randX; i < randX+7; i++ {
-		for j := randY; j < randY+7; j++ {
-			// check if the tile is an outermost corner
-			if !((i == randX || i == randX+6) && (j == randY || j == randY+6)) {
-				tiles[i][j].Type = 6
+	platform, _ := protocol.FindStructure(protocol.StructurePlatform)
+	for try := 0; try < 1000; try++ {
+		x, y := rng.Intn(tilesWide), rng.Intn(tilesHigh)
+		platformTiles := platform.Tiles(x, y, 0)
+		fits := true
+		for _, tile := range platformTiles {
+			if tile.X < 0 || tile.X >= tilesWide || tile.Y < 0 || tile.Y >= tilesHigh || !canBuildOn(tile.Type, tiles[tile.X][tile.Y].Type) {
+				fits = false
+				break
 			}
 		}
+		if !fits {
+			continue
+		}
+		for _, tile := range platformTiles {
+			setTileType(tile.X, tile.Y, tile.Type)
+		}
+		fmt.Printf("Starting platform at (%d, %d)\n", x, y)
+		return
 	}
-	fmt.Printf("Starting platform at (%d, %d)\n", randX, randY)
+	fmt.Println("No room for the starting platform")
 }
 
 func addOilspouts() {
-	// Oilspouts are represented by a value of 5
+	oilspoutTiles = make(map[[2]int]struct{})
+	oilspoutNearby = make(map[[2]int]struct{})
+	oilspouts = make(map[[2]int]*Oilspout)
 	for i := 0; i < tilesWide; i++ {
 		for j := 0; j < tilesHigh; j++ {
-			randFloat := rand.Float64()
-			if tiles[i][j].Type == 0 && randFloat <= oilspoutRate {
-				tiles[i][j].Type = 5
+			randFloat := rng.Float64()
+			tileType := tiles[i][j].Type
+			if (tileType == grass || tileType == dirt || tileType == sand) && randFloat <= oilspoutRate {
+				addOilspout(i, j, 0)
 				oilspoutTiles[[2]int{i, j}] = struct{}{}
 
 				// loop through the 8 surrounding tiles and add them to the oilspoutNearby list
@@ -336,7 +422,7 @@ func addInorganics() {
 	// Rocks are represented by a value of 3
 	for i := 0; i < tilesWide; i++ {
 		for j := 0; j < tilesHigh; j++ {
-			randFloat := rand.Float64()
+			randFloat := rng.Float64()
 			if tiles[i][j].Type == 0 && randFloat <= inorganicRate {
 				tiles[i][j].Type = 3
 				inorganicTiles[[2]int{i, j}] = struct{}{}
@@ -409,15 +495,15 @@ func addWaterPockets() {
 	waterTiles := make(map[[2]int]struct{})
 	for i := 0; i < tilesWide; i++ {
 		for j := 0; j < tilesHigh; j++ {
-			randFloat := rand.Float64()
+			randFloat := rng.Float64()
 			if randFloat <= waterRate {
 				// Add a pocket of water to the tiles
-				pocketSizeX := rand.Intn(3) + 1
-				pocketSizeY := rand.Intn(3) + 1
+				pocketSizeX := rng.Intn(3) + 1
+				pocketSizeY := rng.Intn(3) + 1
 				for x := 0; x < pocketSizeX; x++ {
 					for y := 0; y < pocketSizeY; y++ {
 						if i+x >= 0 && i+x < tilesWide && j+y >= 0 && j+y < tilesHigh {
-							randFloat2 := rand.Float64()
+							randFloat2 := rng.Float64()
 							if randFloat2 <= 0.75 {
 								tiles[i+x][j+y].Type = 4
 								waterTiles[[2]int{i + x, j + y}] = struct{}{}
@@ -494,9 +580,9 @@ func simulateNutrientGrowth() {
 	for coord := range nutrientsNearby {
 		i, j := coord[0], coord[1]
 		if tiles[i][j].Type == 0 {
-			randFloat := rand.Float64()
+			randFloat := rng.Float64()
 			if randFloat <= 0.5 {
-				randFloat = rand.Float64()
+				randFloat = rng.Float64()
 				// Add to the nutrient value
 				if tiles[i][j].Type == 0 {
 					tiles[i][j].Nutrient += 0.083 * (randFloat + 0.4)
@@ -541,7 +627,7 @@ func simulateNutrientDecay(cycleMultiplier float64) {
 	for i := 0; i < tilesWide; i++ {
 		// print the first 4 decimals of cycleMultiplier
 		for j := 0; j < tilesHigh; j++ {
-			rand := rand.Float64()
+			rand := rng.Float64()
 			// Check if the tile is a nutrient tile and randomly decay it
 			if (tiles[i][j].Type == 2 || tiles[i][j].Type == 0) && (int(rand*100)%2) == 0 {
 				// Decrease the nutrient value
@@ -586,53 +672,64 @@ func simulateChangingSeaLevel(cycleMultiplier float64) {
 	deepWaterAltitude = initDeepWaterAltitude + (altitudeDiff / 2)
 }
 
-func runSimulation() {
-	endSimulation = false
-	var iterationsOfCycle float64 = math.Floor(rand.Float64() * ticksPerCycle)
-	var tock bool = true
-	var iterationAddAmount float64 = 1
-	ticker := time.NewTicker(updateInterval)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			if tock {
-				cycleMultiplier := iterationsOfCycle / ticksPerCycle
-				simulateChangingSeaLevel(cycleMultiplier)
-				setTileTypesFromAltitudes()
-				// simulateNutrientDecay(cycleMultiplier)
-				// simulateWaterNutrition()
-				// simulateInorganicNutrientDecay()
-				// simulateNutrientGrowth()
-
-				if iterationsOfCycle == ticksPerCycle || iterationsOfCycle == 0 {
-					iterationAddAmount = -iterationAddAmount
-				}
-				iterationsOfCycle += iterationAddAmount
-
-			}
-			tock = !tock
-			if endSimulation {
-				break
-			}
-		}
+// Advances the world by one tick. Run by simClock with worldLock held.
+func stepSimulation() {
+	recordCheckpoint()
+	previous := worldTime
+	worldTime = worldTimeAt(simClock.Tick)
+	if worldTime.Day != previous.Day {
+		go announceDay(worldTime, worldTime.Season != previous.Season)
 	}
+	// The sea rises through the year and falls back by the end of winter
+	cycleMultiplier := worldTime.YearFraction
+	simulateChangingSeaLevel(cycleMultiplier)
+	weather.update(simClock.Tick)
+	simulateOil()
+	simulateFire()
+	simulateTiles()
+	simulateHives()
+	simulateFauna()
+	// simulateNutrientDecay(cycleMultiplier)
+	// simulateWaterNutrition()
+	// simulateInorganicNutrientDecay()
+	// simulateNutrientGrowth()
 }
 
+// Generates a new world from a random seed and starts recording it
 func resetSimulation() {
-	lehmer = NewLehmer(rand.Int63())
-	endSimulation = true
+	worldSeed := rand.Int63()
+	worldLock.Lock()
+	defer worldLock.Unlock()
+	generateWorld(worldSeed)
+	startRecording(worldSeed)
+}
+
+// Generates the world for a seed. The same seed and parameters always give
+// the same world. Must be called with worldLock held.
+func generateWorld(worldSeed int64) {
+	lehmer = NewLehmer(worldSeed)
+	rng = rand.New(rand.NewSource(worldSeed))
 	fmt.Println("Generating world")
 	startTime := time.Now()
+	worldTime = worldTimeAt(0)
+	simulateChangingSeaLevel(worldTime.YearFraction)
 	// initTiles()
 	generatePerlinMap(3)
+	resetHives()
+	resetTerritory()
+	resetWeather()
+	fires = nil
+	resetEditHistory()
+	pathCache.InvalidateAll()
 
 	// resetNutrientsMaps()
 	// addNutrients()
 	// addWaterPockets()
 	// addInorganics()
-	// addOilspouts()
+	addOilspouts()
+	resetFauna()
 	// addStartingPlatform()
+	rehashTiles()
 
 	// numTries := 1800
 	// testRange := 3
@@ -646,5 +743,5 @@ func resetSimulation() {
 	// leastConflicts(numTries/4, testRange-2)
 	fmt.Println("Finished generating world")
 	fmt.Printf("Time elapsed: %v\n", time.Since(startTime))
-	go runSimulation()
+	simClock.reset()
 }
@@ -0,0 +1,304 @@
+// tasks.go
+package main
+
+import (
+	"fmt"
+
+	"growth-protocol"
+)
+
+// Cooperative tasks need a team of drones working in lockstep.
+// They are allocated with a contract-net style auction: the hive announces a
+// task, idle drones with the right capability bid their distance to the site,
+// and the cheapest bids are awarded once enough drones have bid. Bidding
+// drones take no other work, so an auction that can't gather enough bids
+// releases them and waits before opening again.
+
+const (
+	taskOpen = iota
+	taskAssembling
+	taskWorking
+	taskReturning
+	taskDone
+)
+
+var taskStateNames = []string{"open", "assembling", "working", "returning", "done"}
+
+type TaskKind struct {
+	Required   int
+	Capability string
+	Steps      int
+	Cost       map[string]int
+}
+
+var taskKinds = map[string]TaskKind{
+	protocol.TaskHaulOre:       {Required: 2, Capability: "haul", Steps: 6},
+	protocol.TaskBridgeWater:   {Required: 3, Capability: "build", Steps: 8, Cost: map[string]int{resourceStone: 6}},
+	protocol.TaskClearMountain: {Required: 4, Capability: "dig", Steps: 12},
+}
+
+// Every drone can do one thing well, and the rest are assigned round robin
+var droneCapabilities = [][]string{
+	{"haul", "build"},
+	{"haul", "dig"},
+	{"build", "dig"},
+}
+
+const (
+	oreHaulAmount = 10
+	bidWindow     = 2  // ticks an auction stays open once it has enough bids
+	auctionTicks  = 40 // ticks an auction waits for enough bids before releasing them
+	auctionRest   = 40 // ticks a lapsed auction waits before opening again
+)
+
+type Bid struct {
+	Drone *Drone
+	Cost  int
+}
+
+type Task struct {
+	ID       int
+	Kind     string
+	X        int
+	Y        int
+	Hive     *Hive
+	State    int
+	Bids     []Bid
+	Team     []*Drone
+	Progress int
+	Window   int
+	Opened   int // ticks since the auction opened, negative while it waits to reopen
+}
+
+var nextTaskID = 1
+
+func (d *Drone) hasCapability(capability string) bool {
+	for _, c := range d.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Validates the target tile and announces the task to the hive's drones
+func (h *Hive) postTask(kind string, x, y int) (*Task, error) {
+	taskKind, ok := taskKinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown task kind %q", kind)
+	}
+	if x < 0 || x >= tilesWide || y < 0 || y >= tilesHigh {
+		return nil, fmt.Errorf("invalid tile coordinates")
+	}
+	tileType := tiles[x][y].Type
+	switch kind {
+	case protocol.TaskHaulOre, protocol.TaskClearMountain:
+		if tileType != mountains {
+			return nil, fmt.Errorf("%s needs a mountain tile, not tile type %d", kind, tileType)
+		}
+	case protocol.TaskBridgeWater:
+		if tileType != shallowWater {
+			return nil, fmt.Errorf("%s needs a shallow water tile, not tile type %d", kind, tileType)
+		}
+	}
+	// Hauling ore leaves the tile alone, so it can be done anywhere that isn't someone else's
+	if kind == protocol.TaskHaulOre {
+		if owner := chunkOwner(x, y); owner != "" && owner != h.Owner {
+			return nil, fmt.Errorf("(%d, %d) belongs to %s", x, y, owner)
+		}
+	} else if err := checkOwnership(h.Player, x, y); err != nil {
+		return nil, err
+	}
+	for _, task := range h.Tasks {
+		if task.X == x && task.Y == y {
+			return nil, fmt.Errorf("a task is already posted at (%d, %d)", x, y)
+		}
+	}
+	if err := h.Player.spend(taskKind.Cost); err != nil {
+		return nil, err
+	}
+
+	task := &Task{ID: nextTaskID, Kind: kind, X: x, Y: y, Hive: h, State: taskOpen}
+	nextTaskID++
+	h.Tasks = append(h.Tasks, task)
+	return task, nil
+}
+
+// Collects bids from idle drones and awards open tasks to the cheapest team
+func (h *Hive) allocateTasks() {
+	for _, task := range h.Tasks {
+		if task.State != taskOpen {
+			continue
+		}
+		task.Opened++
+		if task.Opened <= 0 {
+			continue
+		}
+		kind := taskKinds[task.Kind]
+		for _, drone := range h.Drones {
+			if drone.State != droneIdle || drone.Task != nil || drone.Bidding != nil || !drone.hasCapability(kind.Capability) {
+				continue
+			}
+			cost := max(abs(drone.X-task.X), abs(drone.Y-task.Y))
+			task.Bids = append(task.Bids, Bid{Drone: drone, Cost: cost})
+			drone.Bidding = task
+		}
+		if len(task.Bids) < kind.Required {
+			if task.Opened >= auctionTicks {
+				task.releaseBids()
+				task.Opened = -auctionRest
+			}
+			continue
+		}
+		// Give late bidders a short window to undercut the current leaders
+		task.Window++
+		if task.Window < bidWindow {
+			continue
+		}
+
+		sortBids(task.Bids)
+		for _, bid := range task.Bids[:kind.Required] {
+			bid.Drone.Task = task
+			task.Team = append(task.Team, bid.Drone)
+		}
+		task.releaseBids()
+		task.State = taskAssembling
+	}
+}
+
+// Frees the bidding drones to take other work
+func (t *Task) releaseBids() {
+	for _, bid := range t.Bids {
+		bid.Drone.Bidding = nil
+	}
+	t.Bids = nil
+	t.Window = 0
+}
+
+func sortBids(bids []Bid) {
+	for i := 1; i < len(bids); i++ {
+		for j := i; j > 0 && bids[j].Cost < bids[j-1].Cost; j-- {
+			bids[j], bids[j-1] = bids[j-1], bids[j]
+		}
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Moves the team through the task's synchronized steps
+func (t *Task) step() {
+	switch t.State {
+	case taskAssembling:
+		assembled := true
+		for _, drone := range t.Team {
+			if !drone.followField(t.X, t.Y) {
+				fmt.Printf("Task %d cancelled, drone %d can't reach (%d, %d)\n", t.ID, drone.ID, t.X, t.Y)
+				t.cancel()
+				return
+			}
+			if drone.X != t.X || drone.Y != t.Y {
+				assembled = false
+			}
+		}
+		if assembled {
+			t.State = taskWorking
+		}
+	case taskWorking:
+		t.Progress++
+		if t.Progress < taskKinds[t.Kind].Steps {
+			return
+		}
+		t.complete()
+	case taskReturning:
+		// The team carries the load back together, one tile per tick
+		for _, drone := range t.Team {
+			drone.followField(t.Hive.X, t.Hive.Y)
+		}
+		lead := t.Team[0]
+		if lead.X == t.Hive.X && lead.Y == t.Hive.Y {
+			t.Hive.Player.add(map[string]int{resourceStone: oreHaulAmount})
+			t.finish()
+		}
+	}
+}
+
+// Applies the task's effect to the world once the team has finished working
+func (t *Task) complete() {
+	switch t.Kind {
+	case protocol.TaskHaulOre:
+		t.State = taskReturning
+		return
+	case protocol.TaskBridgeWater:
+		if tiles[t.X][t.Y].Type == shallowWater {
+			setTileType(t.X, t.Y, bridge)
+		}
+	case protocol.TaskClearMountain:
+		// Lowering the altitude keeps the cleared tile from reverting each tick
+		tiles[t.X][t.Y].Altitude = dirtAltitude - 0.01
+		setTileType(t.X, t.Y, getTileFromFloatSwitch(tiles[t.X][t.Y].Altitude))
+	}
+	t.finish()
+}
+
+// Refunds the task's materials and releases its team
+func (t *Task) cancel() {
+	t.Hive.Player.add(taskKinds[t.Kind].Cost)
+	t.finish()
+}
+
+func (t *Task) finish() {
+	t.State = taskDone
+	t.releaseBids()
+	for _, drone := range t.Team {
+		drone.Task = nil
+	}
+	for i, task := range t.Hive.Tasks {
+		if task == t {
+			t.Hive.Tasks = append(t.Hive.Tasks[:i], t.Hive.Tasks[i+1:]...)
+			break
+		}
+	}
+}
+
+// Cancels the hive's task at (x, y), whatever state it's in. Any load the
+// team is carrying back is lost.
+func (h *Hive) cancelTask(x, y int) error {
+	for _, task := range h.Tasks {
+		if task.X == x && task.Y == y {
+			task.cancel()
+			return nil
+		}
+	}
+	return fmt.Errorf("no task is posted at (%d, %d)", x, y)
+}
+
+func taskStates() []protocol.TaskState {
+	states := []protocol.TaskState{}
+	for _, hive := range hives {
+		for _, task := range hive.Tasks {
+			team := make([]int, 0, len(task.Team))
+			for _, drone := range task.Team {
+				team = append(team, drone.ID)
+			}
+			states = append(states, protocol.TaskState{
+				ID:       task.ID,
+				Hive:     hive.ID,
+				Kind:     task.Kind,
+				X:        task.X,
+				Y:        task.Y,
+				State:    taskStateNames[task.State],
+				Required: taskKinds[task.Kind].Required,
+				Bids:     len(task.Bids),
+				Team:     team,
+				Progress: task.Progress,
+				Steps:    taskKinds[task.Kind].Steps,
+			})
+		}
+	}
+	return states
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+
+	"growth-protocol"
+)
+
+// A hive with a drone of each capability pairing on an empty world, and a task
+// that needs more haulers than it has
+func setUpAuction(t *testing.T) (*Hive, *Task) {
+	setUpWorld(t)
+	hive := &Hive{ID: 1, Owner: "ada", Player: &Player{Name: "ada", Inventory: map[string]int{}}, X: 10, Y: 10}
+	for i, capabilities := range droneCapabilities {
+		hive.Drones = append(hive.Drones, &Drone{ID: i + 1, X: 10, Y: 10, Hive: hive, Capabilities: capabilities})
+	}
+	task := &Task{ID: 1, Kind: protocol.TaskClearMountain, X: 20, Y: 20, Hive: hive, State: taskOpen}
+	hive.Tasks = append(hive.Tasks, task)
+	return hive, task
+}
+
+func TestAuctionReleasesBidsAtDeadline(t *testing.T) {
+	hive, task := setUpAuction(t)
+	hive.allocateTasks()
+	if len(task.Bids) != 2 {
+		t.Fatalf("%d drones bid, expected the 2 diggers", len(task.Bids))
+	}
+	for i := 1; i < auctionTicks; i++ {
+		hive.allocateTasks()
+	}
+	if len(task.Bids) != 0 {
+		t.Fatalf("%d bids are still held after the deadline", len(task.Bids))
+	}
+	for _, drone := range hive.Drones {
+		if drone.Bidding != nil {
+			t.Errorf("drone %d is still bidding", drone.ID)
+		}
+	}
+
+	// The auction rests so the drones can take other work, then opens again
+	for i := 0; i < auctionRest; i++ {
+		hive.allocateTasks()
+		if len(task.Bids) != 0 {
+			t.Fatalf("drones bid %d ticks into the rest", i+1)
+		}
+	}
+	hive.allocateTasks()
+	if len(task.Bids) != 2 || task.State != taskOpen {
+		t.Errorf("reopened auction has %d bids in state %s", len(task.Bids), taskStateNames[task.State])
+	}
+}
+
+func TestAuctionAwardsCheapestBids(t *testing.T) {
+	hive, task := setUpAuction(t)
+	task.Kind = protocol.TaskHaulOre
+	hive.Drones[2].Capabilities = []string{"haul"}
+	hive.Drones[0].X = 40
+	for i := 0; i < bidWindow; i++ {
+		hive.allocateTasks()
+	}
+	if task.State != taskAssembling {
+		t.Fatalf("task is %s, expected assembling", taskStateNames[task.State])
+	}
+	if len(task.Team) != 2 || task.Team[0] != hive.Drones[1] || task.Team[1] != hive.Drones[2] {
+		t.Fatalf("team is %v, expected the two nearest haulers", task.Team)
+	}
+	if hive.Drones[0].Task != nil || hive.Drones[0].Bidding != nil {
+		t.Error("the losing bidder wasn't released")
+	}
+}
+
+func TestCancelTaskReleasesDrones(t *testing.T) {
+	hive, task := setUpAuction(t)
+	task.Kind = protocol.TaskBridgeWater
+	hive.allocateTasks()
+	if len(task.Bids) == 0 {
+		t.Fatal("no drones bid")
+	}
+	if err := hive.cancelTask(task.X, task.Y); err != nil {
+		t.Fatal(err)
+	}
+	if len(hive.Tasks) != 0 || task.State != taskDone {
+		t.Errorf("task is still posted in state %s", taskStateNames[task.State])
+	}
+	for _, drone := range hive.Drones {
+		if drone.Bidding != nil || drone.Task != nil {
+			t.Errorf("drone %d is still on the task", drone.ID)
+		}
+	}
+	if hive.Player.Inventory[resourceStone] != taskKinds[protocol.TaskBridgeWater].Cost[resourceStone] {
+		t.Errorf("cancelling refunded %d stone", hive.Player.Inventory[resourceStone])
+	}
+	if err := hive.cancelTask(task.X, task.Y); err == nil {
+		t.Error("cancelled a task that was already cancelled")
+	}
+}
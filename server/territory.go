@@ -0,0 +1,222 @@
+// territory.go
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"growth-protocol"
+)
+
+// Territory is claimed in square chunks of tiles around each hive.
+// Players may only edit and build on tiles in chunks they own.
+const (
+	territoryChunkSize = 16
+	chunksWide         = (tilesWide + territoryChunkSize - 1) / territoryChunkSize
+	chunksHigh         = (tilesHigh + territoryChunkSize - 1) / territoryChunkSize
+
+	baseClaimRadius = 32
+)
+
+var chunkOwners [chunksWide][chunksHigh]string
+var territoryVersion = 0
+
+// Tile types a player may turn each tile type into with updateTile and editTiles.
+// Water, high mountains and anything built by drones can't be edited by hand.
+var allowedTransitions = map[int][]int{
+	shallowWater: {sand},
+	sand:         {shallowWater, grass, dirt},
+	grass:        {sand, forest, dirt},
+	forest:       {grass, dirt},
+	dirt:         {sand, grass, forest, mountains},
+	mountains:    {dirt},
+}
+
+func resetTerritory() {
+	chunkOwners = [chunksWide][chunksHigh]string{}
+	territoryVersion++
+}
+
+func chunkOwner(x, y int) string {
+	return chunkOwners[x/territoryChunkSize][y/territoryChunkSize]
+}
+
+// Claims every unowned chunk whose center is within the hive's radius.
+// The radius grows with the nest so the territory expands as drones build.
+func (h *Hive) claimTerritory() {
+	radius := baseClaimRadius + h.NestRadius*2
+	changed := false
+	for cx := 0; cx < chunksWide; cx++ {
+		for cy := 0; cy < chunksHigh; cy++ {
+			if chunkOwners[cx][cy] != "" {
+				continue
+			}
+			centerX := cx*territoryChunkSize + territoryChunkSize/2
+			centerY := cy*territoryChunkSize + territoryChunkSize/2
+			dx, dy := centerX-h.X, centerY-h.Y
+			if dx*dx+dy*dy <= radius*radius {
+				chunkOwners[cx][cy] = h.Owner
+				changed = true
+			}
+		}
+	}
+	if changed {
+		territoryVersion++
+	}
+}
+
+// Checks that the player owns the tile they want to change
+func checkOwnership(player *Player, x, y int) error {
+	if x < 0 || x >= tilesWide || y < 0 || y >= tilesHigh {
+		return fmt.Errorf("invalid tile coordinates")
+	}
+	owner := chunkOwner(x, y)
+	if owner == "" {
+		return protocol.Errorf(protocol.CodeForbidden, "(%d, %d) is outside of your territory", x, y)
+	}
+	if owner != player.Name {
+		return protocol.Errorf(protocol.CodeForbidden, "(%d, %d) belongs to %s", x, y, owner)
+	}
+	return nil
+}
+
+// Validates an updateTile request against ownership and the allowed transitions
+func checkTileEdit(player *Player, x, y, tileType int) error {
+	if err := checkOwnership(player, x, y); err != nil {
+		return err
+	}
+	current := tiles[x][y].Type
+	for _, allowed := range allowedTransitions[current] {
+		if allowed == tileType {
+			return nil
+		}
+	}
+	return fmt.Errorf("can't change tile type %d into %d", current, tileType)
+}
+
+// Returns the altitude in the middle of the tile type's band, so that hand edits
+// to altitude derived tiles aren't undone by the sea level cycle
+func altitudeForTileType(tileType int) (float64, bool) {
+	bands := []struct {
+		tileType int
+		low      float64
+		high     float64
+	}{
+		{shallowWater, deepWaterAltitude, shallowWaterAltitude},
+		{sand, shallowWaterAltitude, sandAltitude},
+		{grass, sandAltitude, grassAltitude},
+		{forest, grassAltitude, forestAltitude},
+		{dirt, forestAltitude, dirtAltitude},
+		{mountains, dirtAltitude, mountainsAltitude},
+	}
+	for _, band := range bands {
+		if band.tileType == tileType {
+			return (band.low + band.high) / 2, true
+		}
+	}
+	return 0, false
+}
+
+func applyTileEdit(x, y, tileType int) {
+	if altitude, ok := altitudeForTileType(tileType); ok {
+		tiles[x][y].Altitude = altitude
+	}
+	// Dug out water fills up to the sea level, and filled in water is drained
+	tiles[x][y].Water = 0
+	if tileType == shallowWater {
+		tiles[x][y].Water = math.Max(shallowWaterAltitude-tiles[x][y].Altitude, shallowWaterDepth)
+	}
+	// Edits put out fires and clear away any ash
+	tiles[x][y].Fire = 0
+	tiles[x][y].Scorch = 0
+	// Planted grass and forest start out fully grown
+	if tileType == grass || tileType == forest {
+		tiles[x][y].Vegetation = 1
+	}
+	setTileType(x, y, tileType)
+}
+
+// Players may raise and lower any tile in their territory that drones didn't build
+func checkAltitudeEdit(player *Player, x, y int) error {
+	if err := checkOwnership(player, x, y); err != nil {
+		return err
+	}
+	if isFixedTile(tiles[x][y].Type) {
+		return fmt.Errorf("can't change the altitude of tile type %d", tiles[x][y].Type)
+	}
+	return nil
+}
+
+// The tile's type follows its new altitude straight away
+func applyAltitudeEdit(x, y int, change float64) {
+	tiles[x][y].Altitude = math.Min(math.Max(tiles[x][y].Altitude+change, 0), 1)
+	refreshTileType(x, y)
+}
+
+// Applies every edit in the rectangle that the player is allowed to make,
+// skipping the rest, and records them as a single operation. Fails only when
+// none of the edits could be made.
+func editTiles(player *Player, msg *protocol.EditTilesMessage) error {
+	var changes []tileChange
+	var firstErr error
+	for i := 0; i < *msg.Width; i++ {
+		for j := 0; j < *msg.Height; j++ {
+			index := i**msg.Height + j
+			x, y := *msg.X+i, *msg.Y+j
+			if (msg.Types != nil && msg.Types[index] == protocol.LeaveTile) || (msg.Altitude != nil && msg.Altitude[index] == 0) {
+				continue
+			}
+			var err error
+			if msg.Types != nil {
+				err = checkTileEdit(player, x, y, msg.Types[index])
+			} else {
+				err = checkAltitudeEdit(player, x, y)
+			}
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			before := saveTileState(x, y)
+			if msg.Types != nil {
+				applyTileEdit(x, y, msg.Types[index])
+			} else {
+				applyAltitudeEdit(x, y, msg.Altitude[index])
+			}
+			changes = append(changes, tileChange{X: x, Y: y, Before: before, After: saveTileState(x, y)})
+		}
+	}
+	recordEdit(player, changes)
+	if len(changes) == 0 && firstErr != nil {
+		return firstErr
+	}
+	return nil
+}
+
+// Chunk owners as indices into a list of player names, 0 being unclaimed
+func territoryMessage() *protocol.TerritoryMessage {
+	names := []string{""}
+	indices := map[string]int{"": 0}
+	owners := make([]int, 0, chunksWide*chunksHigh)
+	for cy := 0; cy < chunksHigh; cy++ {
+		for cx := 0; cx < chunksWide; cx++ {
+			owner := chunkOwners[cx][cy]
+			index, ok := indices[owner]
+			if !ok {
+				index = len(names)
+				indices[owner] = index
+				names = append(names, owner)
+			}
+			owners = append(owners, index)
+		}
+	}
+	return &protocol.TerritoryMessage{
+		Envelope:   protocol.Envelope{Type: protocol.TypeTerritory},
+		ChunkSize:  territoryChunkSize,
+		ChunksWide: chunksWide,
+		ChunksHigh: chunksHigh,
+		Owners:     owners,
+		Players:    names,
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"growth-protocol"
+)
+
+func TestCheckTileEdit(t *testing.T) {
+	setUpWorld(t)
+	chunkOwners[0][0] = "ada"
+	chunkOwners[1][0] = "grace"
+	ada := &Player{Name: "ada", Inventory: map[string]int{}}
+
+	if err := checkTileEdit(ada, 1, 1, forest); err != nil {
+		t.Errorf("planting a forest in ada's territory: %v", err)
+	}
+	for _, x := range []int{territoryChunkSize + 1, 2*territoryChunkSize + 1} {
+		err := checkTileEdit(ada, x, 1, forest)
+		var protoErr *protocol.Error
+		if !errors.As(err, &protoErr) || protoErr.Code != protocol.CodeForbidden {
+			t.Errorf("edit at (%d, 1) outside ada's territory: %v", x, err)
+		}
+	}
+	if checkTileEdit(ada, 1, 1, shallowWater) == nil {
+		t.Error("flooded grass by hand")
+	}
+	if checkTileEdit(ada, -1, 1, forest) == nil {
+		t.Error("edited a tile off the map")
+	}
+}
+
+func TestClaimTerritory(t *testing.T) {
+	setUpWorld(t)
+	chunkOwners[4][3] = "grace"
+	version := territoryVersion
+	hive := &Hive{Owner: "ada", X: 4*territoryChunkSize + 8, Y: 4*territoryChunkSize + 8}
+	hive.claimTerritory()
+	if chunkOwners[4][4] != "ada" || chunkOwners[5][4] != "ada" {
+		t.Error("the chunks around the hive weren't claimed")
+	}
+	if chunkOwners[4][3] != "grace" {
+		t.Error("claimed a chunk someone else owns")
+	}
+	if chunkOwners[0][0] != "" {
+		t.Error("claimed a chunk out of reach")
+	}
+	if territoryVersion == version {
+		t.Error("claiming didn't bump the territory version")
+	}
+}
+
+// Edits outside the player's territory are skipped, while the rest are made
+func TestEditTilesSkipsForbiddenTiles(t *testing.T) {
+	setUpWorld(t)
+	chunkOwners[0][0] = "ada"
+	ada := &Player{Name: "ada", Inventory: map[string]int{}}
+	x := territoryChunkSize - 1
+	if err := editTiles(ada, protocol.NewEditTypes(x, 0, 2, 1, []int{forest, forest})); err != nil {
+		t.Fatal(err)
+	}
+	if tiles[x][0].Type != forest || tiles[x+1][0].Type != grass {
+		t.Errorf("edit left tile types %d and %d", tiles[x][0].Type, tiles[x+1][0].Type)
+	}
+	if history := historyFor("ada"); len(history.done) != 1 || len(history.done[0].Changes) != 1 {
+		t.Error("the edit wasn't recorded as one operation of the one tile changed")
+	}
+	if editTiles(ada, protocol.NewEditTypes(x+1, 0, 1, 1, []int{forest})) == nil {
+		t.Error("an edit made entirely outside the territory succeeded")
+	}
+}
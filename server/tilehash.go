@@ -0,0 +1,79 @@
+// tilehash.go
+package main
+
+import (
+	"fmt"
+
+	"growth-protocol"
+)
+
+// Each chunk's hash is kept up to date as tiles change type, so frames can
+// carry the hashes without going over the whole map. The hashes are computed
+// the same way as the client's, see protocol.TileHash. Guarded by worldLock.
+var chunkHashes [protocol.HashChunks]uint64
+
+func chunkOf(x, y int) int {
+	return protocol.ChunkIndex(x/protocol.HashChunkSize, y/protocol.HashChunkSize)
+}
+
+// Sets a tile's type, updating its chunk's hash
+func retypeTile(x, y, tileType int) {
+	oldType := tiles[x][y].Type
+	if oldType == tileType {
+		return
+	}
+	chunkHashes[chunkOf(x, y)] += protocol.TileHash(x, y, tileType) - protocol.TileHash(x, y, oldType)
+	tiles[x][y].Type = tileType
+}
+
+// Hashes a chunk from scratch
+func hashChunk(index int) uint64 {
+	x, y, width, height := protocol.ChunkBounds(index)
+	var hash uint64
+	for i := x; i < x+width; i++ {
+		for j := y; j < y+height; j++ {
+			hash += protocol.TileHash(i, j, tiles[i][j].Type)
+		}
+	}
+	return hash
+}
+
+// Recomputes every chunk's hash, after the whole map has been generated or loaded
+func rehashTiles() {
+	for index := range chunkHashes {
+		chunkHashes[index] = hashChunk(index)
+	}
+}
+
+// The hash of every tile's type
+func tilesHash() uint64 {
+	var hash uint64
+	for _, chunkHash := range chunkHashes {
+		hash += chunkHash
+	}
+	return hash
+}
+
+// Checks the running hashes against the map, to catch tiles that changed
+// type without going through retypeTile
+func verifyTileHashes() error {
+	for index, chunkHash := range chunkHashes {
+		if hash := hashChunk(index); hash != chunkHash {
+			x, y, _, _ := protocol.ChunkBounds(index)
+			return fmt.Errorf("the chunk at %d, %d hashed to %016x, but its running hash is %016x", x, y, hash, chunkHash)
+		}
+	}
+	return nil
+}
+
+// The tiles of a chunk, for sending to a client
+func chunkTiles(index int) protocol.TileChunk {
+	x, y, width, height := protocol.ChunkBounds(index)
+	types := make([]int, 0, width*height)
+	for i := x; i < x+width; i++ {
+		for j := y; j < y+height; j++ {
+			types = append(types, tiles[i][j].Type)
+		}
+	}
+	return protocol.TileChunk{Index: index, Tiles: types}
+}
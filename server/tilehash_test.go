@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"growth-protocol"
+)
+
+// Fills the test area with a pattern of tile types and hashes the map from scratch
+func setUpTileHashes(t *testing.T) {
+	setUpWorld(t)
+	for i := 0; i < testAreaSize; i++ {
+		for j := 0; j < testAreaSize; j++ {
+			tiles[i][j] = Tile{Type: (i*7 + j*3) % 8}
+		}
+	}
+	rehashTiles()
+}
+
+func TestRetypeTileUpdatesChunkHash(t *testing.T) {
+	setUpTileHashes(t)
+	before := chunkHashes
+	retypeTile(40, 70, concrete)
+	retypeTile(testAreaSize-1, testAreaSize-1, oilspout)
+	changed := map[int]bool{chunkOf(40, 70): true, chunkOf(testAreaSize-1, testAreaSize-1): true}
+	for index := range chunkHashes {
+		if changed[index] == (chunkHashes[index] == before[index]) {
+			t.Errorf("chunk %d changed: %v, expected %v", index, chunkHashes[index] != before[index], changed[index])
+		}
+	}
+	if err := verifyTileHashes(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing a tile back restores the hash
+	retypeTile(40, 70, (40*7+70*3)%8)
+	if chunkHashes[chunkOf(40, 70)] != before[chunkOf(40, 70)] {
+		t.Error("retyping a tile back didn't restore its chunk's hash")
+	}
+}
+
+func TestTilesHashIsSumOfChunks(t *testing.T) {
+	setUpTileHashes(t)
+	var sum uint64
+	for i := 0; i < tilesWide; i++ {
+		for j := 0; j < tilesHigh; j++ {
+			sum += protocol.TileHash(i, j, tiles[i][j].Type)
+		}
+	}
+	if tilesHash() != sum {
+		t.Errorf("tilesHash() = %016x, expected %016x", tilesHash(), sum)
+	}
+}
+
+func TestVerifyTileHashesCatchesUntrackedChanges(t *testing.T) {
+	setUpTileHashes(t)
+	tiles[100][100].Type = bridge
+	if verifyTileHashes() == nil {
+		t.Error("a tile changed without retypeTile went unnoticed")
+	}
+}
+
+func TestChunkTilesMatchesHash(t *testing.T) {
+	setUpTileHashes(t)
+	index := chunkOf(testAreaSize-1, 0)
+	chunk := chunkTiles(index)
+	x, y, width, height := protocol.ChunkBounds(index)
+	var hash uint64
+	for i := 0; i < width; i++ {
+		for j := 0; j < height; j++ {
+			hash += protocol.TileHash(x+i, y+j, chunk.Tiles[i*height+j])
+		}
+	}
+	if hash != chunkHashes[index] {
+		t.Errorf("chunk %d's tiles hash to %016x, expected %016x", index, hash, chunkHashes[index])
+	}
+}
@@ -0,0 +1,93 @@
+// tiletypes.go
+package main
+
+import (
+	"growth-server/pathfinding"
+)
+
+type TileType struct {
+	Name     string
+	MoveCost float64 // cost for a drone to enter the tile
+	Fixed    bool    // not derived from altitude, so it survives the sea level cycle
+}
+
+// Indexed by tile type
+var tileRegistry = []TileType{
+	{Name: "deepWater", MoveCost: pathfinding.Impassable},     // 0
+	{Name: "shallowWater", MoveCost: 4},                       // 1
+	{Name: "sand", MoveCost: 1.5},                             // 2
+	{Name: "grass", MoveCost: 1},                              // 3
+	{Name: "forest", MoveCost: 2},                             // 4
+	{Name: "dirt", MoveCost: 1},                               // 5
+	{Name: "mountains", MoveCost: 3},                          // 6
+	{Name: "highMountains", MoveCost: pathfinding.Impassable}, // 7
+	{Name: "concrete", MoveCost: 0.5, Fixed: true},            // 8
+	{Name: "nest", MoveCost: 0.75, Fixed: true},               // 9
+	{Name: "hiveCore", MoveCost: 0.75, Fixed: true},           // 10
+	{Name: "bridge", MoveCost: 1, Fixed: true},                // 11
+	{Name: "oilspout", MoveCost: 2, Fixed: true},              // 12
+	{Name: "ice", MoveCost: 1.5},                              // 13
+	{Name: "snow", MoveCost: 2},                               // 14
+	{Name: "puddle", MoveCost: 2},                             // 15
+	{Name: "pump", MoveCost: 1, Fixed: true},                  // 16
+	{Name: "fire", MoveCost: 10},                              // 17
+	{Name: "burnt", MoveCost: 1},                              // 18
+	{Name: "shrub", MoveCost: 1.5},                            // 19
+}
+
+const (
+	maxPathSearchNodes = 250000
+	flowFieldRadius    = 96
+)
+
+func tileMoveCost(tileType int) float64 {
+	if tileType < 0 || tileType >= len(tileRegistry) {
+		return pathfinding.Impassable
+	}
+	return tileRegistry[tileType].MoveCost
+}
+
+func isFixedTile(tileType int) bool {
+	return tileType >= 0 && tileType < len(tileRegistry) && tileRegistry[tileType].Fixed
+}
+
+func minMoveCost() float64 {
+	minCost := 0.0
+	for _, tileType := range tileRegistry {
+		if tileType.MoveCost >= 0 && (minCost == 0 || tileType.MoveCost < minCost) {
+			minCost = tileType.MoveCost
+		}
+	}
+	return minCost
+}
+
+// worldGrid exposes the movement cost of the tiles to the pathfinder
+type worldGrid struct{}
+
+func (worldGrid) Size() (int, int) {
+	return tilesWide, tilesHigh
+}
+
+func (worldGrid) Cost(x, y int) float64 {
+	return tileMoveCost(tiles[x][y].Type)
+}
+
+var pathCache = newPathCache()
+
+func newPathCache() *pathfinding.Cache {
+	finder := pathfinding.NewPathfinder(worldGrid{}, minMoveCost())
+	finder.MaxNodes = maxPathSearchNodes
+	return pathfinding.NewCache(finder)
+}
+
+// Sets a tile's type and invalidates any routes that cross it
+func setTileType(x, y, tileType int) {
+	if tiles[x][y].Type == tileType {
+		return
+	}
+	oldCost := tileMoveCost(tiles[x][y].Type)
+	retypeTile(x, y, tileType)
+	if tileMoveCost(tileType) != oldCost {
+		pathCache.Invalidate([]pathfinding.Point{{X: x, Y: y}})
+	}
+}
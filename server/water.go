@@ -0,0 +1,96 @@
+// water.go
+package main
+
+import (
+	"math"
+)
+
+// Each tile holds a depth of water on top of its altitude, and water flows
+// from higher surfaces to lower ones between neighbouring tiles. Tiles whose
+// ground lies below deepWaterAltitude are open sea, held at the sea level so
+// the sea-level cycle and the tides push water in and out along the coasts.
+const (
+	flowRate          = 0.25  // fraction of the difference in surface height that flows per tick
+	shallowWaterDepth = 0.004 // less than this is a puddle, or dry ground
+	puddleDepth       = 0.0005
+	tideAmplitude     = 0.005 // two tides a day, on top of the sea-level cycle
+	minWaterDepth     = 1e-6  // water shallower than this dries up completely
+
+	rainfallDepth      = 0.00005 // water added per tick of full rain
+	evaporationRate    = 0.00002 // water lost per tick with no drought
+	droughtEvaporation = 3.0     // water evaporates up to this much faster again in drought
+)
+
+func seaLevel() float64 {
+	return shallowWaterAltitude + tideAmplitude*math.Sin(4*math.Pi*worldTime.TimeOfDay)
+}
+
+// Water deeper than this is drawn as deep water
+func deepWaterDepth() float64 {
+	return shallowWaterAltitude - deepWaterAltitude
+}
+
+// Fills every tile up to the sea level, used when generating the world
+func floodToSeaLevel() {
+	sea := seaLevel()
+	for i := 0; i < tilesWide; i++ {
+		for j := 0; j < tilesHigh; j++ {
+			tiles[i][j].Water = math.Max(sea-tiles[i][j].Altitude, 0)
+		}
+	}
+}
+
+// Tops up the open sea and lets water flow between the tile and its
+// neighbours to the right and below. Called for every tile in order, so once
+// a tile is visited all of its flows for the tick are done.
+func flowWater(i, j int, sea float64) {
+	tile := &tiles[i][j]
+	if tile.Altitude < deepWaterAltitude {
+		tile.Water = sea - tile.Altitude
+	}
+	if i+1 < tilesWide {
+		flow(tile, &tiles[i+1][j])
+	}
+	if j+1 < tilesHigh {
+		flow(tile, &tiles[i][j+1])
+	}
+}
+
+// Moves water from the tile with the higher surface towards the lower one.
+// A tile gives up at most a quarter of its water to each neighbour so it
+// never goes negative.
+func flow(a, b *Tile) {
+	if a.Water == 0 && b.Water == 0 {
+		return
+	}
+	amount := ((a.Altitude + a.Water) - (b.Altitude + b.Water)) * flowRate
+	if amount > 0 {
+		amount = math.Min(amount, a.Water/4)
+	} else {
+		amount = math.Max(amount, -b.Water/4)
+	}
+	a.Water -= amount
+	b.Water += amount
+}
+
+// Deep and shallow water and puddles come from the depth of water on the
+// tile, and returns -1 for dry ground
+func waterTileType(tile *Tile) int {
+	switch {
+	case tile.Water >= deepWaterDepth():
+		return deepWater
+	case tile.Water >= shallowWaterDepth:
+		return shallowWater
+	case tile.Water >= puddleDepth:
+		return puddle
+	}
+	return -1
+}
+
+// The tile type for dry ground at an altitude. Dry seabed is sand.
+func landTileType(altitude float64) int {
+	if altitude < sandAltitude {
+		return sand
+	}
+	return getTileFromFloatSwitch(altitude)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFlowMovesWaterDownhill(t *testing.T) {
+	high := Tile{Altitude: 0.6, Water: 0.01}
+	low := Tile{Altitude: 0.5}
+	flow(&high, &low)
+	if low.Water <= 0 || high.Water+low.Water != 0.01 {
+		t.Errorf("flow left %v uphill and %v downhill", high.Water, low.Water)
+	}
+	if high.Water < 0.01*3/4 {
+		t.Errorf("the uphill tile gave up more than a quarter of its water, leaving %v", high.Water)
+	}
+
+	// Which tile comes first doesn't change which way the water goes
+	before := low.Water
+	flow(&low, &high)
+	if low.Water <= before {
+		t.Errorf("water flowed uphill, leaving %v downhill", low.Water)
+	}
+}
+
+// Water poured at the top of a slope runs down and pools at the bottom,
+// without any being lost along the way
+func TestWaterPoolsAtBottomOfSlope(t *testing.T) {
+	setUpWorld(t)
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 8; j++ {
+			tiles[i][j] = Tile{Type: grass, Altitude: 0.6 - 0.01*float64(i+j)}
+		}
+	}
+	tiles[0][0].Water = 0.05
+	sea := seaLevel()
+	for tick := 0; tick < 500; tick++ {
+		for i := 0; i < 7; i++ {
+			for j := 0; j < 7; j++ {
+				flowWater(i, j, sea)
+			}
+		}
+	}
+	total := 0.0
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 8; j++ {
+			total += tiles[i][j].Water
+		}
+	}
+	if math.Abs(total-0.05) > 1e-12 {
+		t.Errorf("%v water is left of 0.05", total)
+	}
+	if tiles[6][6].Water <= tiles[0][0].Water || tiles[6][6].Water <= tiles[3][3].Water {
+		t.Errorf("water is %v at the top, %v halfway and %v at the bottom", tiles[0][0].Water, tiles[3][3].Water, tiles[6][6].Water)
+	}
+}
+
+func TestWaterTileType(t *testing.T) {
+	tests := []struct {
+		water    float64
+		tileType int
+	}{
+		{0, -1},
+		{puddleDepth, puddle},
+		{shallowWaterDepth, shallowWater},
+		{deepWaterDepth(), deepWater},
+	}
+	for _, test := range tests {
+		if tileType := waterTileType(&Tile{Water: test.water}); tileType != test.tileType {
+			t.Errorf("%v water is tile type %d, expected %d", test.water, tileType, test.tileType)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// weather.go
+package main
+
+import (
+	"math"
+
+	"github.com/aquilax/go-perlin"
+
+	"growth-protocol"
+)
+
+// Weather is a noise field over coarse cells of the map. Positive values are
+// rain and negative values are drought. The field slowly changes shape and
+// drifts across the map with the wind, which itself slowly turns.
+const (
+	weatherCellSize   = 30
+	weatherCellsWide  = (tilesWide + weatherCellSize - 1) / weatherCellSize
+	weatherCellsHigh  = (tilesHigh + weatherCellSize - 1) / weatherCellSize
+	weatherScale      = 12.0  // cells across one weather system
+	weatherChangeRate = 0.002 // how quickly weather systems form and break up, per tick
+	windSpeed         = 0.04  // cells per tick
+	windTurnRate      = 0.0002
+
+	rainThreshold    = 0.25 // weather values beyond this rain or dry out the ground
+	stormThreshold   = 0.7
+	droughtThreshold = -0.25
+
+	ambientMoisture    = 0.4 // ground moisture with no rain or drought
+	rainfallRate       = 0.02
+	dryingRate         = 0.005
+	droughtDecayFactor = 2.0 // vegetation dies back up to this much faster in drought
+)
+
+type Weather struct {
+	noise     *perlin.Perlin
+	windNoise *perlin.Perlin
+	WindX     float64
+	WindY     float64
+	offsetX   float64 // how far the wind has carried the weather, in cells
+	offsetY   float64
+	Cells     [weatherCellsWide][weatherCellsHigh]float64 // -1 for severe drought to 1 for a storm
+}
+
+// Guarded by worldLock
+var weather = &Weather{}
+
+// Starts new weather systems. Must be called with worldLock held.
+func resetWeather() {
+	weather = &Weather{
+		noise:     perlin.NewPerlin(2, 2, 3, lehmer.Int63()),
+		windNoise: perlin.NewPerlin(2, 2, 2, lehmer.Int63()),
+	}
+	weather.update(0)
+}
+
+// Moves the weather on to the given tick
+func (w *Weather) update(tick int64) {
+	angle := 2 * math.Pi * w.windNoise.Noise1D(float64(tick)*windTurnRate)
+	w.WindX = math.Cos(angle) * windSpeed
+	w.WindY = math.Sin(angle) * windSpeed
+	w.offsetX += w.WindX
+	w.offsetY += w.WindY
+	z := float64(tick) * weatherChangeRate
+	for cx := 0; cx < weatherCellsWide; cx++ {
+		for cy := 0; cy < weatherCellsHigh; cy++ {
+			value := w.noise.Noise3D((float64(cx)-w.offsetX)/weatherScale, (float64(cy)-w.offsetY)/weatherScale, z)
+			w.Cells[cx][cy] = math.Min(math.Max(value*2, -1), 1)
+		}
+	}
+}
+
+// How hard it is raining in a weather cell, from 0 to 1 or 2 in a storm
+func rainIntensity(value float64) float64 {
+	if value <= rainThreshold {
+		return 0
+	}
+	rain := (value - rainThreshold) / (1 - rainThreshold)
+	if value > stormThreshold {
+		rain *= 2
+	}
+	return rain
+}
+
+// How severe the drought is in a weather cell, from 0 to 1
+func droughtSeverity(value float64) float64 {
+	if value >= droughtThreshold {
+		return 0
+	}
+	return (droughtThreshold - value) / (1 + droughtThreshold)
+}
+
+// Rain falls as water on the tile and soaks the ground, which then dries back
+// out to the ambient moisture, or drier than that in a drought. Standing water
+// slowly evaporates, faster in a drought.
+func weatherTile(tile *Tile, rain, drought float64) {
+	tile.Water += rainfallDepth*rain - evaporationRate*(1+droughtEvaporation*drought)
+	if tile.Water < minWaterDepth {
+		tile.Water = 0
+	}
+	if tile.Water > 0 {
+		tile.Moisture = 1
+		return
+	}
+	tile.Moisture += rainfallRate*rain*(1-tile.Moisture) - dryingRate*(tile.Moisture-ambientMoisture*(1-drought))
+}
+
+func (w *Weather) state() protocol.WeatherState {
+	cells := make([][]int, weatherCellsWide)
+	for cx := range cells {
+		cells[cx] = make([]int, weatherCellsHigh)
+		for cy := range cells[cx] {
+			cells[cx][cy] = int(math.Round(w.Cells[cx][cy] * 100))
+		}
+	}
+	return protocol.WeatherState{
+		CellSize: weatherCellSize,
+		WindX:    w.WindX,
+		WindY:    w.WindY,
+		Cells:    cells,
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRainAndDrought(t *testing.T) {
+	if rainIntensity(rainThreshold) != 0 || droughtSeverity(droughtThreshold) != 0 {
+		t.Error("rain or drought at the thresholds")
+	}
+	if rain := rainIntensity(stormThreshold); rain <= 0 || rain >= 1 {
+		t.Errorf("rain of %v just short of a storm", rain)
+	}
+	if rainIntensity(1) != 2 {
+		t.Errorf("the worst storm rains %v", rainIntensity(1))
+	}
+	if droughtSeverity(-1) != 1 {
+		t.Errorf("the worst drought has severity %v", droughtSeverity(-1))
+	}
+}
+
+// Rain leaves standing water on dry ground and soaks it, and once the rain
+// stops the ground dries out, further in a drought
+func TestWeatherTile(t *testing.T) {
+	tile := Tile{Moisture: ambientMoisture}
+	weatherTile(&tile, 1, 0)
+	if tile.Water <= 0 || tile.Moisture != 1 {
+		t.Fatalf("rain left %v water and %v moisture", tile.Water, tile.Moisture)
+	}
+	for i := 0; i < 2000; i++ {
+		weatherTile(&tile, 0, 0)
+	}
+	if tile.Water != 0 || math.Abs(tile.Moisture-ambientMoisture) > 0.01 {
+		t.Errorf("after the rain the tile has %v water and %v moisture", tile.Water, tile.Moisture)
+	}
+	for i := 0; i < 2000; i++ {
+		weatherTile(&tile, 0, 1)
+	}
+	if tile.Moisture > 0.01 {
+		t.Errorf("a severe drought left %v moisture", tile.Moisture)
+	}
+}
+
+// The weather comes from the world's generator, so a replay sees the same weather
+func TestWeatherIsDeterministic(t *testing.T) {
+	setUpWorld(t)
+	lehmer = NewLehmer(7)
+	resetWeather()
+	first := weather
+	lehmer = NewLehmer(7)
+	resetWeather()
+	for tick := int64(1); tick <= 50; tick++ {
+		first.update(tick)
+		weather.update(tick)
+	}
+	if first.Cells != weather.Cells || first.WindX != weather.WindX {
+		t.Error("the same seed gave different weather")
+	}
+	if wind := math.Hypot(weather.WindX, weather.WindY); math.Abs(wind-windSpeed) > 1e-9 {
+		t.Errorf("the wind blows at %v", wind)
+	}
+	for _, column := range weather.Cells {
+		for _, value := range column {
+			if value < -1 || value > 1 {
+				t.Fatalf("weather value %v", value)
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+
+	"growth-protocol"
+)
+
+// Tests build their worlds in this corner of the map. It spans several
+// territory and hash chunks.
+const testAreaSize = 4 * protocol.HashChunkSize
+
+// Everything in the world a test may change, so it can be put back when the
+// test ends. Only the test area's tiles are kept unless the whole map is.
+type worldSnapshot struct {
+	area             [testAreaSize][testAreaSize]Tile
+	allTiles         *[tilesWide][tilesHigh]Tile
+	chunkHashes      [protocol.HashChunks]uint64
+	hives            []*Hive
+	hivesByOwner     map[string]*Hive
+	nextHiveID       int
+	nextDroneID      int
+	nextTaskID       int
+	chunkOwners      [chunksWide][chunksHigh]string
+	territoryVersion int
+	editHistories    map[string]*editHistory
+	players          map[string]*Player
+	oilspouts        map[[2]int]*Oilspout
+	animals          []*Animal
+	populations      map[string]*population
+	faunaStats       *protocol.StatsMessage
+	statsVersion     int
+	fires            [][2]int
+	weather          *Weather
+	worldTime        WorldTime
+	simClock         SimClock
+	params           map[string]float64
+	lehmer           *Lehmer
+	rng              *rand.Rand
+}
+
+func takeWorldSnapshot(wholeMap bool) *worldSnapshot {
+	s := &worldSnapshot{
+		chunkHashes:      chunkHashes,
+		hives:            hives,
+		hivesByOwner:     hivesByOwner,
+		nextHiveID:       nextHiveID,
+		nextDroneID:      nextDroneID,
+		nextTaskID:       nextTaskID,
+		chunkOwners:      chunkOwners,
+		territoryVersion: territoryVersion,
+		editHistories:    editHistories,
+		players:          players,
+		oilspouts:        oilspouts,
+		animals:          animals,
+		populations:      populations,
+		faunaStats:       faunaStats,
+		statsVersion:     statsVersion,
+		fires:            fires,
+		weather:          weather,
+		worldTime:        worldTime,
+		simClock:         *simClock,
+		params:           make(map[string]float64),
+		lehmer:           lehmer,
+		rng:              rng,
+	}
+	if wholeMap {
+		s.allTiles = new([tilesWide][tilesHigh]Tile)
+		*s.allTiles = tiles
+	} else {
+		for i := range s.area {
+			copy(s.area[i][:], tiles[i][:testAreaSize])
+		}
+	}
+	for name, param := range tunableParams {
+		s.params[name] = *param.value
+	}
+	return s
+}
+
+func (s *worldSnapshot) restore() {
+	if s.allTiles != nil {
+		tiles = *s.allTiles
+	} else {
+		for i := range s.area {
+			copy(tiles[i][:testAreaSize], s.area[i][:])
+		}
+	}
+	chunkHashes = s.chunkHashes
+	hives = s.hives
+	hivesByOwner = s.hivesByOwner
+	nextHiveID = s.nextHiveID
+	nextDroneID = s.nextDroneID
+	nextTaskID = s.nextTaskID
+	chunkOwners = s.chunkOwners
+	territoryVersion = s.territoryVersion
+	editHistories = s.editHistories
+	players = s.players
+	oilspouts = s.oilspouts
+	animals = s.animals
+	populations = s.populations
+	faunaStats = s.faunaStats
+	statsVersion = s.statsVersion
+	fires = s.fires
+	weather = s.weather
+	worldTime = s.worldTime
+	*simClock = s.simClock
+	for name, value := range s.params {
+		*tunableParams[name].value = value
+	}
+	lehmer = s.lehmer
+	rng = s.rng
+	pathCache.InvalidateAll()
+}
+
+// Gives the test an empty world: lush grass over the test area, with no hives,
+// territory, players, animals or fires, and calm weather at tick 0. The world
+// is put back as it was when the test ends.
+func setUpWorld(t *testing.T) {
+	t.Helper()
+	t.Cleanup(takeWorldSnapshot(false).restore)
+	for i := 0; i < testAreaSize; i++ {
+		for j := 0; j < testAreaSize; j++ {
+			tiles[i][j] = Tile{Type: grass, Altitude: 0.45, Vegetation: 1}
+		}
+	}
+	resetHives()
+	resetTerritory()
+	resetEditHistory()
+	players = make(map[string]*Player)
+	oilspouts = make(map[[2]int]*Oilspout)
+	fires = nil
+	lehmer = NewLehmer(1)
+	rng = rand.New(rand.NewSource(1))
+	resetWeather()
+	weather.Cells = [weatherCellsWide][weatherCellsHigh]float64{}
+	worldTime = worldTimeAt(0)
+	*simClock = SimClock{TicksPerSecond: defaultTicksPerSecond, Speed: 1}
+	animals = nil
+	resetPopulations()
+	pathCache.InvalidateAll()
+	rehashTiles()
+}
+
+// Generates a whole world from the seed, as the server does when it starts.
+// Runs in a temporary directory so the replay it records is thrown away, and
+// puts the world back as it was when the test ends.
+func setUpGeneratedWorld(t *testing.T, worldSeed int64) {
+	t.Helper()
+	t.Cleanup(takeWorldSnapshot(true).restore)
+	chdirTemp(t)
+	players = make(map[string]*Player)
+	*simClock = SimClock{TicksPerSecond: defaultTicksPerSecond, Speed: 1}
+	t.Cleanup(stopRecording)
+	generateWorld(worldSeed)
+	startRecording(worldSeed)
+}
+
+// Runs the rest of the test in a temporary directory
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(dir) })
+}
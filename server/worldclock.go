@@ -0,0 +1,166 @@
+// worldclock.go
+package main
+
+import (
+	"math"
+
+	"growth-protocol"
+)
+
+// World time is derived from the simulation tick. Each day has a night and a
+// day, and the seasons change every few days. The year drives the sea level,
+// vegetation and temperature.
+const (
+	ticksPerDay    = 240 // 1 minute at the default 4 ticks per second
+	daysPerSeason  = 3
+	ticksPerSeason = ticksPerDay * daysPerSeason
+	startTimeOfDay = 0.25 // worlds start at dawn on the first day of spring
+
+	diurnalSwing      = 5.0  // degrees warmer at noon and colder at midnight than the day's average
+	altitudeLapseRate = 25.0 // degrees colder per unit of altitude above the beach
+	iceTemperature    = -2.0 // shallow water freezes below this
+	snowTemperature   = 0.0  // land is covered in snow below this
+
+	vegetationGrowthRate = 0.01
+	vegetationDecayRate  = 0.002
+	startingVegetation   = 0.6
+	barrenVegetation     = 0.15 // less than this and grass dies back to dirt
+	forestVegetation     = 0.5  // forests need at least this much, or they thin out to grass
+)
+
+// Indexed like protocol.Seasons. Values are for the middle of each season
+// and blend into the next one.
+var (
+	seasonTemperatures = []float64{14, 26, 12, -2}
+	seasonGrowth       = []float64{1.2, 1, 0.4, 0.05}
+	seasonDecay        = []float64{0.8, 0.8, 1.4, 2}
+)
+
+type WorldTime struct {
+	Day          int
+	Season       int     // index into protocol.Seasons
+	TimeOfDay    float64 // 0 is midnight, 0.5 is noon
+	YearFraction float64 // 0 is the start of spring
+	Daylight     float64
+	Temperature  float64 // degrees at sea level
+	Growth       float64 // vegetation growth multiplier for the season
+	Decay        float64 // vegetation decay multiplier for the season
+}
+
+// The world time as of the current tick. Updated by stepSimulation, guarded by worldLock.
+var worldTime = worldTimeAt(0)
+
+func worldTimeAt(tick int64) WorldTime {
+	ticksPerYear := float64(ticksPerSeason * len(protocol.Seasons))
+	days := float64(tick)/ticksPerDay + startTimeOfDay
+	timeOfDay := days - math.Floor(days)
+	yearFraction := math.Mod(float64(tick), ticksPerYear) / ticksPerYear
+	daylight := math.Max(0, -math.Cos(2*math.Pi*timeOfDay))
+	return WorldTime{
+		Day:          int(days) + 1,
+		Season:       int(yearFraction * float64(len(protocol.Seasons))),
+		TimeOfDay:    timeOfDay,
+		YearFraction: yearFraction,
+		Daylight:     daylight,
+		Temperature:  seasonal(seasonTemperatures, yearFraction) - diurnalSwing*math.Cos(2*math.Pi*timeOfDay),
+		Growth:       seasonal(seasonGrowth, yearFraction),
+		Decay:        seasonal(seasonDecay, yearFraction),
+	}
+}
+
+// Smoothly blends the per-season values so there are no jumps between seasons
+func seasonal(values []float64, yearFraction float64) float64 {
+	position := yearFraction*float64(len(values)) - 0.5
+	if position < 0 {
+		position += float64(len(values))
+	}
+	current := int(position)
+	next := (current + 1) % len(values)
+	blend := position - float64(current)
+	blend = blend * blend * (3 - 2*blend)
+	return values[current]*(1-blend) + values[next]*blend
+}
+
+func (t WorldTime) state() protocol.WorldTime {
+	return protocol.WorldTime{
+		Day:         t.Day,
+		Season:      protocol.Seasons[t.Season],
+		TimeOfDay:   t.TimeOfDay,
+		Daylight:    t.Daylight,
+		Temperature: t.Temperature,
+	}
+}
+
+// High ground is colder than the coast. Returns the altitude above which
+// it is colder than temperature.
+func (t WorldTime) altitudeBelow(temperature float64) float64 {
+	if t.Temperature < temperature {
+		return math.Inf(-1)
+	}
+	return sandAltitude + (t.Temperature-temperature)/altitudeLapseRate
+}
+
+// Vegetation grows in daylight and dies back over time, both faster or
+// slower depending on the season. Only grass and forest tiles carry it, and
+// it grows best on wet, low ground near the coast. Drought kills it off faster.
+func growVegetation(tile *Tile, growth, decay, drought, snowLine float64) {
+	if tile.Altitude < sandAltitude || tile.Altitude >= forestAltitude {
+		return
+	}
+	// Oil slicks smother whatever they cover
+	tile.Vegetation -= pollutionKillRate * tile.Pollution * tile.Vegetation
+	if tile.Altitude > snowLine || tile.Water >= shallowWaterDepth {
+		// Nothing grows under snow or water
+		tile.Vegetation -= decay * tile.Vegetation
+		return
+	}
+	if tile.Scorch > ashScorch {
+		return
+	}
+	// Nutrients, mostly left by fires, speed up regrowth and are used up by it
+	fertility := 1.5 - (tile.Altitude-sandAltitude)/(forestAltitude-sandAltitude)
+	fertility *= math.Min(tile.Moisture/ambientMoisture, 2) * (1 + tile.Nutrient)
+	dryness := 1 + droughtDecayFactor*drought
+	grown := growth * fertility * (1 - tile.Vegetation)
+	tile.Vegetation += grown - decay*dryness*tile.Vegetation
+	tile.Nutrient = math.Max(tile.Nutrient-grown*nutrientUptake, 0)
+}
+
+// The tile type for the water on the tile or the ground under it, adjusted
+// for its vegetation and whether it lies above the altitudes where water
+// freezes and snow settles. Puddles only form on flat, open ground.
+func naturalTileType(tile *Tile, iceLine, snowLine float64) int {
+	tileType := waterTileType(tile)
+	if tileType == puddle || tileType < 0 {
+		land := landTileType(tile.Altitude)
+		switch land {
+		case grass:
+			if tile.Vegetation < barrenVegetation {
+				land = dirt
+			}
+		case forest:
+			if tile.Vegetation < barrenVegetation {
+				land = dirt
+			} else if tile.Vegetation < forestVegetation {
+				land = grass
+			}
+		}
+		land = successionTileType(tile, land)
+		if tileType < 0 || (land != sand && land != grass && land != dirt) {
+			tileType = land
+		}
+	}
+
+	switch tileType {
+	case deepWater, highMountains, fire:
+	case shallowWater, puddle:
+		if tile.Altitude > iceLine {
+			tileType = ice
+		}
+	default:
+		if tile.Altitude > snowLine {
+			tileType = snow
+		}
+	}
+	return tileType
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestWorldTimeAt(t *testing.T) {
+	tests := []struct {
+		name      string
+		tick      int64
+		day       int
+		season    int
+		timeOfDay float64
+		daylight  float64
+	}{
+		{"dawn of the first day", 0, 1, 0, 0.25, 0},
+		{"noon of the first day", ticksPerDay / 4, 1, 0, 0.5, 1},
+		{"midnight", 3 * ticksPerDay / 4, 2, 0, 0, 0},
+		{"the first day of summer", ticksPerSeason, daysPerSeason + 1, 1, 0.25, 0},
+		{"spring again", 4 * ticksPerSeason, 4*daysPerSeason + 1, 0, 0.25, 0},
+	}
+	for _, test := range tests {
+		now := worldTimeAt(test.tick)
+		if now.Day != test.day || now.Season != test.season {
+			t.Errorf("%s: day %d of season %d, expected day %d of season %d", test.name, now.Day, now.Season, test.day, test.season)
+		}
+		if math.Abs(now.TimeOfDay-test.timeOfDay) > 1e-9 || math.Abs(now.Daylight-test.daylight) > 1e-9 {
+			t.Errorf("%s: time of day %v with daylight %v", test.name, now.TimeOfDay, now.Daylight)
+		}
+	}
+}
+
+// Seasons blend into each other rather than changing overnight
+func TestSeasonsBlend(t *testing.T) {
+	const step = 1.0 / 1000
+	for fraction := 0.0; fraction < 1; fraction += step {
+		before, after := seasonal(seasonTemperatures, fraction), seasonal(seasonTemperatures, math.Mod(fraction+step, 1))
+		if math.Abs(after-before) > 0.2 {
+			t.Fatalf("the temperature jumped from %v to %v at %v through the year", before, after, fraction)
+		}
+	}
+	winter, summer := worldTimeAt(3*ticksPerSeason+ticksPerSeason/2), worldTimeAt(ticksPerSeason+ticksPerSeason/2)
+	if winter.Temperature >= summer.Temperature || winter.Growth >= summer.Growth || winter.Decay <= summer.Decay {
+		t.Errorf("winter is %+v and summer %+v", winter, summer)
+	}
+}
+
+func TestSnowLineFollowsTemperature(t *testing.T) {
+	warm, cold := WorldTime{Temperature: 20}, WorldTime{Temperature: 5}
+	if cold.altitudeBelow(snowTemperature) >= warm.altitudeBelow(snowTemperature) {
+		t.Error("snow settled lower on a warmer day")
+	}
+	if !math.IsInf(WorldTime{Temperature: -5}.altitudeBelow(snowTemperature), -1) {
+		t.Error("the coast isn't snowed on when it's freezing at sea level")
+	}
+}
+
+// Vegetation grows back in daylight, and dies back under snow
+func TestGrowVegetation(t *testing.T) {
+	spring := worldTimeAt(ticksPerDay / 4)
+	tile := Tile{Altitude: 0.45, Vegetation: 0.3, Moisture: ambientMoisture}
+	growVegetation(&tile, vegetationGrowthRate*spring.Growth*spring.Daylight, vegetationDecayRate*spring.Decay, 0, math.Inf(1))
+	if tile.Vegetation <= 0.3 {
+		t.Errorf("vegetation fell to %v on a spring day", tile.Vegetation)
+	}
+	snowed := Tile{Altitude: 0.45, Vegetation: 0.3, Moisture: ambientMoisture}
+	growVegetation(&snowed, vegetationGrowthRate, vegetationDecayRate, 0, 0.4)
+	if snowed.Vegetation >= 0.3 {
+		t.Errorf("vegetation grew to %v under snow", snowed.Vegetation)
+	}
+}
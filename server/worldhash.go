@@ -0,0 +1,75 @@
+// worldhash.go
+package main
+
+import "math"
+
+// A world hash sums up the state the simulation depends on, so two runs of
+// the same world can be compared. It's an FNV-1a style hash taken a word at
+// a time, which is quick enough to run over the whole map.
+const (
+	hashOffset uint64 = 14695981039346656037
+	hashPrime  uint64 = 1099511628211
+)
+
+type hasher uint64
+
+func newHasher() hasher {
+	return hasher(hashOffset)
+}
+
+func (h *hasher) word(value uint64) {
+	*h = (*h ^ hasher(value)) * hasher(hashPrime)
+}
+
+func (h *hasher) int(value int) {
+	h.word(uint64(value))
+}
+
+func (h *hasher) float(value float64) {
+	h.word(math.Float64bits(value))
+}
+
+func (h *hasher) string(value string) {
+	for i := 0; i < len(value); i++ {
+		h.word(uint64(value[i]))
+	}
+	h.word(uint64(len(value)))
+}
+
+// Hashes the tiles, animals, hives and drones. Must be called with worldLock held.
+func worldHash() uint64 {
+	h := newHasher()
+	for i := 0; i < tilesWide; i++ {
+		for j := 0; j < tilesHigh; j++ {
+			tile := &tiles[i][j]
+			h.int(tile.Type)
+			h.float(tile.Nutrient)
+			h.float(tile.Altitude)
+			h.float(tile.Vegetation)
+			h.float(tile.Moisture)
+			h.float(tile.Water)
+			h.float(tile.Pollution)
+			h.float(tile.Fire)
+			h.float(tile.Scorch)
+		}
+	}
+	for _, a := range animals {
+		h.string(a.Kind)
+		h.int(a.X)
+		h.int(a.Y)
+		h.float(a.Energy)
+	}
+	for _, hive := range hives {
+		// Players without a hive can't have done anything yet
+		h.string(hive.Owner)
+		for _, resource := range resourceTypes {
+			h.int(hive.Player.Inventory[resource])
+		}
+		for _, drone := range hive.Drones {
+			h.int(drone.X)
+			h.int(drone.Y)
+			h.int(drone.State)
+		}
+	}
+	return uint64(h)
+}